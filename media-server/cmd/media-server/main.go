@@ -5,10 +5,14 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/romashorodok/conferencing-platform/media-server/internal/chat"
 	"github.com/romashorodok/conferencing-platform/media-server/internal/identity"
 	"github.com/romashorodok/conferencing-platform/media-server/internal/mcu"
 	"github.com/romashorodok/conferencing-platform/media-server/internal/pipeline"
 	"github.com/romashorodok/conferencing-platform/media-server/internal/room"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/egress"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/ingest"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/recorder"
 	"github.com/romashorodok/conferencing-platform/media-server/pkg/service"
 	"github.com/romashorodok/conferencing-platform/media-server/pkg/sfu"
 	globalprotocol "github.com/romashorodok/conferencing-platform/pkg/protocol"
@@ -47,11 +51,48 @@ func CreateAdminUser(params CreateAdminUser_Params) {
 	_, _ = params.IdentityService.SignUp(context.Background(), "test", "test")
 }
 
-var _ sfu.Pipeline = (*pipeline.CannyFilter)(nil)
+var _ chat.RoomMembership = (*room.RoomService)(nil)
+
+func NewChatRoomMembership(roomService *room.RoomService) chat.RoomMembership {
+	return roomService
+}
+
+var (
+	_ sfu.Pipeline   = (*pipeline.CannyFilter)(nil)
+	_ sfu.AudioMixer = (*pipeline.AudioMixer)(nil)
+)
 
 func NewPipelinesAllocatorsContext() *sfu.AllocatorsContext {
 	allocContext := sfu.NewAllocatorsContext()
 	allocContext.Register(sfu.FILTER_RTP_CANNY_FILTER, pipeline.NewCannyFilter)
+	allocContext.RegisterAudioMixer(pipeline.NewAudioMixer)
+	return allocContext
+}
+
+var _ recorder.Recorder = (*pipeline.CompositeRecording)(nil)
+
+func NewRecorderAllocatorsContext() *recorder.AllocatorsContext {
+	allocContext := recorder.NewAllocatorsContext()
+	allocContext.Register(recorder.MODE_COMPOSITE, pipeline.NewCompositeRecording)
+	return allocContext
+}
+
+var _ egress.Egress = (*pipeline.StreamEgress)(nil)
+
+func NewEgressAllocatorsContext() *egress.AllocatorsContext {
+	allocContext := egress.NewAllocatorsContext()
+	allocContext.Register(egress.PROTOCOL_RTMP, pipeline.NewStreamEgress)
+	allocContext.Register(egress.PROTOCOL_SRT, pipeline.NewStreamEgress)
+	allocContext.Register(egress.PROTOCOL_HLS, pipeline.NewStreamEgress)
+	return allocContext
+}
+
+var _ ingest.Ingest = (*pipeline.SourceIngest)(nil)
+
+func NewIngestAllocatorsContext() *ingest.AllocatorsContext {
+	allocContext := ingest.NewAllocatorsContext()
+	allocContext.Register(ingest.PROTOCOL_RTSP, pipeline.NewSourceIngest)
+	allocContext.Register(ingest.PROTOCOL_RTP, pipeline.NewSourceIngest)
 	return allocContext
 }
 
@@ -65,6 +106,9 @@ func main() {
 	fx.New(
 		fx.Provide(
 			NewPipelinesAllocatorsContext,
+			NewRecorderAllocatorsContext,
+			NewEgressAllocatorsContext,
+			NewIngestAllocatorsContext,
 
 			room.NewRoomService,
 			room.NewRoomNotifier,
@@ -72,8 +116,12 @@ func main() {
 			identity.NewTokenService,
 			identity.NewIdentityService,
 
+			chat.NewChatService,
+			NewChatRoomMembership,
+
 			globalprotocol.AsHttpController(room.NewRoomController),
 			globalprotocol.AsHttpController(identity.NewIdentityController),
+			globalprotocol.AsHttpController(chat.NewChatController),
 		),
 
 		fx.Module("test-room",
@@ -12,6 +12,7 @@ require (
 	github.com/pion/rtcp v1.2.12
 	github.com/pion/rtp v1.8.5
 	github.com/pion/sdp/v3 v3.0.9
+	github.com/pion/srtp/v3 v3.0.1
 	github.com/pion/webrtc/v3 v3.2.36
 	github.com/pion/webrtc/v4 v4.0.0-beta.7
 	go.uber.org/atomic v1.7.0
@@ -42,7 +43,6 @@ require (
 	github.com/pion/randutil v0.1.0 // indirect
 	github.com/pion/sctp v1.8.14 // indirect
 	github.com/pion/srtp/v2 v2.0.18 // indirect
-	github.com/pion/srtp/v3 v3.0.1 // indirect
 	github.com/pion/stun v0.6.1 // indirect
 	github.com/pion/stun/v2 v2.0.0 // indirect
 	github.com/pion/transport v0.14.1 // indirect
@@ -0,0 +1,95 @@
+package chat
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/romashorodok/conferencing-platform/media-server/internal/identity"
+	globalprotocol "github.com/romashorodok/conferencing-platform/pkg/protocol"
+	"go.uber.org/fx"
+)
+
+type errResponse struct {
+	Message string `json:"message"`
+}
+
+// Room side knows who joined the room, chat only stores messages.
+// Instance id is made on the room creation, chat history of the room don't outlive it
+type RoomMembership interface {
+	RoomInstance(roomID string, userID uuid.UUID) (instanceID uuid.UUID, member bool)
+}
+
+type chatController struct {
+	chatService     *ChatService
+	identityService *identity.IdentityService
+	membership      RoomMembership
+}
+
+// Query: cursor from the previous page, limit up to 100. History is visible only to the room participants
+func (ctrl *chatController) ChatHistory(c echo.Context) error {
+	roomID := c.Param("room_id")
+
+	token := identity.WithTokenContext(c)
+	instanceID, member := ctrl.membership.RoomInstance(roomID, token.UserID)
+	if !member {
+		return c.JSON(http.StatusForbidden, &errResponse{
+			Message: ErrNotRoomMember.Error(),
+		})
+	}
+
+	limit := _HISTORY_MAX_LIMIT
+	if value := c.QueryParam("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil {
+			return c.JSON(http.StatusBadRequest, &errResponse{
+				Message: "limit must be a number",
+			})
+		}
+	}
+
+	history, err := ctrl.chatService.History(c.Request().Context(), instanceID, c.QueryParam("cursor"), limit)
+	if err != nil {
+		log.Println("[ChatHistory] Unable get history. Err:", err)
+		if errors.Is(err, ErrInvalidCursor) {
+			return c.JSON(http.StatusBadRequest, &errResponse{
+				Message: err.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, &errResponse{
+			Message: "Unable get chat history",
+		})
+	}
+
+	return c.JSON(http.StatusOK, history)
+}
+
+func (ctrl *chatController) Resolve(router *echo.Echo) error {
+	middlewares := []echo.MiddlewareFunc{
+		echo.MiddlewareFunc(identity.IdentityWallFactoryMiddleware(ctrl.identityService)),
+	}
+
+	router.GET("/rooms/:room_id/chat", ctrl.ChatHistory, middlewares...)
+	return nil
+}
+
+var _ globalprotocol.HttpResolvable = (*chatController)(nil)
+
+type newChatControllerParams struct {
+	fx.In
+
+	ChatService     *ChatService
+	IdentityService *identity.IdentityService
+	Membership      RoomMembership
+}
+
+func NewChatController(params newChatControllerParams) *chatController {
+	return &chatController{
+		chatService:     params.ChatService,
+		identityService: params.IdentityService,
+		membership:      params.Membership,
+	}
+}
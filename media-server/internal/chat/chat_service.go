@@ -0,0 +1,182 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/romashorodok/conferencing-platform/media-server/internal/storage"
+	"go.uber.org/fx"
+)
+
+var (
+	ErrMessageEmpty    = errors.New("chat message is empty")
+	ErrMessageTooLarge = errors.New("chat message is too large")
+	ErrInvalidCursor   = errors.New("invalid chat history cursor")
+	ErrNotRoomMember   = errors.New("user is not a participant of the room")
+)
+
+const (
+	_MESSAGE_MAX_LENGTH = 2000
+
+	// Late joiner receives this count of the last messages
+	HISTORY_ON_JOIN     = 50
+	_HISTORY_MAX_LIMIT  = 100
+	_HISTORY_CURSOR_SEP = "_"
+)
+
+// NOTE: Messages with the same time as the cursor are compared by id
+var _maxUUID = uuid.MustParse("ffffffff-ffff-ffff-ffff-ffffffffffff")
+
+type Message struct {
+	ID        uuid.UUID `json:"id"`
+	RoomID    string    `json:"roomId"`
+	UserID    uuid.UUID `json:"userId"`
+	Username  string    `json:"username"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Messages are newest first. Cursor points to the last one, empty cursor when there is nothing more
+type History struct {
+	Messages []Message `json:"messages"`
+	Cursor   string    `json:"cursor"`
+}
+
+type ChatService struct {
+	queries *storage.Queries
+}
+
+// Messages belong to the room instance, room created again with the same id don't see them
+func (s *ChatService) Send(ctx context.Context, roomID string, instanceID uuid.UUID, userID uuid.UUID, body string) (*Message, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return nil, ErrMessageEmpty
+	}
+	if utf8.RuneCountInString(body) > _MESSAGE_MAX_LENGTH {
+		return nil, ErrMessageTooLarge
+	}
+
+	user, err := s.queries.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	row, err := s.queries.NewChatMessage(ctx, storage.NewChatMessageParams{
+		RoomID:         roomID,
+		RoomInstanceID: instanceID,
+		UserID:         userID,
+		Body:           body,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		ID:        row.ID,
+		RoomID:    roomID,
+		UserID:    userID,
+		Username:  user.Username,
+		Body:      body,
+		CreatedAt: row.CreatedAt,
+	}, nil
+}
+
+// Page of the room instance messages older than cursor. Empty cursor is the newest page
+func (s *ChatService) History(ctx context.Context, instanceID uuid.UUID, cursor string, limit int) (*History, error) {
+	if limit <= 0 || limit > _HISTORY_MAX_LIMIT {
+		limit = _HISTORY_MAX_LIMIT
+	}
+
+	before, beforeID, err := parseCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.queries.GetChatMessages(ctx, storage.GetChatMessagesParams{
+		RoomInstanceID: instanceID,
+		Before:         before,
+		BeforeID:       beforeID,
+		Lim:            int32(limit),
+	})
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	history := &History{
+		Messages: make([]Message, 0, len(rows)),
+	}
+	for _, row := range rows {
+		history.Messages = append(history.Messages, Message{
+			ID:        row.ID,
+			RoomID:    row.RoomID,
+			UserID:    row.UserID,
+			Username:  row.Username,
+			Body:      row.Body,
+			CreatedAt: row.CreatedAt,
+		})
+	}
+
+	if len(rows) == limit {
+		last := rows[len(rows)-1]
+		history.Cursor = formatCursor(last.CreatedAt, last.ID)
+	}
+	return history, nil
+}
+
+// Last messages of the room instance in chronological order
+func (s *ChatService) Recent(ctx context.Context, instanceID uuid.UUID) ([]Message, error) {
+	history, err := s.History(ctx, instanceID, "", HISTORY_ON_JOIN)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := history.Messages
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+func formatCursor(createdAt time.Time, id uuid.UUID) string {
+	return fmt.Sprintf("%d%s%s", createdAt.UnixMicro(), _HISTORY_CURSOR_SEP, id)
+}
+
+func parseCursor(cursor string) (time.Time, uuid.UUID, error) {
+	if cursor == "" {
+		return time.Now().Add(time.Minute), _maxUUID, nil
+	}
+
+	createdAt, id, found := strings.Cut(cursor, _HISTORY_CURSOR_SEP)
+	if !found {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+
+	var micro int64
+	if _, err := fmt.Sscan(createdAt, &micro); err != nil {
+		return time.Time{}, uuid.Nil, errors.Join(ErrInvalidCursor, err)
+	}
+
+	beforeID, err := uuid.Parse(id)
+	if err != nil {
+		return time.Time{}, uuid.Nil, errors.Join(ErrInvalidCursor, err)
+	}
+	return time.UnixMicro(micro), beforeID, nil
+}
+
+type NewChatServiceParams struct {
+	fx.In
+
+	Queries *storage.Queries
+}
+
+func NewChatService(params NewChatServiceParams) *ChatService {
+	return &ChatService{
+		queries: params.Queries,
+	}
+}
@@ -0,0 +1,46 @@
+package chat
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	// NOTE: Postgres keeps microseconds, cursor of the stored message must point exactly to it
+	createdAt := time.Date(2024, 3, 1, 12, 30, 0, 123456000, time.UTC)
+	id := uuid.New()
+
+	before, beforeID, err := parseCursor(formatCursor(createdAt, id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !before.Equal(createdAt) || beforeID != id {
+		t.Fatalf("cursor %s %s, want %s %s", before, beforeID, createdAt, id)
+	}
+}
+
+func TestCursorEmpty(t *testing.T) {
+	before, beforeID, err := parseCursor("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !before.After(time.Now()) || beforeID != _maxUUID {
+		t.Fatalf("cursor %s %s, want the newest page", before, beforeID)
+	}
+}
+
+func TestCursorInvalid(t *testing.T) {
+	for _, cursor := range []string{
+		"1709296200123456",
+		"now_" + uuid.NewString(),
+		"1709296200123456_not-uuid",
+		"_",
+	} {
+		if _, _, err := parseCursor(cursor); !errors.Is(err, ErrInvalidCursor) {
+			t.Fatalf("cursor %q err %v, want %v", cursor, err, ErrInvalidCursor)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"log"
+	"slices"
 
 	"github.com/google/uuid"
 	"github.com/lestrrat-go/jwx/v2/jwk"
@@ -169,6 +170,14 @@ type TokenContext struct {
 	pkeyJwsMessage string
 }
 
+func (t *TokenContext) HasClaim(claim string) bool {
+	return slices.Contains(t.Aud, claim)
+}
+
+func (t *TokenContext) IsAdmin() bool {
+	return t.TokenUse == ACCESS_TOKEN && t.HasClaim(ADMIN_CLAIM)
+}
+
 func (s *IdentityService) TokenIdentity(ctx context.Context, insecureToken string) (*TokenContext, error) {
 	untrustJws, err := jws.Parse([]byte(insecureToken))
 	if err != nil {
@@ -2,6 +2,8 @@ package identity
 
 import (
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -9,6 +11,7 @@ import (
 	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/lestrrat-go/jwx/v2/jws"
 	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/variables"
 )
 
 const (
@@ -17,6 +20,9 @@ const (
 
 	ACCESS_TOKEN  = "access_token"
 	REFRESH_TOKEN = "refresh_token"
+
+	// Server wide control, e.g. recording or forwarding of any room
+	ADMIN_CLAIM = "can:admin"
 )
 
 var (
@@ -50,14 +56,24 @@ func signToken(pkeyJwsMessage string, headers jws.Headers, token jwt.Token) (str
 	return string(byteToken), nil
 }
 
-type TokenService struct{}
+type TokenService struct {
+	admins []string
+}
+
+// NOTE: Admin claim is only in the access token, refreshed token picks up the current admin list
+func (s *TokenService) claims(user *User) []string {
+	if slices.Contains(s.admins, user.Username) {
+		return append(slices.Clone(_DEFAULT_CLAIMS), ADMIN_CLAIM)
+	}
+	return _DEFAULT_CLAIMS
+}
 
 func (s *TokenService) CreateAccessToken(user *User, pkeyID uuid.UUID, pkeyJwsMessage string) (string, error) {
 	expiresAt := time.Now().Add(_ACCESS_TOKEN_EXPIRES_AFTER)
 
 	b := jwt.NewBuilder().
 		Issuer(_ISSUER).
-		Audience(_DEFAULT_CLAIMS).
+		Audience(s.claims(user)).
 		Subject(user.Username).
 		Expiration(expiresAt)
 
@@ -113,5 +129,13 @@ func (s *TokenService) CreateRefreshToken(user *User, pkeyID uuid.UUID, pkeyJwsM
 }
 
 func NewTokenService() *TokenService {
-	return &TokenService{}
+	var admins []string
+	for _, username := range strings.Split(variables.Env(variables.ADMIN_USERS, variables.ADMIN_USERS_DEFAULT), ",") {
+		if username = strings.TrimSpace(username); username != "" {
+			admins = append(admins, username)
+		}
+	}
+	return &TokenService{
+		admins: admins,
+	}
 }
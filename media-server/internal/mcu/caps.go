@@ -21,6 +21,18 @@ func NewRtpVP8Caps(clockRate uint32) string {
 	return fmt.Sprintf("application/x-rtp, media=(string)video, payload=(int)96, clock-rate=(int)%d, encoding-name=(string)VP8-DRAFT-IETF-01", clockRate)
 }
 
+func NewRtpVP9Caps(clockRate uint32) string {
+	return fmt.Sprintf("application/x-rtp, media=(string)video, payload=(int)98, clock-rate=(int)%d, encoding-name=(string)VP9", clockRate)
+}
+
+func NewRtpH264Caps(clockRate uint32) string {
+	return fmt.Sprintf("application/x-rtp, media=(string)video, payload=(int)102, clock-rate=(int)%d, encoding-name=(string)H264", clockRate)
+}
+
+func NewRtpOpusCaps(clockRate uint32) string {
+	return fmt.Sprintf("application/x-rtp, media=(string)audio, payload=(int)111, clock-rate=(int)%d, encoding-name=(string)OPUS", clockRate)
+}
+
 func CapsFromString(caps string) *GstCaps {
 	c := (*C.gchar)(unsafe.Pointer(C.CString(caps)))
 	defer C.g_free(C.gpointer(unsafe.Pointer(c)))
@@ -0,0 +1,118 @@
+package mcu
+
+// #cgo pkg-config: gstreamer-full-1.0
+// #include <gst/gst.h>
+// #cgo pkg-config: media-server-mcu
+// #include <main.h>
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"unsafe"
+)
+
+type GstPad struct {
+	pad *C.GstPad
+}
+
+// Request pad of the aggregator like compositor or audiomixer, e.g. template "sink_%u"
+func ElementRequestPad(elem *GstElement, template string) (*GstPad, error) {
+	CTemplate := (*C.gchar)(unsafe.Pointer(C.CString(template)))
+	defer C.g_free(C.gpointer(unsafe.Pointer(CTemplate)))
+
+	pad := C.MCU_gst_element_request_pad(elem.element, CTemplate)
+	if pad == nil {
+		return nil, errors.New(fmt.Sprintf("could not request a pad template %s", template))
+	}
+	return &GstPad{pad: pad}, nil
+}
+
+func ElementReleasePad(elem *GstElement, pad *GstPad) {
+	C.MCU_gst_element_release_pad(elem.element, pad.pad)
+}
+
+// Link static src pad of the element to the sink pad
+func ElementLinkPad(src *GstElement, sink *GstPad) bool {
+	res := C.MCU_gst_element_link_pad(src.element, sink.pad)
+	if res == C.TRUE {
+		return true
+	}
+	return false
+}
+
+func ElementUnlinkPad(src *GstElement, sink *GstPad) {
+	C.MCU_gst_element_unlink_pad(src.element, sink.pad)
+}
+
+// Link pads of the source which appear later, e.g. decodebin. Pad is linked to the sink only when caps fit
+func ElementLinkDynamic(src, sink *GstElement) {
+	C.MCU_gst_element_link_dynamic(src.element, sink.element)
+}
+
+func PadSetInt(pad *GstPad, pName string, pValue int) {
+	CpName := (*C.gchar)(unsafe.Pointer(C.CString(pName)))
+	defer C.g_free(C.gpointer(unsafe.Pointer(CpName)))
+
+	C.MCU_gst_pad_set_int(pad.pad, CpName, C.gint(pValue))
+}
+
+// Element added into running pipeline must catch up its state
+func ElementSyncStateWithParent(elem *GstElement) bool {
+	res := C.MCU_gst_element_sync_state(elem.element)
+	if res == C.TRUE {
+		return true
+	}
+	return false
+}
+
+// NOTE: Bin drops its reference, element must not be used after it
+func BinRemoveMany(p *GstElement, elements ...*GstElement) {
+	for _, e := range elements {
+		if e != nil {
+			C.MCU_gst_bin_remove(p.element, e.element)
+		}
+	}
+}
+
+func ElementSendEOS(elem *GstElement) bool {
+	res := C.MCU_gst_element_send_eos(elem.element)
+	if res == C.TRUE {
+		return true
+	}
+	return false
+}
+
+// Returns false on pipeline error or timeout
+func PipelineWaitEOS(pipe *GstElement, timeout time.Duration) bool {
+	res := C.MCU_gst_pipeline_wait_eos(pipe.element, C.GstClockTime(timeout.Nanoseconds()))
+	if res == C.TRUE {
+		return true
+	}
+	return false
+}
+
+type BusMessage int
+
+const (
+	BusMessageNone BusMessage = iota
+	BusMessageEOS
+	BusMessageError
+)
+
+// Wait for eos or error of the pipeline. Error of the element comes with BusMessageError
+func PipelinePopMessage(pipe *GstElement, timeout time.Duration) (BusMessage, error) {
+	var CErrorMessage *C.gchar
+	res := C.MCU_gst_pipeline_pop_message(pipe.element, C.GstClockTime(timeout.Nanoseconds()), &CErrorMessage)
+
+	switch BusMessage(res) {
+	case BusMessageEOS:
+		return BusMessageEOS, nil
+	case BusMessageError:
+		defer C.g_free(C.gpointer(unsafe.Pointer(CErrorMessage)))
+		return BusMessageError, errors.New(C.GoString((*C.char)(unsafe.Pointer(CErrorMessage))))
+	default:
+		return BusMessageNone, nil
+	}
+}
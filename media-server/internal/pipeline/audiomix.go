@@ -0,0 +1,191 @@
+package pipeline
+
+import (
+	"C"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	webrtc "github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+	"github.com/romashorodok/conferencing-platform/media-server/internal/mcu"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/sfu"
+)
+
+const (
+	// NOTE: Default frame size of opusenc
+	_AUDIO_MIX_FRAME = 20 * time.Millisecond
+	// Mix is meant for the poor connections
+	_AUDIO_MIX_BITRATE = 32000
+)
+
+var ErrAudioMixStart = errors.New("could not start audio mix pipeline")
+
+// Room audio of all participants except one, re-encoded into the output track of the subscriber
+type AudioMixer struct {
+	output        *sfu.TrackContext
+	excludePeerID string
+	caps          *mcu.GstCaps
+
+	pipe       *mcu.GstElement
+	audioMixer *mcu.GstElement
+	appSink    *mcu.GstElement
+
+	branchesMu sync.Mutex
+	branches   map[*sfu.TrackContext]*mixerBranch
+	closed     bool
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func (m *AudioMixer) TrackPublished(t *sfu.TrackContext) {
+	if t.Kind() != webrtc.RTPCodecTypeAudio || t.SourcePeerID == m.excludePeerID {
+		return
+	}
+
+	m.branchesMu.Lock()
+	defer m.branchesMu.Unlock()
+
+	if m.closed {
+		return
+	}
+	if _, exist := m.branches[t]; exist {
+		return
+	}
+
+	branch, err := newMixerBranch(m.pipe, m.audioMixer, t, "")
+	if err != nil {
+		log.Printf("[AudioMix] %s unable mix track %s. Err: %s", m.output.ID(), t.ID(), err)
+		return
+	}
+	m.branches[t] = branch
+
+	go branch.run()
+	branch.downTrack = t.AddSink(branch)
+}
+
+func (m *AudioMixer) TrackUnpublished(t *sfu.TrackContext) {
+	m.branchesMu.Lock()
+	defer m.branchesMu.Unlock()
+
+	branch, exist := m.branches[t]
+	if !exist {
+		return
+	}
+	delete(m.branches, t)
+	branch.Stop()
+	branch.Remove(m.pipe)
+}
+
+func (m *AudioMixer) Start() error {
+	if mcu.ElementSetState(m.pipe, mcu.StatePlaying) == mcu.StateChangeReturnFailure {
+		// NOTE: Samples are not pulled, close must not wait for it
+		close(m.stopped)
+		return ErrAudioMixStart
+	}
+	go m.handleSample()
+	return nil
+}
+
+func (m *AudioMixer) handleSample() {
+	defer close(m.stopped)
+
+	for {
+		select {
+		case <-m.done:
+			return
+		default:
+		}
+
+		sample, err := mcu.AppSinkPullSample(m.appSink)
+		if err != nil {
+			if mcu.AppSinkIsEOS(m.appSink) {
+				return
+			}
+			continue
+		}
+
+		w, err := m.output.GetTrackRemoteWriterSample()
+		if err == nil {
+			err = w.WriteRemote(media.Sample{
+				Data:     C.GoBytes(sample.Buff, C.int(sample.Size)),
+				Duration: _AUDIO_MIX_FRAME,
+			})
+		}
+		sample.Deinit()
+		if err != nil {
+			log.Printf("[AudioMix] %s unable write sample. Err: %s", m.output.ID(), err)
+		}
+	}
+}
+
+func (m *AudioMixer) Close() error {
+	m.branchesMu.Lock()
+	if m.closed {
+		m.branchesMu.Unlock()
+		return nil
+	}
+	m.closed = true
+	branches := m.branches
+	m.branches = make(map[*sfu.TrackContext]*mixerBranch)
+	m.branchesMu.Unlock()
+
+	for _, branch := range branches {
+		branch.Stop()
+	}
+
+	close(m.done)
+	// NOTE: Pulling of the sample is released on the state change
+	_ = mcu.ElementSetState(m.pipe, mcu.StateNull)
+	<-m.stopped
+
+	mcu.ElementDeinit(m.pipe)
+	return nil
+}
+
+var _ sfu.AudioMixer = (*AudioMixer)(nil)
+
+func NewAudioMixer(output *sfu.TrackContext, excludePeerID string) (sfu.AudioMixer, error) {
+	m := &AudioMixer{
+		output:        output,
+		excludePeerID: excludePeerID,
+		caps:          mcu.CapsFromString("audio/x-raw, rate=(int)48000, channels=(int)2"),
+		branches:      make(map[*sfu.TrackContext]*mixerBranch),
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+
+	pipe, err := mcu.PipelineNew("")
+	if err != nil {
+		return nil, err
+	}
+	m.pipe = pipe
+
+	elements, err := makeMany("audiotestsrc", "capsfilter", "audiomixer", "audioconvert", "audioresample", "queue", "opusenc", "appsink")
+	if err != nil {
+		pipelineDeinit(pipe)
+		return nil, err
+	}
+	mcu.BinAddMany(pipe, elements...)
+
+	m.audioMixer = elements[2]
+	m.appSink = elements[7]
+
+	// NOTE: Silence keeps the mixer running when nobody is talking
+	mcu.ObjectSet(elements[0], "is-live", true)
+	mcu.ObjectSet(elements[0], "wave", 4)
+	mcu.ObjectSet(elements[1], "caps", m.caps)
+	mcu.ObjectSet(elements[6], "bitrate", _AUDIO_MIX_BITRATE)
+	mcu.ObjectSet(m.appSink, "sync", false)
+	mcu.ObjectSet(m.appSink, "drop", true)
+	mcu.ObjectSet(m.appSink, "max-buffers", uint32(10))
+	setQueueBufferSize(elements[5])
+
+	if err = linkMany(elements...); err != nil {
+		pipelineDeinit(pipe)
+		return nil, err
+	}
+	return m, nil
+}
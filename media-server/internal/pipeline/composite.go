@@ -0,0 +1,368 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"path/filepath"
+	"sync"
+	"time"
+
+	webrtc "github.com/pion/webrtc/v4"
+	"github.com/romashorodok/conferencing-platform/media-server/internal/mcu"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/recorder"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/sfu"
+)
+
+const (
+	_COMPOSITE_WIDTH     = 1280
+	_COMPOSITE_HEIGHT    = 720
+	_COMPOSITE_FRAMERATE = 30
+
+	_COMPOSITE_FILE = "composite.webm"
+
+	// NOTE: Muxer must get eos to write the index, otherwise file is not seekable
+	_COMPOSITE_EOS_TIMEOUT = 5 * time.Second
+)
+
+var ErrCompositeStart = errors.New("could not start composite pipeline")
+
+type compositeRect struct {
+	X, Y, Width, Height int
+}
+
+// Grid of the video tracks. Incomplete last row is centered
+func compositeLayout(n, width, height int) []compositeRect {
+	if n == 0 {
+		return nil
+	}
+
+	cols := int(math.Ceil(math.Sqrt(float64(n))))
+	rows := (n + cols - 1) / cols
+	cellWidth := width / cols
+	cellHeight := height / rows
+
+	result := make([]compositeRect, 0, n)
+	for i := 0; i < n; i++ {
+		row, col := i/cols, i%cols
+
+		inRow := cols
+		if rest := n - row*cols; rest < cols {
+			inRow = rest
+		}
+		offset := (width - inRow*cellWidth) / 2
+
+		result = append(result, compositeRect{
+			X:      offset + col*cellWidth,
+			Y:      row * cellHeight,
+			Width:  cellWidth,
+			Height: cellHeight,
+		})
+	}
+	return result
+}
+
+var ErrCompositeStopped = errors.New("composite is stopped")
+
+// Room tracks mixed into one video and one audio. Video tracks are laid out by compositor, audio is mixed by audiomixer.
+// Owner links encoders to the outputs and sets the pipeline state
+type compositeMixer struct {
+	// NOTE: Output where tracks are recorded, empty when mix is not a file
+	file string
+
+	videoCaps *mcu.GstCaps
+	audioCaps *mcu.GstCaps
+
+	pipe       *mcu.GstElement
+	compositor *mcu.GstElement
+	audioMixer *mcu.GstElement
+	videoOut   *mcu.GstElement
+	audioOut   *mcu.GstElement
+
+	branchesMu sync.Mutex
+	branches   map[*sfu.TrackContext]*mixerBranch
+	// NOTE: Order of the video cells in the layout
+	videoOrder []*sfu.TrackContext
+	finished   []recorder.ManifestTrack
+	stopped    bool
+}
+
+func (c *compositeMixer) AddTrack(t *sfu.TrackContext) error {
+	c.branchesMu.Lock()
+	defer c.branchesMu.Unlock()
+
+	if c.stopped {
+		return ErrCompositeStopped
+	}
+	if _, exist := c.branches[t]; exist {
+		return nil
+	}
+
+	mixer := c.audioMixer
+	if t.Kind() == webrtc.RTPCodecTypeVideo {
+		mixer = c.compositor
+	}
+
+	branch, err := newMixerBranch(c.pipe, mixer, t, c.file)
+	if err != nil {
+		return err
+	}
+	c.branches[t] = branch
+	if t.Kind() == webrtc.RTPCodecTypeVideo {
+		c.videoOrder = append(c.videoOrder, t)
+		c.relayout()
+	}
+
+	go branch.run()
+	branch.downTrack = t.AddSink(branch)
+	return nil
+}
+
+// Branch is unlinked from the running pipeline, the entry stays in the manifest
+func (c *compositeMixer) RemoveTrack(t *sfu.TrackContext) {
+	c.branchesMu.Lock()
+	defer c.branchesMu.Unlock()
+
+	branch, exist := c.branches[t]
+	if !exist {
+		return
+	}
+	delete(c.branches, t)
+	c.finished = append(c.finished, branch.Stop())
+	branch.Remove(c.pipe)
+
+	for i, video := range c.videoOrder {
+		if video == t {
+			c.videoOrder = append(c.videoOrder[:i], c.videoOrder[i+1:]...)
+			c.relayout()
+			break
+		}
+	}
+}
+
+func (c *compositeMixer) relayout() {
+	layout := compositeLayout(len(c.videoOrder), _COMPOSITE_WIDTH, _COMPOSITE_HEIGHT)
+	for i, video := range c.videoOrder {
+		pad := c.branches[video].pad
+		// NOTE: Background is the first pad
+		mcu.PadSetInt(pad, "zorder", i+1)
+		mcu.PadSetInt(pad, "xpos", layout[i].X)
+		mcu.PadSetInt(pad, "ypos", layout[i].Y)
+		mcu.PadSetInt(pad, "width", layout[i].Width)
+		mcu.PadSetInt(pad, "height", layout[i].Height)
+	}
+}
+
+// Stop feeding of all branches. Returns false when it's already stopped
+func (c *compositeMixer) Stop() ([]recorder.ManifestTrack, bool) {
+	c.branchesMu.Lock()
+	if c.stopped {
+		c.branchesMu.Unlock()
+		return nil, false
+	}
+	c.stopped = true
+	branches := c.branches
+	c.branches = make(map[*sfu.TrackContext]*mixerBranch)
+	entries := append([]recorder.ManifestTrack(nil), c.finished...)
+	c.branchesMu.Unlock()
+
+	for _, branch := range branches {
+		entries = append(entries, branch.Stop())
+	}
+	return entries, true
+}
+
+// Live test sources keep mixers running when the room has no tracks
+func newCompositeMixer(file string) (*compositeMixer, error) {
+	c := &compositeMixer{
+		file:     file,
+		branches: make(map[*sfu.TrackContext]*mixerBranch),
+		videoCaps: mcu.CapsFromString(fmt.Sprintf(
+			"video/x-raw, width=(int)%d, height=(int)%d, framerate=(fraction)%d/1",
+			_COMPOSITE_WIDTH, _COMPOSITE_HEIGHT, _COMPOSITE_FRAMERATE,
+		)),
+		audioCaps: mcu.CapsFromString("audio/x-raw, rate=(int)48000, channels=(int)2"),
+	}
+
+	pipe, err := mcu.PipelineNew("")
+	if err != nil {
+		return nil, err
+	}
+	c.pipe = pipe
+
+	video, err := makeMany("videotestsrc", "capsfilter", "compositor", "videoconvert", "queue")
+	if err != nil {
+		pipelineDeinit(pipe)
+		return nil, err
+	}
+	mcu.BinAddMany(pipe, video...)
+
+	audio, err := makeMany("audiotestsrc", "capsfilter", "audiomixer", "audioconvert", "audioresample", "queue")
+	if err != nil {
+		pipelineDeinit(pipe)
+		return nil, err
+	}
+	mcu.BinAddMany(pipe, audio...)
+
+	c.compositor = video[2]
+	c.audioMixer = audio[2]
+	c.videoOut = video[len(video)-1]
+	c.audioOut = audio[len(audio)-1]
+
+	mcu.ObjectSet(video[0], "is-live", true)
+	// NOTE: Black pattern
+	mcu.ObjectSet(video[0], "pattern", 2)
+	mcu.ObjectSet(video[1], "caps", c.videoCaps)
+	mcu.ObjectSet(c.compositor, "background", 1)
+
+	mcu.ObjectSet(audio[0], "is-live", true)
+	// NOTE: Silence wave
+	mcu.ObjectSet(audio[0], "wave", 4)
+	mcu.ObjectSet(audio[1], "caps", c.audioCaps)
+
+	setQueueBufferSize(c.videoOut)
+	setQueueBufferSize(c.audioOut)
+
+	if err = linkMany(video...); err != nil {
+		pipelineDeinit(pipe)
+		return nil, err
+	}
+	if err = linkMany(audio...); err != nil {
+		pipelineDeinit(pipe)
+		return nil, err
+	}
+	return c, nil
+}
+
+// Single file recording of the room
+type CompositeRecording struct {
+	id        string
+	roomID    string
+	dir       string
+	startedAt time.Time
+
+	mixer *compositeMixer
+}
+
+func (c *CompositeRecording) ID() string {
+	return c.id
+}
+
+func (c *CompositeRecording) Mode() recorder.Mode {
+	return recorder.MODE_COMPOSITE
+}
+
+func (c *CompositeRecording) StartedAt() time.Time {
+	return c.startedAt
+}
+
+func (c *CompositeRecording) Dir() string {
+	return c.dir
+}
+
+func (c *CompositeRecording) TrackPublished(t *sfu.TrackContext) {
+	if err := c.mixer.AddTrack(t); err != nil {
+		log.Printf("[Composite] recording %s unable add track %s. Err: %s", c.id, t.ID(), err)
+		return
+	}
+	log.Printf("[Composite] recording %s start track %s", c.id, t.ID())
+}
+
+func (c *CompositeRecording) TrackUnpublished(t *sfu.TrackContext) {
+	c.mixer.RemoveTrack(t)
+}
+
+func (c *CompositeRecording) Stop() (*recorder.Manifest, error) {
+	entries, ok := c.mixer.Stop()
+	if !ok {
+		return nil, recorder.ErrRecordingStopped
+	}
+
+	if !mcu.ElementSendEOS(c.mixer.pipe) || !mcu.PipelineWaitEOS(c.mixer.pipe, _COMPOSITE_EOS_TIMEOUT) {
+		log.Printf("[Composite] recording %s eos is not reached, file may be not finalized", c.id)
+	}
+	pipelineDeinit(c.mixer.pipe)
+
+	manifest := &recorder.Manifest{
+		RecordingID: c.id,
+		RoomID:      c.roomID,
+		Mode:        recorder.MODE_COMPOSITE,
+		StartedAt:   c.startedAt,
+		StoppedAt:   time.Now(),
+		File:        _COMPOSITE_FILE,
+	}
+	manifest.SetTracks(entries)
+	if err := manifest.Write(c.dir); err != nil {
+		return nil, err
+	}
+
+	log.Printf("[Composite] recording %s stopped. Tracks: %d", c.id, len(manifest.Tracks))
+	return manifest, nil
+}
+
+var _ recorder.Recorder = (*CompositeRecording)(nil)
+
+func NewCompositeRecording(baseDir, roomID string) (recorder.Recorder, error) {
+	id, dir, err := recorder.NewRecordingDir(baseDir, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	mixer, err := newCompositeMixer(_COMPOSITE_FILE)
+	if err != nil {
+		return nil, err
+	}
+	c := &CompositeRecording{
+		id:     id,
+		roomID: roomID,
+		dir:    dir,
+		mixer:  mixer,
+	}
+
+	video, err := makeMany("vp8enc", "queue")
+	if err != nil {
+		pipelineDeinit(mixer.pipe)
+		return nil, err
+	}
+	mcu.BinAddMany(mixer.pipe, video...)
+
+	audio, err := makeMany("opusenc", "queue")
+	if err != nil {
+		pipelineDeinit(mixer.pipe)
+		return nil, err
+	}
+	mcu.BinAddMany(mixer.pipe, audio...)
+
+	output, err := makeMany("webmmux", "filesink")
+	if err != nil {
+		pipelineDeinit(mixer.pipe)
+		return nil, err
+	}
+	mcu.BinAddMany(mixer.pipe, output...)
+	mux, sink := output[0], output[1]
+
+	mcu.ObjectSet(video[0], "deadline", 1)
+	mcu.ObjectSet(video[0], "keyframe-max-dist", _COMPOSITE_FRAMERATE*2)
+	mcu.ObjectSet(sink, "location", filepath.Join(dir, _COMPOSITE_FILE))
+	setQueueBufferSize(video[1])
+	setQueueBufferSize(audio[1])
+
+	if err = linkMany(append([]*mcu.GstElement{mixer.videoOut}, append(video, mux, sink)...)...); err != nil {
+		pipelineDeinit(mixer.pipe)
+		return nil, err
+	}
+	if err = linkMany(append([]*mcu.GstElement{mixer.audioOut}, append(audio, mux)...)...); err != nil {
+		pipelineDeinit(mixer.pipe)
+		return nil, err
+	}
+
+	if mcu.ElementSetState(mixer.pipe, mcu.StatePlaying) == mcu.StateChangeReturnFailure {
+		pipelineDeinit(mixer.pipe)
+		return nil, ErrCompositeStart
+	}
+	c.startedAt = time.Now()
+
+	log.Printf("[Composite] recording %s started at %s", id, dir)
+	return c, nil
+}
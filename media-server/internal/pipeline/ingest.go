@@ -0,0 +1,420 @@
+package pipeline
+
+import (
+	"C"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pion/rtp"
+	webrtc "github.com/pion/webrtc/v4"
+	"github.com/romashorodok/conferencing-platform/media-server/internal/mcu"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/ingest"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/sfu"
+)
+
+const (
+	_INGEST_VIDEO_PAYLOAD_TYPE = 96
+	_INGEST_AUDIO_PAYLOAD_TYPE = 111
+	// NOTE: Subscriber gets keyframe only by the interval, track has no peer connection for pli
+	_INGEST_KEYFRAME_MAX_DIST = _COMPOSITE_FRAMERATE
+	// NOTE: Kbit/s of vp8enc
+	_INGEST_VIDEO_BITRATE = 1500
+	_INGEST_AUDIO_BITRATE = 64000
+	_INGEST_RTP_MTU       = 1200
+	// NOTE: Ms of rtpjitterbuffer
+	_INGEST_RTP_LATENCY = 200
+)
+
+var ErrIngestStart = errors.New("could not start ingest pipeline")
+
+var (
+	_INGEST_VIDEO_CODEC = webrtc.RTPCodecCapability{
+		MimeType:  webrtc.MimeTypeVP8,
+		ClockRate: 90000,
+	}
+	_INGEST_AUDIO_CODEC = webrtc.RTPCodecCapability{
+		MimeType:    webrtc.MimeTypeOpus,
+		ClockRate:   48000,
+		Channels:    2,
+		SDPFmtpLine: "minptime=10;useinbandfec=1",
+	}
+)
+
+type rtpSource struct {
+	kind        webrtc.RTPCodecType
+	encoding    string
+	clockRate   uint32
+	payloadType uint8
+	depay       string
+}
+
+// Plain rtp carries one stream, codec must be told by the sender
+var _INGEST_RTP_SOURCES = map[ingest.Codec]rtpSource{
+	ingest.CODEC_VP8:  {webrtc.RTPCodecTypeVideo, "VP8", 90000, 96, "rtpvp8depay"},
+	ingest.CODEC_VP9:  {webrtc.RTPCodecTypeVideo, "VP9", 90000, 98, "rtpvp9depay"},
+	ingest.CODEC_H264: {webrtc.RTPCodecTypeVideo, "H264", 90000, 102, "rtph264depay"},
+	ingest.CODEC_OPUS: {webrtc.RTPCodecTypeAudio, "OPUS", 48000, 111, "rtpopusdepay"},
+	ingest.CODEC_PCMU: {webrtc.RTPCodecTypeAudio, "PCMU", 8000, 0, "rtppcmudepay"},
+	ingest.CODEC_PCMA: {webrtc.RTPCodecTypeAudio, "PCMA", 8000, 8, "rtppcmadepay"},
+}
+
+// Encoded branch of one kind. Track is published on the first packet, e.g. camera may have no audio
+type ingestOutput struct {
+	kind        webrtc.RTPCodecType
+	codec       webrtc.RTPCodecCapability
+	payloadType webrtc.PayloadType
+	entry       *mcu.GstElement
+	appSink     *mcu.GstElement
+	track       *sfu.IngestTrack
+}
+
+// Source decoded by gstreamer and re-encoded into vp8/opus, so every subscriber is able to receive it
+type SourceIngest struct {
+	id        string
+	roomID    string
+	protocol  ingest.Protocol
+	port      int
+	label     string
+	streamID  string
+	startedAt time.Time
+
+	peer *sfu.PeerContext
+	pipe *mcu.GstElement
+
+	outputsMu sync.Mutex
+	outputs   []*ingestOutput
+	closed    bool
+
+	stateMu sync.Mutex
+	state   ingest.State
+	err     error
+
+	done     chan struct{}
+	halted   sync.Once
+	running  sync.WaitGroup
+	released sync.Once
+}
+
+func (i *SourceIngest) ID() string {
+	return i.id
+}
+
+func (i *SourceIngest) Protocol() ingest.Protocol {
+	return i.protocol
+}
+
+func (i *SourceIngest) Participant() *sfu.PeerContext {
+	return i.peer
+}
+
+func (i *SourceIngest) Port() int {
+	return i.port
+}
+
+func (i *SourceIngest) StartedAt() time.Time {
+	return i.startedAt
+}
+
+func (i *SourceIngest) State() ingest.State {
+	i.stateMu.Lock()
+	defer i.stateMu.Unlock()
+	return i.state
+}
+
+func (i *SourceIngest) Err() error {
+	i.stateMu.Lock()
+	defer i.stateMu.Unlock()
+	return i.err
+}
+
+func (i *SourceIngest) setState(state ingest.State, err error) {
+	i.stateMu.Lock()
+	defer i.stateMu.Unlock()
+	i.state = state
+	i.err = err
+}
+
+func (i *SourceIngest) publish(o *ingestOutput) (*sfu.IngestTrack, error) {
+	i.outputsMu.Lock()
+	defer i.outputsMu.Unlock()
+
+	if i.closed {
+		return nil, ingest.ErrIngestStopped
+	}
+	if o.track != nil {
+		return o.track, nil
+	}
+
+	track, err := i.peer.PublishIngestTrack(sfu.IngestTrackParams{
+		Codec:       o.codec,
+		PayloadType: o.payloadType,
+		Kind:        o.kind,
+		StreamID:    i.streamID,
+		Label:       i.label,
+	})
+	if err != nil {
+		return nil, err
+	}
+	o.track = track
+	log.Printf("[Ingest] %s publish %s track %s", i.id, o.kind, track.TrackContext().ID())
+	return track, nil
+}
+
+func (i *SourceIngest) unpublish() {
+	i.outputsMu.Lock()
+	defer i.outputsMu.Unlock()
+
+	i.closed = true
+	for _, o := range i.outputs {
+		if o.track != nil {
+			_ = o.track.Close()
+			o.track = nil
+		}
+	}
+}
+
+func (i *SourceIngest) handleOutput(o *ingestOutput) {
+	defer i.running.Done()
+
+	for {
+		select {
+		case <-i.done:
+			return
+		default:
+		}
+
+		sample, err := mcu.AppSinkPullSample(o.appSink)
+		if err != nil {
+			if mcu.AppSinkIsEOS(o.appSink) {
+				return
+			}
+			continue
+		}
+
+		pkt := &rtp.Packet{}
+		err = pkt.Unmarshal(C.GoBytes(sample.Buff, C.int(sample.Size)))
+		sample.Deinit()
+		if err != nil {
+			log.Printf("[Ingest] %s unable parse %s packet. Err: %s", i.id, o.kind, err)
+			continue
+		}
+
+		track, err := i.publish(o)
+		if err != nil {
+			continue
+		}
+		_ = track.WriteRTP(pkt)
+	}
+}
+
+// Source may end or drop the connection at any time, tracks are unpublished then
+func (i *SourceIngest) watch() {
+	defer i.running.Done()
+
+	for {
+		select {
+		case <-i.done:
+			return
+		default:
+		}
+
+		msg, err := mcu.PipelinePopMessage(i.pipe, _STREAM_BUS_POLL)
+		switch msg {
+		case mcu.BusMessageEOS:
+			log.Printf("[Ingest] %s of room %s source ended", i.id, i.roomID)
+			i.setState(ingest.STATE_STOPPED, nil)
+		case mcu.BusMessageError:
+			log.Printf("[Ingest] %s of room %s failed. Err: %s", i.id, i.roomID, err)
+			i.setState(ingest.STATE_FAILED, err)
+		default:
+			continue
+		}
+		i.halt()
+		i.unpublish()
+		return
+	}
+}
+
+// NOTE: Pulling of the sample is released on the state change
+func (i *SourceIngest) halt() (first bool) {
+	i.halted.Do(func() {
+		first = true
+		close(i.done)
+		_ = mcu.ElementSetState(i.pipe, mcu.StateNull)
+	})
+	return first
+}
+
+// Ended or failed ingest is kept until it's stopped, participant is removed by the room
+func (i *SourceIngest) Stop() error {
+	first := i.halt()
+	i.running.Wait()
+	i.unpublish()
+	// NOTE: Ended or failed pipeline is halted by the watcher, it's released only here
+	i.released.Do(func() {
+		pipelineDeinit(i.pipe)
+	})
+	if !first {
+		return ingest.ErrIngestStopped
+	}
+
+	i.setState(ingest.STATE_STOPPED, nil)
+	log.Printf("[Ingest] %s of room %s stopped", i.id, i.roomID)
+	return nil
+}
+
+func newIngestOutput(pipe *mcu.GstElement, kind webrtc.RTPCodecType) (*ingestOutput, error) {
+	o := &ingestOutput{kind: kind}
+
+	var (
+		elements []*mcu.GstElement
+		err      error
+	)
+	switch kind {
+	case webrtc.RTPCodecTypeVideo:
+		if elements, err = makeMany("videoconvert", "queue", "vp8enc", "rtpvp8pay", "appsink"); err != nil {
+			return nil, err
+		}
+		mcu.BinAddMany(pipe, elements...)
+		o.codec = _INGEST_VIDEO_CODEC
+		o.payloadType = _INGEST_VIDEO_PAYLOAD_TYPE
+
+		// NOTE: Realtime deadline like the canny filter
+		mcu.ObjectSet(elements[2], "deadline", 1)
+		mcu.ObjectSet(elements[2], "target-bitrate", _INGEST_VIDEO_BITRATE*1000)
+		mcu.ObjectSet(elements[2], "keyframe-max-dist", _INGEST_KEYFRAME_MAX_DIST)
+		mcu.ObjectSet(elements[2], "error-resilient", 1)
+		// NOTE: 15 bit picture id
+		mcu.ObjectSet(elements[3], "picture-id-mode", 2)
+		setQueueBufferSize(elements[1])
+	default:
+		if elements, err = makeMany("audioconvert", "audioresample", "queue", "opusenc", "rtpopuspay", "appsink"); err != nil {
+			return nil, err
+		}
+		mcu.BinAddMany(pipe, elements...)
+		o.codec = _INGEST_AUDIO_CODEC
+		o.payloadType = _INGEST_AUDIO_PAYLOAD_TYPE
+
+		mcu.ObjectSet(elements[3], "bitrate", _INGEST_AUDIO_BITRATE)
+		mcu.ObjectSet(elements[3], "inband-fec", true)
+		setQueueBufferSize(elements[2])
+	}
+
+	o.entry = elements[0]
+	o.appSink = elements[len(elements)-1]
+	pay := elements[len(elements)-2]
+	mcu.ObjectSet(pay, "pt", uint32(o.payloadType))
+	mcu.ObjectSet(pay, "mtu", uint32(_INGEST_RTP_MTU))
+	mcu.ObjectSet(o.appSink, "sync", false)
+
+	if err = linkMany(elements...); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Rtsp goes through uridecodebin which exposes each stream of the server.
+// Rtp goes through the depayloader of the told codec into decodebin. Elements are added to the pipeline once made,
+// so failed start releases only the pipeline
+func NewSourceIngest(peer *sfu.PeerContext, params ingest.Params, source *url.URL) (ingest.Ingest, error) {
+	i := &SourceIngest{
+		id:       uuid.NewString(),
+		roomID:   params.RoomID,
+		protocol: params.Protocol,
+		port:     params.Port,
+		label:    params.Label,
+		streamID: uuid.NewString(),
+		peer:     peer,
+		state:    ingest.STATE_ACTIVE,
+		done:     make(chan struct{}),
+	}
+
+	pipe, err := mcu.PipelineNew("")
+	if err != nil {
+		return nil, err
+	}
+	i.pipe = pipe
+
+	var (
+		decoder *mcu.GstElement
+		kinds   []webrtc.RTPCodecType
+	)
+	switch params.Protocol {
+	case ingest.PROTOCOL_RTSP:
+		if decoder, err = mcu.ElementFactoryMake("uridecodebin", ""); err != nil {
+			pipelineDeinit(pipe)
+			return nil, err
+		}
+		mcu.ObjectSet(decoder, "uri", source.String())
+		mcu.BinAddMany(pipe, decoder)
+		kinds = []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio}
+
+		if i.label == "" {
+			i.label = source.Hostname()
+		}
+	case ingest.PROTOCOL_RTP:
+		src := _INGEST_RTP_SOURCES[params.Codec]
+		payloadType := src.payloadType
+		if params.PayloadType != 0 {
+			payloadType = params.PayloadType
+		}
+
+		elements, err := makeMany("udpsrc", "rtpjitterbuffer", src.depay, "decodebin")
+		if err != nil {
+			pipelineDeinit(pipe)
+			return nil, err
+		}
+		mcu.BinAddMany(pipe, elements...)
+		decoder = elements[3]
+
+		mcu.ObjectSet(elements[0], "port", params.Port)
+		mcu.ObjectSet(elements[0], "caps", mcu.CapsFromString(fmt.Sprintf(
+			"application/x-rtp, media=(string)%s, clock-rate=(int)%d, encoding-name=(string)%s, payload=(int)%d",
+			src.kind, src.clockRate, src.encoding, payloadType,
+		)))
+		mcu.ObjectSet(elements[1], "latency", uint32(_INGEST_RTP_LATENCY))
+
+		if err = linkMany(elements...); err != nil {
+			pipelineDeinit(pipe)
+			return nil, err
+		}
+		kinds = []webrtc.RTPCodecType{src.kind}
+
+		if i.label == "" {
+			i.label = fmt.Sprintf("rtp :%d", params.Port)
+		}
+	default:
+		pipelineDeinit(pipe)
+		return nil, errors.Join(ingest.ErrUnsupportedProtocol, fmt.Errorf("protocol: %s", params.Protocol))
+	}
+
+	for _, kind := range kinds {
+		o, err := newIngestOutput(pipe, kind)
+		if err != nil {
+			pipelineDeinit(pipe)
+			return nil, err
+		}
+		mcu.ElementLinkDynamic(decoder, o.entry)
+		i.outputs = append(i.outputs, o)
+	}
+
+	if mcu.ElementSetState(pipe, mcu.StatePlaying) == mcu.StateChangeReturnFailure {
+		pipelineDeinit(pipe)
+		return nil, ErrIngestStart
+	}
+	i.startedAt = time.Now()
+
+	i.running.Add(len(i.outputs) + 1)
+	for _, o := range i.outputs {
+		go i.handleOutput(o)
+	}
+	go i.watch()
+
+	log.Printf("[Ingest] %s of room %s started. Protocol: %s Participant: %s", i.id, i.roomID, i.protocol, peer.PeerID())
+	return i, nil
+}
@@ -0,0 +1,213 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	webrtc "github.com/pion/webrtc/v4"
+	"github.com/romashorodok/conferencing-platform/media-server/internal/mcu"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/recorder"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/sfu"
+)
+
+// NOTE: Rtp read loop must not wait for the pipeline
+const _MIXER_BRANCH_BUFFER = 512
+
+// Rtp caps and the chain from depay to raw media for the codec of the track
+func mixerBranchFactories(codec webrtc.RTPCodecParameters) (string, []string, error) {
+	switch strings.ToLower(codec.MimeType) {
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		return mcu.NewRtpVP8Caps(codec.ClockRate), []string{"rtpvp8depay", "vp8dec", "videoconvert", "videoscale"}, nil
+	case strings.ToLower(webrtc.MimeTypeVP9):
+		return mcu.NewRtpVP9Caps(codec.ClockRate), []string{"rtpvp9depay", "vp9dec", "videoconvert", "videoscale"}, nil
+	case strings.ToLower(webrtc.MimeTypeH264):
+		return mcu.NewRtpH264Caps(codec.ClockRate), []string{"rtph264depay", "h264parse", "avdec_h264", "videoconvert", "videoscale"}, nil
+	case strings.ToLower(webrtc.MimeTypeOpus):
+		return mcu.NewRtpOpusCaps(codec.ClockRate), []string{"rtpopusdepay", "opusdec", "audioconvert", "audioresample"}, nil
+	default:
+		return "", nil, errors.Join(recorder.ErrCodecNotSupported, fmt.Errorf("mime type: %s", codec.MimeType))
+	}
+}
+
+// Decoding chain of one track which is linked to the request pad of the mixer, e.g. compositor or audiomixer
+type mixerBranch struct {
+	track     *sfu.TrackContext
+	downTrack *sfu.DownTrack
+	caps      *mcu.GstCaps
+
+	// NOTE: Ordered from appsrc to the last queue
+	elements []*mcu.GstElement
+	mixer    *mcu.GstElement
+	pad      *mcu.GstPad
+
+	packets chan []byte
+	done    chan struct{}
+	stopped sync.Once
+
+	entryMu sync.Mutex
+	entry   recorder.ManifestTrack
+}
+
+func (b *mixerBranch) appSrc() *mcu.GstElement {
+	return b.elements[0]
+}
+
+func (b *mixerBranch) WriteRTP(pkt *rtp.Packet) error {
+	b.entryMu.Lock()
+	if b.entry.FirstPacketAt == nil {
+		now := time.Now()
+		b.entry.FirstPacketAt = &now
+		b.entry.FirstRTPTimestamp = pkt.Timestamp
+	}
+	b.entryMu.Unlock()
+
+	data, err := pkt.Marshal()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case b.packets <- data:
+	default:
+		log.Printf("[Mixer] track %s buffer is full. Drop packet %d", b.track.ID(), pkt.SequenceNumber)
+	}
+	return nil
+}
+
+func (b *mixerBranch) run() {
+	defer close(b.done)
+
+	for data := range b.packets {
+		buf, err := mcu.BufferNewWrapped(data)
+		if err != nil {
+			log.Printf("[Mixer] track %s unable wrap packet. Err: %s", b.track.ID(), err)
+			continue
+		}
+		if err = mcu.AppSrcPushBuffer(b.appSrc(), buf); err != nil {
+			log.Printf("[Mixer] track %s unable push packet. Err: %s", b.track.ID(), err)
+		}
+	}
+}
+
+// Stop feeding the branch, elements stay in the pipeline
+func (b *mixerBranch) Stop() recorder.ManifestTrack {
+	b.stopped.Do(func() {
+		b.track.RemoveSink(b.downTrack)
+		close(b.packets)
+		<-b.done
+
+		b.entryMu.Lock()
+		now := time.Now()
+		b.entry.StoppedAt = &now
+		b.entryMu.Unlock()
+	})
+
+	b.entryMu.Lock()
+	defer b.entryMu.Unlock()
+	return b.entry
+}
+
+// Branch is added into running pipeline. File is the output where track is recorded
+func newMixerBranch(pipe, mixer *mcu.GstElement, t *sfu.TrackContext, file string) (*mixerBranch, error) {
+	caps, factories, err := mixerBranchFactories(t.Codec())
+	if err != nil {
+		return nil, err
+	}
+
+	branch := &mixerBranch{
+		track:   t,
+		caps:    mcu.CapsFromString(caps),
+		mixer:   mixer,
+		packets: make(chan []byte, _MIXER_BRANCH_BUFFER),
+		done:    make(chan struct{}),
+		entry:   recorder.NewManifestTrack(t, file),
+	}
+
+	factories = append([]string{"appsrc", "rtpjitterbuffer"}, factories...)
+	branch.elements, err = makeMany(append(factories, "queue")...)
+	if err != nil {
+		return nil, err
+	}
+
+	src := branch.appSrc()
+	mcu.ObjectSet(src, "caps", branch.caps)
+	mcu.ObjectSet(src, "format", 3)
+	mcu.ObjectSet(src, "is-live", true)
+	mcu.ObjectSet(src, "do-timestamp", true)
+	setQueueBufferSize(branch.elements[len(branch.elements)-1])
+
+	mcu.BinAddMany(pipe, branch.elements...)
+	if err = linkMany(branch.elements...); err != nil {
+		mcu.BinRemoveMany(pipe, branch.elements...)
+		return nil, errors.Join(err, fmt.Errorf("track: %s", t.ID()))
+	}
+
+	pad, err := mcu.ElementRequestPad(mixer, "sink_%u")
+	if err != nil {
+		mcu.BinRemoveMany(pipe, branch.elements...)
+		return nil, err
+	}
+	branch.pad = pad
+
+	if !mcu.ElementLinkPad(branch.elements[len(branch.elements)-1], pad) {
+		mcu.ElementReleasePad(mixer, pad)
+		mcu.BinRemoveMany(pipe, branch.elements...)
+		return nil, fmt.Errorf("unable link track %s to the mixer", t.ID())
+	}
+
+	// NOTE: Downstream goes first, so it's ready when the data comes
+	for i := len(branch.elements) - 1; i >= 0; i-- {
+		mcu.ElementSyncStateWithParent(branch.elements[i])
+	}
+	return branch, nil
+}
+
+// Unlink the branch from the running pipeline. Feeding must be stopped before
+func (b *mixerBranch) Remove(pipe *mcu.GstElement) {
+	for _, elem := range b.elements {
+		mcu.ElementSetState(elem, mcu.StateNull)
+	}
+	mcu.ElementUnlinkPad(b.elements[len(b.elements)-1], b.pad)
+	mcu.ElementReleasePad(b.mixer, b.pad)
+	mcu.BinRemoveMany(pipe, b.elements...)
+}
+
+func linkMany(elements ...*mcu.GstElement) error {
+	for i := 1; i < len(elements); i++ {
+		if !mcu.ElementLink(elements[i-1], elements[i]) {
+			return errors.New("unable link GstElement")
+		}
+	}
+	return nil
+}
+
+// Elements which are not added to the pipeline yet
+func deinitMany(elements ...*mcu.GstElement) {
+	for _, elem := range elements {
+		mcu.ElementDeinit(elem)
+	}
+}
+
+// Elements added to the pipeline are released with it
+func pipelineDeinit(pipe *mcu.GstElement) {
+	_ = mcu.ElementSetState(pipe, mcu.StateNull)
+	mcu.ElementDeinit(pipe)
+}
+
+func makeMany(factories ...string) ([]*mcu.GstElement, error) {
+	result := make([]*mcu.GstElement, 0, len(factories))
+	for _, factory := range factories {
+		elem, err := mcu.ElementFactoryMake(factory, "")
+		if err != nil {
+			deinitMany(result...)
+			return nil, err
+		}
+		result = append(result, elem)
+	}
+	return result, nil
+}
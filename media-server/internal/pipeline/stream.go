@@ -0,0 +1,274 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romashorodok/conferencing-platform/media-server/internal/mcu"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/egress"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/sfu"
+)
+
+const (
+	// NOTE: Kbit/s of x264enc
+	_STREAM_VIDEO_BITRATE = 2500
+	_STREAM_AUDIO_BITRATE = 128000
+
+	// NOTE: Bus is polled, so the watcher notices stop without eos
+	_STREAM_BUS_POLL = 500 * time.Millisecond
+
+	// NOTE: Segment is cut on the keyframe, so it's the key-int-max of x264enc
+	_HLS_TARGET_DURATION = 2
+	_HLS_PLAYLIST_LENGTH = 6
+	// NOTE: Segments out of the playlist are kept a bit for the slow viewers
+	_HLS_MAX_FILES = _HLS_PLAYLIST_LENGTH * 2
+)
+
+var ErrStreamStart = errors.New("could not start stream pipeline")
+
+// Composite of the room or participant encoded into H.264/AAC. It's pushed to the rtmp or srt server or packaged into hls
+type StreamEgress struct {
+	id            string
+	roomID        string
+	participantID string
+	protocol      egress.Protocol
+	startedAt     time.Time
+	// NOTE: Directory of the hls playlist and segments
+	dir string
+
+	mixer *compositeMixer
+
+	stateMu sync.Mutex
+	state   egress.State
+	err     error
+
+	done     chan struct{}
+	watched  chan struct{}
+	released sync.Once
+}
+
+func (e *StreamEgress) ID() string {
+	return e.id
+}
+
+func (e *StreamEgress) Protocol() egress.Protocol {
+	return e.protocol
+}
+
+func (e *StreamEgress) ParticipantID() string {
+	return e.participantID
+}
+
+func (e *StreamEgress) StartedAt() time.Time {
+	return e.startedAt
+}
+
+func (e *StreamEgress) State() egress.State {
+	e.stateMu.Lock()
+	defer e.stateMu.Unlock()
+	return e.state
+}
+
+func (e *StreamEgress) Err() error {
+	e.stateMu.Lock()
+	defer e.stateMu.Unlock()
+	return e.err
+}
+
+func (e *StreamEgress) setState(state egress.State, err error) {
+	e.stateMu.Lock()
+	defer e.stateMu.Unlock()
+	e.state = state
+	e.err = err
+}
+
+func (e *StreamEgress) TrackPublished(t *sfu.TrackContext) {
+	if e.participantID != "" && t.SourcePeerID != e.participantID {
+		return
+	}
+	if err := e.mixer.AddTrack(t); err != nil {
+		log.Printf("[Egress] %s unable add track %s. Err: %s", e.id, t.ID(), err)
+	}
+}
+
+func (e *StreamEgress) TrackUnpublished(t *sfu.TrackContext) {
+	e.mixer.RemoveTrack(t)
+}
+
+// Remote side may drop the stream at any time, egress is failed then and tracks are not mixed anymore
+func (e *StreamEgress) watch() {
+	defer close(e.watched)
+
+	for {
+		select {
+		case <-e.done:
+			return
+		default:
+		}
+
+		msg, err := mcu.PipelinePopMessage(e.mixer.pipe, _STREAM_BUS_POLL)
+		switch msg {
+		case mcu.BusMessageEOS:
+			return
+		case mcu.BusMessageError:
+			log.Printf("[Egress] %s of room %s failed. Err: %s", e.id, e.roomID, err)
+			e.setState(egress.STATE_FAILED, err)
+			e.mixer.Stop()
+			_ = mcu.ElementSetState(e.mixer.pipe, mcu.StateNull)
+			return
+		}
+	}
+}
+
+// Failed egress is released too, but it's reported as already stopped
+func (e *StreamEgress) Stop() error {
+	_, ok := e.mixer.Stop()
+
+	// NOTE: Muxer flushes the tail of the stream on eos
+	if ok && mcu.ElementSendEOS(e.mixer.pipe) {
+		select {
+		case <-e.watched:
+		case <-time.After(_COMPOSITE_EOS_TIMEOUT):
+			log.Printf("[Egress] %s eos is not reached", e.id)
+		}
+	}
+	e.released.Do(func() {
+		close(e.done)
+		_ = mcu.ElementSetState(e.mixer.pipe, mcu.StateNull)
+		<-e.watched
+		e.release()
+	})
+	if !ok {
+		return egress.ErrEgressStopped
+	}
+
+	e.setState(egress.STATE_STOPPED, nil)
+	log.Printf("[Egress] %s of room %s stopped", e.id, e.roomID)
+	return nil
+}
+
+// Elements are released with the pipeline
+func (e *StreamEgress) release() {
+	pipelineDeinit(e.mixer.pipe)
+
+	// NOTE: Hls is live only, viewers can't catch up the stopped stream
+	if e.dir != "" {
+		if err := os.RemoveAll(e.dir); err != nil {
+			log.Printf("[Egress] %s unable remove hls dir. Err: %s", e.id, err)
+		}
+	}
+}
+
+var _ egress.Egress = (*StreamEgress)(nil)
+
+// Rtmp goes with flv, srt goes with mpeg-ts. Hls is written as mpeg-ts segments by hlssink2
+func NewStreamEgress(params egress.Params, target *url.URL) (egress.Egress, error) {
+	mixer, err := newCompositeMixer("")
+	if err != nil {
+		return nil, err
+	}
+	e := &StreamEgress{
+		id:            uuid.NewString(),
+		roomID:        params.RoomID,
+		participantID: params.ParticipantID,
+		protocol:      params.Protocol,
+		mixer:         mixer,
+		state:         egress.STATE_ACTIVE,
+		done:          make(chan struct{}),
+		watched:       make(chan struct{}),
+	}
+
+	video, err := makeMany("x264enc", "h264parse", "queue")
+	if err != nil {
+		e.release()
+		return nil, err
+	}
+	mcu.BinAddMany(mixer.pipe, video...)
+
+	audio, err := makeMany("avenc_aac", "aacparse", "queue")
+	if err != nil {
+		e.release()
+		return nil, err
+	}
+	mcu.BinAddMany(mixer.pipe, audio...)
+
+	var output []*mcu.GstElement
+	switch e.protocol {
+	case egress.PROTOCOL_RTMP:
+		if output, err = makeMany("flvmux", "rtmp2sink"); err != nil {
+			e.release()
+			return nil, err
+		}
+		mcu.ObjectSet(output[0], "streamable", true)
+		mcu.ObjectSet(output[1], "location", target.String())
+	case egress.PROTOCOL_SRT:
+		if output, err = makeMany("mpegtsmux", "srtsink"); err != nil {
+			e.release()
+			return nil, err
+		}
+		mcu.ObjectSet(output[1], "uri", target.String())
+	case egress.PROTOCOL_HLS:
+		if e.dir, err = egress.HlsDir(params.BaseDir, e.roomID, e.id); err != nil {
+			e.release()
+			return nil, err
+		}
+		if err = os.MkdirAll(e.dir, 0o755); err != nil {
+			e.release()
+			return nil, err
+		}
+		// NOTE: hlssink2 has no partial segments of ll-hls, latency is about the target duration * 3
+		if output, err = makeMany("hlssink2"); err != nil {
+			e.release()
+			return nil, err
+		}
+		mcu.ObjectSet(output[0], "location", filepath.Join(e.dir, "segment%05d.ts"))
+		mcu.ObjectSet(output[0], "playlist-location", filepath.Join(e.dir, egress.HLS_PLAYLIST))
+		mcu.ObjectSet(output[0], "target-duration", uint32(_HLS_TARGET_DURATION))
+		mcu.ObjectSet(output[0], "playlist-length", uint32(_HLS_PLAYLIST_LENGTH))
+		mcu.ObjectSet(output[0], "max-files", uint32(_HLS_MAX_FILES))
+	default:
+		e.release()
+		return nil, errors.Join(egress.ErrUnsupportedProtocol, fmt.Errorf("protocol: %s", e.protocol))
+	}
+	mcu.BinAddMany(mixer.pipe, output...)
+	mux := output[0]
+
+	// NOTE: Zerolatency tune and veryfast preset
+	mcu.ObjectSet(video[0], "tune", 4)
+	mcu.ObjectSet(video[0], "speed-preset", 3)
+	mcu.ObjectSet(video[0], "bitrate", uint32(_STREAM_VIDEO_BITRATE))
+	mcu.ObjectSet(video[0], "key-int-max", uint32(_COMPOSITE_FRAMERATE*_HLS_TARGET_DURATION))
+	mcu.ObjectSet(audio[0], "bitrate", _STREAM_AUDIO_BITRATE)
+	setQueueBufferSize(video[2])
+	setQueueBufferSize(audio[2])
+
+	if err = linkMany(append([]*mcu.GstElement{mixer.videoOut}, append(video, output...)...)...); err != nil {
+		e.release()
+		return nil, err
+	}
+	if err = linkMany(append([]*mcu.GstElement{mixer.audioOut}, append(audio, mux)...)...); err != nil {
+		e.release()
+		return nil, err
+	}
+
+	if mcu.ElementSetState(mixer.pipe, mcu.StatePlaying) == mcu.StateChangeReturnFailure {
+		e.release()
+		return nil, ErrStreamStart
+	}
+	e.startedAt = time.Now()
+	go e.watch()
+
+	if target != nil {
+		log.Printf("[Egress] %s of room %s started. Protocol: %s Host: %s", e.id, e.roomID, e.protocol, target.Host)
+	} else {
+		log.Printf("[Egress] %s of room %s started. Protocol: %s Dir: %s", e.id, e.roomID, e.protocol, e.dir)
+	}
+	return e, nil
+}
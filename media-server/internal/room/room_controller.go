@@ -5,9 +5,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"log/slog"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,8 +17,15 @@ import (
 	"github.com/gorilla/websocket"
 	echo "github.com/labstack/echo/v4"
 	webrtc "github.com/pion/webrtc/v4"
+	"github.com/romashorodok/conferencing-platform/media-server/internal/chat"
+	"github.com/romashorodok/conferencing-platform/media-server/internal/identity"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/bwe"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/egress"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/ingest"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/recorder"
 	"github.com/romashorodok/conferencing-platform/media-server/pkg/rtpstats"
 	"github.com/romashorodok/conferencing-platform/media-server/pkg/sfu"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/variables"
 	"github.com/romashorodok/conferencing-platform/pkg/controller/room"
 	globalprotocol "github.com/romashorodok/conferencing-platform/pkg/protocol"
 	"github.com/romashorodok/conferencing-platform/pkg/wsutils"
@@ -41,15 +50,24 @@ type roomController struct {
 	lifecycle        fx.Lifecycle
 	roomService      *RoomService
 	stats            <-chan *rtpstats.RtpStats
+	estimators       <-chan *bwe.BandwidthEstimator
 	upgrader         websocket.Upgrader
 	webrtc           *webrtc.API
 	logger           *slog.Logger
 	peerConnectionMu sync.Mutex
 	roomNotifier     *RoomNotifier
 	pipeAllocContext *sfu.AllocatorsContext
+	chatService      *chat.ChatService
+	identityService  *identity.IdentityService
+	recordingsDir    string
+	recorders        *recorder.AllocatorsContext
+	egresses         *egress.AllocatorsContext
+	ingests          *ingest.AllocatorsContext
+	hlsDir           string
 }
 
 type filterData struct {
+	TrackID  string `json:"trackId"`
 	Enabled  bool   `json:"enabled"`
 	Name     string `json:"name"`
 	MimeType string `json:"mimeType"`
@@ -58,7 +76,7 @@ type filterData struct {
 func (ctrl *roomController) RoomControllerRoomNotifier(ctx echo.Context) error {
 	conn, err := ctrl.upgrader.Upgrade(ctx.Response().Writer, ctx.Request(), nil)
 	if err != nil {
-		ctrl.logger.Error(fmt.Sprintf("Unable upgrade request %s", ctx.Request()))
+		ctrl.logger.Error(fmt.Sprintf("Unable upgrade request %+v", ctx.Request()))
 		return err
 	}
 
@@ -99,29 +117,46 @@ func (ctrl *roomController) RoomControllerRoomJoin(ctx echo.Context, roomId stri
 	w := wsutils.NewThreadSafeWriter(conn)
 	defer w.Close()
 
+	if token := ctx.QueryParam("resume"); token != "" {
+		return ctrl.resume(w, roomCtx, token)
+	}
+
+	// NOTE: Browser websocket can't set authorization header, access token comes in the query.
+	// Anonymous participant is able to join, but not to chat
+	userID := uuid.Nil
+	if accessToken := ctx.QueryParam("token"); accessToken != "" {
+		tokenCtx, err := ctrl.identityService.TokenIdentity(ctx.Request().Context(), accessToken)
+		if err != nil || tokenCtx.TokenUse != identity.ACCESS_TOKEN {
+			return ctrl.wsError(w, errors.Join(ErrIdentityRequired, err))
+		}
+		userID = tokenCtx.UserID
+		roomCtx.AddMember(userID)
+	}
+
 	ctrl.peerConnectionMu.Lock()
+	// NOTE: Peer context outlives the websocket, so client is able to resume it
 	peerContext, err := sfu.NewPeerContext(sfu.NewPeerContextParams{
-		Context:          ctx.Request().Context(),
+		Context:          roomCtx.ctx,
 		API:              ctrl.webrtc,
 		WS:               w,
 		PipeAllocContext: ctrl.pipeAllocContext,
 		Spreader:         roomCtx.peerContextPool,
 	})
-	peerContext.SetStats(<-ctrl.stats)
-	ctrl.peerConnectionMu.Unlock()
 	if err != nil {
+		ctrl.peerConnectionMu.Unlock()
 		return ctrl.wsError(w, err)
 	}
-	defer func() {
-		peerContext.Close(sfu.ErrPeerConnectionClosed)
-		roomCtx.peerContextPool.Remove(peerContext)
-		ctrl.roomNotifier.DispatchUpdateRooms()
-	}()
+	peerContext.SetStats(<-ctrl.stats)
+	peerContext.SetBandwidthEstimator(<-ctrl.estimators)
+	ctrl.peerConnectionMu.Unlock()
+
+	token, generation := roomCtx.sessions.Add(peerContext, userID)
 
 	if err = peerContext.AddTransceiver([]webrtc.RTPCodecType{
 		webrtc.RTPCodecTypeVideo,
 		webrtc.RTPCodecTypeAudio,
 	}); err != nil {
+		ctrl.closePeer(roomCtx, peerContext, token)
 		return ctrl.wsError(w, err)
 	}
 
@@ -131,24 +166,18 @@ func (ctrl *roomController) RoomControllerRoomJoin(ctx echo.Context, roomId stri
 			return
 		}
 
-		candidate, lErr := json.Marshal(c.ToJSON())
-		if lErr != nil {
-			log.Println(lErr)
-			return
-		}
-
-		if err := w.WriteJSON(websocketMessage{
-			Event: "candidate",
-			Data:  string(candidate),
-		}); err != nil {
-			ctrl.wsError(w, err)
-			return
+		if err := peerContext.Signal.DispatchEvent("candidate", c.ToJSON()); err != nil {
+			log.Println("[OnICECandidate] Unable send candidate. Err:", err)
 		}
 	})
 
 	peerContext.OnConnectionStateChange(func(p webrtc.PeerConnectionState) {
 		switch p {
 		case webrtc.PeerConnectionStateConnected:
+			// NOTE: Connected again after ice restart
+			if roomCtx.peerContextPool.Exist(peerContext) {
+				return
+			}
 			if err = roomCtx.peerContextPool.Add(peerContext); err != nil {
 				ctrl.wsError(w, err)
 				peerContext.Close(errors.Join(errors.New("unable add into pool."), sfu.ErrPeerConnectionClosed))
@@ -156,56 +185,447 @@ func (ctrl *roomController) RoomControllerRoomJoin(ctx echo.Context, roomId stri
 			}
 			ctrl.roomNotifier.DispatchUpdateRooms()
 
-		case webrtc.PeerConnectionStateClosed, webrtc.PeerConnectionStateFailed:
-			peerContext.Close(sfu.ErrPeerConnectionClosed)
-			roomCtx.peerContextPool.Remove(peerContext)
-			roomCtx.peerContextPool.DispatchOffers()
+		case webrtc.PeerConnectionStateDisconnected:
+			peerContext.ScheduleICERestart()
+
+		case webrtc.PeerConnectionStateFailed:
+			peerContext.ScheduleICERestart()
+			time.AfterFunc(_SESSION_RESUME_GRACE, func() {
+				if peerContext.ConnectionState() == webrtc.PeerConnectionStateFailed {
+					ctrl.closePeer(roomCtx, peerContext, token)
+				}
+			})
+
+		case webrtc.PeerConnectionStateClosed:
+			ctrl.closePeer(roomCtx, peerContext, token)
 		}
 	})
 
-	go func() {
-		ticker := time.NewTicker(time.Second * 10)
-		for {
-			select {
-			case <-peerContext.Done():
+	if err := peerContext.OpenDataChannel(); err != nil {
+		ctrl.closePeer(roomCtx, peerContext, token)
+		return ctrl.wsError(w, err)
+	}
+
+	ctrl.dispatchSession(peerContext, token)
+	go ctrl.dispatchFilters(peerContext)
+	go ctrl.dispatchChatHistory(roomCtx, peerContext)
+	ctrl.dispatchRecording(roomCtx, peerContext)
+
+	return ctrl.serveSignal(w, roomCtx, peerContext, token, generation)
+}
+
+// Attach new websocket to the peer context which is waiting in the grace period
+func (ctrl *roomController) resume(w *wsutils.ThreadSafeWriter, roomCtx *roomContext, token string) error {
+	peerContext, generation, err := roomCtx.sessions.Resume(token)
+	if err != nil {
+		return ctrl.wsError(w, err)
+	}
+
+	log.Printf("[RoomJoin] peer %s resume session", peerContext.PeerID())
+	peerContext.Signal.SetConn(w)
+
+	ctrl.dispatchSession(peerContext, token)
+	go ctrl.dispatchFilters(peerContext)
+	// NOTE: Messages may be missed while websocket was down
+	go ctrl.dispatchChatHistory(roomCtx, peerContext)
+	ctrl.dispatchRecording(roomCtx, peerContext)
+	if err = peerContext.Signal.DispatchEvent("audio-mix", peerContext.AudioMixState()); err != nil {
+		log.Println("[RoomJoin] Unable send audio mix state. Err:", err)
+	}
+
+	// NOTE: Websocket drop usually means network change, old candidates are useless
+	if err = peerContext.RestartICE(); err != nil {
+		log.Println("[RoomJoin] Unable restart ice on resume. Err:", err)
+	}
+
+	return ctrl.serveSignal(w, roomCtx, peerContext, token, generation)
+}
+
+func (ctrl *roomController) closePeer(roomCtx *roomContext, peerContext *sfu.PeerContext, token string) {
+	peerContext.Close(sfu.ErrPeerConnectionClosed)
+	roomCtx.peerContextPool.Remove(peerContext)
+	roomCtx.sessions.Remove(token)
+	ctrl.roomNotifier.DispatchUpdateRooms()
+}
+
+// WHIP ingest. Encoder publishes into the room as a participant without websocket
+func (ctrl *roomController) RoomControllerWhipPublish(ctx echo.Context, roomId string) error {
+	roomCtx := ctrl.roomService.GetRoom(roomId)
+	if roomCtx == nil {
+		return echo.NewHTTPError(http.StatusNotFound, ErrRoomNotExist.Error())
+	}
+
+	if !strings.HasPrefix(ctx.Request().Header.Get(echo.HeaderContentType), _SDP_CONTENT_TYPE) {
+		return echo.NewHTTPError(http.StatusUnsupportedMediaType)
+	}
+
+	if err := ctrl.bearerIdentity(ctx); err != nil {
+		return err
+	}
+
+	offer, err := io.ReadAll(ctx.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	ctrl.peerConnectionMu.Lock()
+	peerContext, err := sfu.NewPeerContext(sfu.NewPeerContextParams{
+		Context:          roomCtx.ctx,
+		API:              ctrl.webrtc,
+		PipeAllocContext: ctrl.pipeAllocContext,
+		Spreader:         roomCtx.peerContextPool,
+		PublishOnly:      true,
+	})
+	if err != nil {
+		ctrl.peerConnectionMu.Unlock()
+		return err
+	}
+	peerContext.SetStats(<-ctrl.stats)
+	peerContext.SetBandwidthEstimator(<-ctrl.estimators)
+	ctrl.peerConnectionMu.Unlock()
+
+	resourceID := roomCtx.whip.Add(peerContext)
+
+	peerContext.OnTrack()
+	peerContext.OnConnectionStateChange(func(p webrtc.PeerConnectionState) {
+		switch p {
+		case webrtc.PeerConnectionStateConnected:
+			if roomCtx.peerContextPool.Exist(peerContext) {
 				return
-			case <-ticker.C:
-				roomCtx.peerContextPool.SanitizePeerSenders(peerContext)
-				log.Println("dispatch offer")
 			}
+			if err := roomCtx.peerContextPool.Add(peerContext); err != nil {
+				log.Printf("[WHIP] Unable add %s into pool. Err: %s", peerContext.PeerID(), err)
+				ctrl.closeWhip(roomCtx, peerContext, resourceID)
+				return
+			}
+			ctrl.roomNotifier.DispatchUpdateRooms()
+
+		// NOTE: Encoder is not able to restart ice over the server offer
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			ctrl.closeWhip(roomCtx, peerContext, resourceID)
 		}
-	}()
+	})
 
-	if _, err := peerContext.CreateDataChannel("_negotiation", nil); err != nil {
-		return ctrl.wsError(w, err)
+	answer, err := peerContext.AnswerOffer(ctx.Request().Context(), webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  string(offer),
+	})
+	if err != nil {
+		ctrl.closeWhip(roomCtx, peerContext, resourceID)
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	log.Printf("[WHIP] peer %s publish into room %s", peerContext.PeerID(), roomId)
+	ctx.Response().Header().Set(echo.HeaderLocation, ctx.Request().URL.Path+"/"+resourceID)
+	return ctx.Blob(http.StatusCreated, _SDP_CONTENT_TYPE, []byte(answer.SDP))
+}
+
+// Trickle candidates of the encoder
+func (ctrl *roomController) RoomControllerWhipPatch(ctx echo.Context, roomId string, resourceId string) error {
+	roomCtx := ctrl.roomService.GetRoom(roomId)
+	if roomCtx == nil {
+		return echo.NewHTTPError(http.StatusNotFound, ErrRoomNotExist.Error())
+	}
+
+	peerContext, err := roomCtx.whip.Get(resourceId)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	if !strings.HasPrefix(ctx.Request().Header.Get(echo.HeaderContentType), _WHIP_FRAGMENT_CONTENT_TYPE) {
+		return echo.NewHTTPError(http.StatusUnsupportedMediaType)
+	}
+
+	frag, err := io.ReadAll(ctx.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	ufrag, candidates, err := parseTrickleFragment(string(frag))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	// NOTE: New credentials mean ice restart, it's not supported
+	if remote := peerContext.RemoteDescription(); ufrag != "" && remote != nil && ufrag != sdpAttribute(remote.SDP, "ice-ufrag") {
+		return echo.NewHTTPError(http.StatusMethodNotAllowed)
+	}
+
+	for _, candidate := range candidates {
+		if err = peerContext.SetCandidate(candidate); err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		}
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func (ctrl *roomController) RoomControllerWhipDelete(ctx echo.Context, roomId string, resourceId string) error {
+	roomCtx := ctrl.roomService.GetRoom(roomId)
+	if roomCtx == nil {
+		return echo.NewHTTPError(http.StatusNotFound, ErrRoomNotExist.Error())
+	}
+
+	peerContext, err := roomCtx.whip.Get(resourceId)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	ctrl.closeWhip(roomCtx, peerContext, resourceId)
+	return ctx.NoContent(http.StatusOK)
+}
+
+// WHEP egress. Player watches the participant or the whole room, it's not a participant itself
+func (ctrl *roomController) RoomControllerWhepSubscribe(ctx echo.Context, roomId string, params room.RoomControllerWhepSubscribeParams) error {
+	roomCtx := ctrl.roomService.GetRoom(roomId)
+	if roomCtx == nil {
+		return echo.NewHTTPError(http.StatusNotFound, ErrRoomNotExist.Error())
+	}
+
+	if !strings.HasPrefix(ctx.Request().Header.Get(echo.HeaderContentType), _SDP_CONTENT_TYPE) {
+		return echo.NewHTTPError(http.StatusUnsupportedMediaType)
 	}
 
-	go func() {
-	retry:
-		peerFilters := peerContext.Filters()
+	if err := ctrl.bearerIdentity(ctx); err != nil {
+		return err
+	}
+
+	watchPeer := params.ParticipantId != nil
+	if watchPeer && !roomCtx.HasParticipant(*params.ParticipantId) {
+		return echo.NewHTTPError(http.StatusNotFound, sfu.ErrParticipantNotFound.Error())
+	}
+
+	offer, err := io.ReadAll(ctx.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	ctrl.peerConnectionMu.Lock()
+	peerContext, err := sfu.NewPeerContext(sfu.NewPeerContextParams{
+		Context:          roomCtx.ctx,
+		API:              ctrl.webrtc,
+		PipeAllocContext: ctrl.pipeAllocContext,
+		Spreader:         roomCtx.peerContextPool,
+		ReceiveOnly:      true,
+	})
+	if err != nil {
+		ctrl.peerConnectionMu.Unlock()
+		return err
+	}
+	peerContext.SetStats(<-ctrl.stats)
+	peerContext.SetBandwidthEstimator(<-ctrl.estimators)
+	ctrl.peerConnectionMu.Unlock()
+
+	resourceID := roomCtx.whep.Add(peerContext)
+
+	if watchPeer {
+		peerContext.Subscriber.SetAutoSubscribe(false)
+		_ = peerContext.Subscriber.Subscribe(sfu.SubscriptionMessage{
+			PeerIDs: []string{*params.ParticipantId},
+		})
+	} else if err = peerContext.SetAudioMix(sfu.AudioMixMessage{Enabled: true}); err != nil {
+		// NOTE: Player usually offers one audio section, without mix it gets audio of one participant
+		log.Printf("[WHEP] %s unable mix room audio. Err: %s", peerContext.PeerID(), err)
+	}
+
+	peerContext.OnTrack()
+	peerContext.OnConnectionStateChange(func(p webrtc.PeerConnectionState) {
+		switch p {
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			ctrl.closeWhep(roomCtx, peerContext, resourceID)
+		}
+	})
+
+	answer, err := peerContext.AnswerOffer(ctx.Request().Context(), webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  string(offer),
+	})
+	if err != nil {
+		ctrl.closeWhep(roomCtx, peerContext, resourceID)
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	log.Printf("[WHEP] peer %s watch room %s", peerContext.PeerID(), roomId)
+	ctx.Response().Header().Set(echo.HeaderLocation, ctx.Request().URL.Path+"/"+resourceID)
+	return ctx.Blob(http.StatusCreated, _SDP_CONTENT_TYPE, []byte(answer.SDP))
+}
+
+func (ctrl *roomController) RoomControllerWhepDelete(ctx echo.Context, roomId string, resourceId string) error {
+	roomCtx := ctrl.roomService.GetRoom(roomId)
+	if roomCtx == nil {
+		return echo.NewHTTPError(http.StatusNotFound, ErrRoomNotExist.Error())
+	}
+
+	peerContext, err := roomCtx.whep.Get(resourceId)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	ctrl.closeWhep(roomCtx, peerContext, resourceId)
+	return ctx.NoContent(http.StatusOK)
+}
+
+// NOTE: Player is not in the pool, it only reads tracks of the room
+func (ctrl *roomController) closeWhep(roomCtx *roomContext, peerContext *sfu.PeerContext, resourceID string) {
+	peerContext.Close(sfu.ErrPeerConnectionClosed)
+	roomCtx.whep.Remove(resourceID)
+}
+
+// Token of the http signaling is optional as for the websocket join
+func (ctrl *roomController) bearerIdentity(ctx echo.Context) error {
+	_, err := ctrl.bearerToken(ctx)
+	return err
+}
+
+// Nil token context when request has no token
+func (ctrl *roomController) bearerToken(ctx echo.Context) (*identity.TokenContext, error) {
+	accessToken, found := strings.CutPrefix(ctx.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+	if !found {
+		return nil, nil
+	}
+
+	tokenCtx, err := ctrl.identityService.TokenIdentity(ctx.Request().Context(), accessToken)
+	if err != nil || tokenCtx.TokenUse != identity.ACCESS_TOKEN {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, ErrIdentityRequired.Error())
+	}
+	return tokenCtx, nil
+}
+
+// Room control api is not public, unlike bearerIdentity the token is required
+func (ctrl *roomController) requireIdentity(ctx echo.Context) (*identity.TokenContext, error) {
+	tokenCtx, err := ctrl.bearerToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if tokenCtx == nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, ErrIdentityRequired.Error())
+	}
+	return tokenCtx, nil
+}
+
+// Media of the room leaves the server or is stored, e.g. recording. Only admin or the user who created the room
+func (ctrl *roomController) requireRoomOwner(ctx echo.Context, roomCtx *roomContext) error {
+	tokenCtx, err := ctrl.requireIdentity(ctx)
+	if err != nil {
+		return err
+	}
+	if !tokenCtx.IsAdmin() && !roomCtx.IsOwner(tokenCtx.UserID) {
+		return echo.NewHTTPError(http.StatusForbidden, ErrRoomPermissionDenied.Error())
+	}
+	return nil
+}
+
+// Forward sends media to any address, so it's not granted to the room owner
+func (ctrl *roomController) requireAdmin(ctx echo.Context) error {
+	tokenCtx, err := ctrl.requireIdentity(ctx)
+	if err != nil {
+		return err
+	}
+	if !tokenCtx.IsAdmin() {
+		return echo.NewHTTPError(http.StatusForbidden, ErrRoomPermissionDenied.Error())
+	}
+	return nil
+}
+
+func (ctrl *roomController) closeWhip(roomCtx *roomContext, peerContext *sfu.PeerContext, resourceID string) {
+	peerContext.Close(sfu.ErrPeerConnectionClosed)
+	roomCtx.peerContextPool.Remove(peerContext)
+	roomCtx.whip.Remove(resourceID)
+	ctrl.roomNotifier.DispatchUpdateRooms()
+}
+
+func (ctrl *roomController) dispatchSession(peerContext *sfu.PeerContext, token string) {
+	if err := peerContext.Signal.DispatchEvent("session", SessionMessage{
+		Token:  token,
+		PeerID: peerContext.PeerID(),
+	}); err != nil {
+		log.Println("[RoomJoin] Unable send session. Err:", err)
+	}
+}
+
+func (ctrl *roomController) dispatchRecording(roomCtx *roomContext, peerContext *sfu.PeerContext) {
+	if err := peerContext.Signal.DispatchEvent("recording", roomCtx.RecordingState()); err != nil {
+		log.Println("[RoomJoin] Unable send recording state. Err:", err)
+	}
+}
+
+type chatMessage struct {
+	Body string `json:"body"`
+}
+
+type chatHistoryMessage struct {
+	Messages []chat.Message `json:"messages"`
+}
+
+type chatErrorMessage struct {
+	Message string `json:"message"`
+}
+
+func (ctrl *roomController) dispatchChatHistory(roomCtx *roomContext, peerContext *sfu.PeerContext) {
+	messages, err := ctrl.chatService.Recent(roomCtx.ctx, roomCtx.instanceID)
+	if err != nil {
+		log.Println("[RoomJoin] Unable get chat history. Err:", err)
+		return
+	}
+
+	if err = peerContext.Signal.DispatchEvent("chat-history", chatHistoryMessage{
+		Messages: messages,
+	}); err != nil {
+		log.Println("[RoomJoin] Unable send chat history. Err:", err)
+	}
+}
 
-		filtersBytes, err := json.Marshal(peerFilters)
-		if err != nil {
+// Store message of the session user and send it to everyone in the room, sender gets it back with id
+func (ctrl *roomController) chat(roomCtx *roomContext, peerContext *sfu.PeerContext, token string, msg chatMessage) error {
+	userID, exist := roomCtx.sessions.UserID(token)
+	if !exist {
+		return ErrIdentityRequired
+	}
+
+	message, err := ctrl.chatService.Send(roomCtx.ctx, roomCtx.roomID, roomCtx.instanceID, userID, msg.Body)
+	if err != nil {
+		return err
+	}
+
+	for _, peer := range roomCtx.peerContextPool.Get() {
+		if err = peer.Signal.DispatchEvent("chat", message); err != nil {
+			log.Printf("[Chat] Unable dispatch to %s. Err: %s", peer.PeerID(), err)
+		}
+	}
+
+	// NOTE: Sender may be not in the pool until its peer connection is connected
+	if !roomCtx.peerContextPool.Exist(peerContext) {
+		return peerContext.Signal.DispatchEvent("chat", message)
+	}
+	return nil
+}
+
+func (ctrl *roomController) dispatchFilters(peerContext *sfu.PeerContext) {
+retry:
+	if err := peerContext.Signal.DispatchEvent("filters", peerContext.Filters()); err != nil {
+		select {
+		case <-peerContext.Done():
+			return
+		default:
 			time.Sleep(time.Second)
 			goto retry
 		}
+	}
+}
 
-		if err = w.WriteJSON(&websocketMessage{
-			Event: "filters",
-			Data:  string(filtersBytes),
-		}); err != nil {
-			select {
-			case <-peerContext.Done():
-				return
-			default:
-				time.Sleep(time.Second)
-				goto retry
-			}
+// Read signal messages of the websocket. When websocket drops peer context is kept for the grace period
+func (ctrl *roomController) serveSignal(w *wsutils.ThreadSafeWriter, roomCtx *roomContext, peerContext *sfu.PeerContext, token string, generation uint64) error {
+	defer func() {
+		select {
+		case <-peerContext.Done():
+			ctrl.closePeer(roomCtx, peerContext, token)
+		default:
+			roomCtx.sessions.Detach(token, generation, func() {
+				log.Printf("[RoomJoin] peer %s session expired", peerContext.PeerID())
+				ctrl.closePeer(roomCtx, peerContext, token)
+			})
 		}
 	}()
 
-	go peerContext.SynchronizeOfferState()
-
 	message := &websocketMessage{}
 	for {
 		if err := w.ReadJSON(message); err != nil {
@@ -223,12 +643,27 @@ func (ctrl *roomController) RoomControllerRoomJoin(ctx echo.Context, roomId stri
 			if err := peerContext.Signal.OnCandidate([]byte(message.Data)); err != nil {
 				return ctrl.wsError(w, err)
 			}
+		case "offer":
+			if err := peerContext.Signal.OnOffer([]byte(message.Data)); err != nil {
+				return ctrl.wsError(w, err)
+			}
 		case "answer":
 			if err := peerContext.Signal.OnAnswer([]byte(message.Data)); err != nil {
 				return ctrl.wsError(w, err)
 			}
 		case "subscribe":
-			if err := peerContext.Signal.DispatchOffer(); err != nil {
+			var subscribe SubscribeMessage
+			if message.Data != "" {
+				if err := json.Unmarshal([]byte(message.Data), &subscribe); err != nil {
+					return ctrl.wsError(w, err)
+				}
+			}
+
+			dispatch := peerContext.Signal.DispatchOffer
+			if subscribe.RestartICE {
+				dispatch = peerContext.Signal.DispatchRestartICE
+			}
+			if err := dispatch(); err != nil {
 				return ctrl.wsError(w, err)
 			}
 
@@ -244,13 +679,100 @@ func (ctrl *roomController) RoomControllerRoomJoin(ctx echo.Context, roomId stri
 				// return ctrl.wsError(w, err)
 			}
 
+		case "simulcast-layer":
+			var layer sfu.SimulcastLayerMessage
+			if err := json.Unmarshal([]byte(message.Data), &layer); err != nil {
+				return ctrl.wsError(w, err)
+			}
+
+			if err := peerContext.SetSimulcastLayer(layer); err != nil {
+				log.Println("[simulcast-layer] Unable switch layer. Err:", err)
+			}
+
+		case "track-metadata":
+			var metadata sfu.TrackMetadataMessage
+			if err := json.Unmarshal([]byte(message.Data), &metadata); err != nil {
+				return ctrl.wsError(w, err)
+			}
+
+			if err := peerContext.SetTrackMetadata(metadata); err != nil {
+				log.Println("[track-metadata] Unable update track metadata. Err:", err)
+			}
+
+		case "mute":
+			var mute sfu.MuteMessage
+			if err := json.Unmarshal([]byte(message.Data), &mute); err != nil {
+				return ctrl.wsError(w, err)
+			}
+
+			if err := peerContext.SetTrackMuted(mute); err != nil {
+				log.Println("[mute] Unable mute track. Err:", err)
+			}
+
+		case "audio-mix":
+			var audioMix sfu.AudioMixMessage
+			if err := json.Unmarshal([]byte(message.Data), &audioMix); err != nil {
+				return ctrl.wsError(w, err)
+			}
+
+			if err := peerContext.SetAudioMix(audioMix); err != nil {
+				log.Println("[audio-mix] Unable switch audio mix. Err:", err)
+			}
+
+		case "chat":
+			var msg chatMessage
+			if err := json.Unmarshal([]byte(message.Data), &msg); err != nil {
+				return ctrl.wsError(w, err)
+			}
+
+			if err := ctrl.chat(roomCtx, peerContext, token, msg); err != nil {
+				log.Println("[chat] Unable send chat message. Err:", err)
+				_ = peerContext.Signal.DispatchEvent("chat-error", chatErrorMessage{
+					Message: err.Error(),
+				})
+			}
+
+		case "track-pause":
+			var pause sfu.TrackPauseMessage
+			if err := json.Unmarshal([]byte(message.Data), &pause); err != nil {
+				return ctrl.wsError(w, err)
+			}
+
+			if err := peerContext.SetTrackPaused(pause); err != nil {
+				log.Println("[track-pause] Unable pause track. Err:", err)
+			}
+
+		case "track-subscribe", "track-unsubscribe":
+			var subscription sfu.SubscriptionMessage
+			if err := json.Unmarshal([]byte(message.Data), &subscription); err != nil {
+				return ctrl.wsError(w, err)
+			}
+
+			subscribe := peerContext.Subscribe
+			if message.Event == "track-unsubscribe" {
+				subscribe = peerContext.Unsubscribe
+			}
+			if err := subscribe(subscription); err != nil {
+				log.Printf("[%s] Unable update subscription. Err: %s", message.Event, err)
+			}
+
+		case "auto-subscribe":
+			var autoSubscribe sfu.AutoSubscribeMessage
+			if err := json.Unmarshal([]byte(message.Data), &autoSubscribe); err != nil {
+				return ctrl.wsError(w, err)
+			}
+
+			if err := peerContext.SetAutoSubscribe(autoSubscribe); err != nil {
+				log.Println("[auto-subscribe] Unable update subscription. Err:", err)
+			}
+
 		case "filter":
 			var fData filterData
 			if err := json.Unmarshal([]byte(message.Data), &fData); err != nil {
 				return ctrl.wsError(w, err)
 			}
 
-			if err := peerContext.SwitchFilter(fData.Name, fData.MimeType); err != nil {
+			if err := peerContext.SwitchFilter(fData.TrackID, fData.Name, fData.MimeType); err != nil {
 				log.Println(err)
 				return ctrl.wsError(w, err)
 			}
@@ -261,6 +783,306 @@ func (ctrl *roomController) RoomControllerRoomJoin(ctx echo.Context, roomId stri
 	}
 }
 
+func (ctrl *roomController) RoomControllerRecordingStart(ctx echo.Context, roomId string) error {
+	roomCtx := ctrl.roomService.GetRoom(roomId)
+	if roomCtx == nil {
+		return echo.NewHTTPError(http.StatusNotFound, ErrRoomNotExist.Error())
+	}
+
+	if err := ctrl.requireRoomOwner(ctx, roomCtx); err != nil {
+		return err
+	}
+
+	var request room.RecordingStartRequest
+	// NOTE: Body is optional
+	if err := json.NewDecoder(ctx.Request().Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	mode := recorder.MODE_TRACKS
+	if request.Mode != nil {
+		mode = recorder.Mode(*request.Mode)
+	}
+
+	recording, err := roomCtx.StartRecording(ctrl.recorders, mode, ctrl.recordingsDir)
+	if errors.Is(err, recorder.ErrInvalidRecorderMode) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if errors.Is(err, ErrRecordingAlreadyStarted) {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, recording)
+}
+
+func (ctrl *roomController) RoomControllerRecordingStop(ctx echo.Context, roomId string) error {
+	roomCtx := ctrl.roomService.GetRoom(roomId)
+	if roomCtx == nil {
+		return echo.NewHTTPError(http.StatusNotFound, ErrRoomNotExist.Error())
+	}
+
+	if err := ctrl.requireRoomOwner(ctx, roomCtx); err != nil {
+		return err
+	}
+
+	recording, err := roomCtx.StopRecording()
+	if errors.Is(err, ErrRecordingNotStarted) {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, recording)
+}
+
+func (ctrl *roomController) RoomControllerEgressStart(ctx echo.Context, roomId string) error {
+	roomCtx := ctrl.roomService.GetRoom(roomId)
+	if roomCtx == nil {
+		return echo.NewHTTPError(http.StatusNotFound, ErrRoomNotExist.Error())
+	}
+
+	if err := ctrl.requireRoomOwner(ctx, roomCtx); err != nil {
+		return err
+	}
+
+	var request room.EgressStartRequest
+	if err := json.NewDecoder(ctx.Request().Body).Decode(&request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	params := egress.Params{
+		RoomID:  roomId,
+		BaseDir: ctrl.hlsDir,
+	}
+	if request.Url != nil {
+		params.URL = *request.Url
+	}
+	if request.Protocol != nil {
+		params.Protocol = egress.Protocol(*request.Protocol)
+	}
+	if request.ParticipantId != nil {
+		if !roomCtx.HasParticipant(*request.ParticipantId) {
+			return echo.NewHTTPError(http.StatusNotFound, sfu.ErrParticipantNotFound.Error())
+		}
+		params.ParticipantID = *request.ParticipantId
+	}
+
+	e, err := roomCtx.StartEgress(ctrl.egresses, params)
+	if errors.Is(err, egress.ErrInvalidEgressURL) || errors.Is(err, egress.ErrUnsupportedProtocol) || errors.Is(err, egress.ErrInvalidHlsDir) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	ctrl.roomNotifier.DispatchUpdateRooms()
+	return ctx.JSON(http.StatusOK, e)
+}
+
+func (ctrl *roomController) RoomControllerEgressStop(ctx echo.Context, roomId string, egressId string) error {
+	roomCtx := ctrl.roomService.GetRoom(roomId)
+	if roomCtx == nil {
+		return echo.NewHTTPError(http.StatusNotFound, ErrRoomNotExist.Error())
+	}
+
+	if err := ctrl.requireRoomOwner(ctx, roomCtx); err != nil {
+		return err
+	}
+
+	e, err := roomCtx.StopEgress(egressId)
+	if errors.Is(err, ErrEgressNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	ctrl.roomNotifier.DispatchUpdateRooms()
+	return ctx.JSON(http.StatusOK, e)
+}
+
+// Ingest of the external source. Source is published by the synthetic participant without peer connection media
+func (ctrl *roomController) RoomControllerIngestStart(ctx echo.Context, roomId string) error {
+	roomCtx := ctrl.roomService.GetRoom(roomId)
+	if roomCtx == nil {
+		return echo.NewHTTPError(http.StatusNotFound, ErrRoomNotExist.Error())
+	}
+
+	if err := ctrl.requireRoomOwner(ctx, roomCtx); err != nil {
+		return err
+	}
+
+	var request room.IngestStartRequest
+	if err := json.NewDecoder(ctx.Request().Body).Decode(&request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	params := ingest.Params{
+		RoomID:   roomId,
+		Protocol: ingest.Protocol(request.Protocol),
+	}
+	if request.Url != nil {
+		params.URL = *request.Url
+	}
+	if request.Port != nil {
+		params.Port = *request.Port
+	}
+	if request.Codec != nil {
+		params.Codec = ingest.Codec(*request.Codec)
+	}
+	if request.PayloadType != nil {
+		// NOTE: Rtp payload type has 7 bits
+		if *request.PayloadType < 0 || *request.PayloadType > 127 {
+			return echo.NewHTTPError(http.StatusBadRequest, ingest.ErrInvalidIngestSource.Error())
+		}
+		params.PayloadType = uint8(*request.PayloadType)
+	}
+	if request.Label != nil {
+		params.Label = *request.Label
+	}
+
+	ctrl.peerConnectionMu.Lock()
+	peerContext, err := sfu.NewPeerContext(sfu.NewPeerContextParams{
+		Context:          roomCtx.ctx,
+		API:              ctrl.webrtc,
+		PipeAllocContext: ctrl.pipeAllocContext,
+		Spreader:         roomCtx.peerContextPool,
+		PublishOnly:      true,
+	})
+	if err != nil {
+		ctrl.peerConnectionMu.Unlock()
+		return err
+	}
+	peerContext.SetStats(<-ctrl.stats)
+	peerContext.SetBandwidthEstimator(<-ctrl.estimators)
+	ctrl.peerConnectionMu.Unlock()
+
+	peerContext.OnTrack()
+
+	info, err := roomCtx.StartIngest(ctrl.ingests, peerContext, params)
+	if errors.Is(err, ingest.ErrInvalidIngestSource) || errors.Is(err, ingest.ErrUnsupportedProtocol) || errors.Is(err, ingest.ErrUnsupportedCodec) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	ctrl.roomNotifier.DispatchUpdateRooms()
+	return ctx.JSON(http.StatusOK, info)
+}
+
+func (ctrl *roomController) RoomControllerIngestStop(ctx echo.Context, roomId string, ingestId string) error {
+	roomCtx := ctrl.roomService.GetRoom(roomId)
+	if roomCtx == nil {
+		return echo.NewHTTPError(http.StatusNotFound, ErrRoomNotExist.Error())
+	}
+
+	if err := ctrl.requireRoomOwner(ctx, roomCtx); err != nil {
+		return err
+	}
+
+	info, err := roomCtx.StopIngest(ingestId)
+	if errors.Is(err, ErrIngestNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	ctrl.roomNotifier.DispatchUpdateRooms()
+	return ctx.JSON(http.StatusOK, info)
+}
+
+func (ctrl *roomController) RoomControllerForwardList(ctx echo.Context, roomId string) error {
+	if err := ctrl.requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	roomCtx := ctrl.roomService.GetRoom(roomId)
+	if roomCtx == nil {
+		return echo.NewHTTPError(http.StatusNotFound, ErrRoomNotExist.Error())
+	}
+	return ctx.JSON(http.StatusOK, room.ForwardListResponse{
+		Forwards: roomCtx.Forwards(),
+	})
+}
+
+// Track mirror for the external receivers, e.g. ffmpeg or sip gateway. It's not visible to participants
+func (ctrl *roomController) RoomControllerForwardStart(ctx echo.Context, roomId string) error {
+	if err := ctrl.requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	roomCtx := ctrl.roomService.GetRoom(roomId)
+	if roomCtx == nil {
+		return echo.NewHTTPError(http.StatusNotFound, ErrRoomNotExist.Error())
+	}
+
+	var request room.ForwardStartRequest
+	if err := json.NewDecoder(ctx.Request().Body).Decode(&request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	var srtpKey string
+	if request.SrtpKey != nil {
+		srtpKey = *request.SrtpKey
+	}
+
+	forward, err := roomCtx.StartForward(request.TrackId, request.Target, srtpKey)
+	if errors.Is(err, sfu.ErrTrackNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if errors.Is(err, egress.ErrInvalidForwardTarget) || errors.Is(err, egress.ErrInvalidSRTPKey) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, forward)
+}
+
+func (ctrl *roomController) RoomControllerForwardGet(ctx echo.Context, roomId string, forwardId string) error {
+	if err := ctrl.requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	roomCtx := ctrl.roomService.GetRoom(roomId)
+	if roomCtx == nil {
+		return echo.NewHTTPError(http.StatusNotFound, ErrRoomNotExist.Error())
+	}
+
+	forward, err := roomCtx.Forward(forwardId)
+	if errors.Is(err, ErrForwardNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, forward)
+}
+
+func (ctrl *roomController) RoomControllerForwardStop(ctx echo.Context, roomId string, forwardId string) error {
+	if err := ctrl.requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	roomCtx := ctrl.roomService.GetRoom(roomId)
+	if roomCtx == nil {
+		return echo.NewHTTPError(http.StatusNotFound, ErrRoomNotExist.Error())
+	}
+
+	forward, err := roomCtx.StopForward(forwardId)
+	if errors.Is(err, ErrForwardNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, forward)
+}
+
 func (*roomController) RoomControllerRoomDelete(ctx echo.Context, sessionID string) error {
 	panic("unimplemented")
 }
@@ -274,6 +1096,7 @@ func (ctrl *roomController) RoomControllerRoomList(ctx echo.Context) error {
 type RoomCreateOption struct {
 	MaxParticipants int32
 	RoomID          *string
+	OwnerID         uuid.UUID
 }
 
 func (ctrl *roomController) RoomControllerRoomCreate(ctx echo.Context) error {
@@ -282,10 +1105,24 @@ func (ctrl *roomController) RoomControllerRoomCreate(ctx echo.Context) error {
 		return err
 	}
 
+	// NOTE: Anonymous room has no owner, it's controlled only by admin
+	tokenCtx, err := ctrl.bearerToken(ctx)
+	if err != nil {
+		return err
+	}
+	ownerID := uuid.Nil
+	if tokenCtx != nil {
+		ownerID = tokenCtx.UserID
+	}
+
 	room, err := ctrl.roomService.CreateRoom(&RoomCreateOption{
 		RoomID:          request.RoomId,
 		MaxParticipants: *request.MaxParticipants,
+		OwnerID:         ownerID,
 	})
+	if errors.Is(err, ErrInvalidRoomID) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
 	if err != nil {
 		return err
 	}
@@ -325,6 +1162,8 @@ func (ctrl *roomController) Resolve(c *echo.Echo) error {
 	}
 	spec.Servers = nil
 	room.RegisterHandlers(c, ctrl)
+	// NOTE: Hls viewers are served without the sfu
+	c.Static(_HLS_ROUTE, ctrl.hlsDir)
 	return nil
 }
 
@@ -341,8 +1180,14 @@ type newRoomController_Params struct {
 	API              *webrtc.API
 	Logger           *slog.Logger
 	Stats            chan *rtpstats.RtpStats
+	Estimators       chan *bwe.BandwidthEstimator
 	RoomNotifier     *RoomNotifier
 	PipeAllocContext *sfu.AllocatorsContext
+	ChatService      *chat.ChatService
+	IdentityService  *identity.IdentityService
+	Recorders        *recorder.AllocatorsContext
+	Egresses         *egress.AllocatorsContext
+	Ingests          *ingest.AllocatorsContext
 }
 
 func NewRoomController(params newRoomController_Params) *roomController {
@@ -350,6 +1195,7 @@ func NewRoomController(params newRoomController_Params) *roomController {
 		lifecycle:   params.Lifecycle,
 		webrtc:      params.API,
 		stats:       params.Stats,
+		estimators:  params.Estimators,
 		logger:      params.Logger,
 		roomService: params.RoomService,
 		upgrader: websocket.Upgrader{
@@ -357,5 +1203,12 @@ func NewRoomController(params newRoomController_Params) *roomController {
 		},
 		roomNotifier:     params.RoomNotifier,
 		pipeAllocContext: params.PipeAllocContext,
+		chatService:      params.ChatService,
+		identityService:  params.IdentityService,
+		recordingsDir:    variables.Env(variables.RECORDINGS_DIR, variables.RECORDINGS_DIR_DEFAULT),
+		recorders:        params.Recorders,
+		egresses:         params.Egresses,
+		ingests:          params.Ingests,
+		hlsDir:           variables.Env(variables.HLS_DIR, variables.HLS_DIR_DEFAULT),
 	}
 }
@@ -0,0 +1,19 @@
+package room
+
+import (
+	"context"
+	"testing"
+
+	"github.com/romashorodok/conferencing-platform/pkg/controller/room"
+)
+
+// Controller is not resolved with the broken embedded spec
+func TestEmbeddedSpec(t *testing.T) {
+	spec, err := room.GetSwagger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = spec.Validate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,101 @@
+package room
+
+import (
+	"errors"
+	"log"
+	"net/url"
+	"sort"
+
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/egress"
+	"github.com/romashorodok/conferencing-platform/pkg/controller/room"
+)
+
+// Path of the echo static which serves the hls dir
+const _HLS_ROUTE = "/hls"
+
+func (r *roomContext) StartEgress(allocators *egress.AllocatorsContext, params egress.Params) (room.Egress, error) {
+	e, err := allocators.Allocate(params)
+	if err != nil {
+		return room.Egress{}, err
+	}
+
+	r.egressMu.Lock()
+	defer r.egressMu.Unlock()
+
+	r.peerContextPool.AddTrackListenerWithSnapshot(e)
+	r.egress[e.ID()] = e
+
+	log.Printf("[Egress] room %s %s egress %s started", r.roomID, e.Protocol(), e.ID())
+	return r.egressInfo(e), nil
+}
+
+// Failed egress is kept in the room info until it's stopped
+func (r *roomContext) StopEgress(egressID string) (room.Egress, error) {
+	r.egressMu.Lock()
+	defer r.egressMu.Unlock()
+
+	e, exist := r.egress[egressID]
+	if !exist {
+		return room.Egress{}, ErrEgressNotFound
+	}
+	delete(r.egress, egressID)
+	r.peerContextPool.RemoveTrackListener(e)
+
+	if err := e.Stop(); err != nil && !errors.Is(err, egress.ErrEgressStopped) {
+		return room.Egress{}, err
+	}
+	return r.egressInfo(e), nil
+}
+
+func (r *roomContext) stopEgress() {
+	r.egressMu.Lock()
+	ids := make([]string, 0, len(r.egress))
+	for id := range r.egress {
+		ids = append(ids, id)
+	}
+	r.egressMu.Unlock()
+
+	for _, id := range ids {
+		if _, err := r.StopEgress(id); err != nil {
+			log.Printf("[Egress] Unable stop egress %s of canceled room. Err: %s", id, err)
+		}
+	}
+}
+
+func (r *roomContext) Egress() []room.Egress {
+	r.egressMu.Lock()
+	defer r.egressMu.Unlock()
+
+	result := make([]room.Egress, 0, len(r.egress))
+	for _, e := range r.egress {
+		result = append(result, r.egressInfo(e))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartedAt.Before(result[j].StartedAt)
+	})
+	return result
+}
+
+// NOTE: Url is not reported, it usually has the stream key
+func (r *roomContext) egressInfo(e egress.Egress) room.Egress {
+	info := room.Egress{
+		EgressId:  e.ID(),
+		Protocol:  room.EgressProtocol(e.Protocol()),
+		State:     room.EgressState(e.State()),
+		StartedAt: e.StartedAt(),
+	}
+	if participantID := e.ParticipantID(); participantID != "" {
+		info.ParticipantId = &participantID
+	}
+	if e.Protocol() == egress.PROTOCOL_HLS {
+		// NOTE: Room id may have spaces
+		playlist := url.URL{Path: _HLS_ROUTE + "/" + egress.HlsPlaylist(r.roomID, e.ID())}
+		playlistURL := playlist.EscapedPath()
+		info.PlaylistUrl = &playlistURL
+	}
+	if err := e.Err(); err != nil {
+		message := err.Error()
+		info.Error = &message
+	}
+	return info
+}
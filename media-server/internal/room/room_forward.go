@@ -0,0 +1,123 @@
+package room
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"sort"
+
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/egress"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/sfu"
+	"github.com/romashorodok/conferencing-platform/pkg/controller/room"
+)
+
+func (r *roomContext) publishedTrack(trackID string) (*sfu.TrackContext, bool) {
+	for _, t := range r.peerContextPool.PublishedTracks() {
+		if t.ID() == trackID {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+func (r *roomContext) StartForward(trackID, target, srtpKey string) (room.Forward, error) {
+	t, exist := r.publishedTrack(trackID)
+	if !exist {
+		return room.Forward{}, sfu.ErrTrackNotFound
+	}
+
+	f, err := egress.NewRTPForward(t, target, srtpKey)
+	if err != nil {
+		return room.Forward{}, err
+	}
+
+	r.forwardsMu.Lock()
+	defer r.forwardsMu.Unlock()
+
+	if !slices.Contains(r.peerContextPool.AddTrackListenerWithSnapshot(f), t) {
+		r.peerContextPool.RemoveTrackListener(f)
+		_ = f.Stop()
+		return room.Forward{}, sfu.ErrTrackNotFound
+	}
+	r.forwards[f.ID()] = f
+
+	log.Printf("[RTPForward] room %s forward %s of track %s started", r.roomID, f.ID(), trackID)
+	return forwardInfo(f), nil
+}
+
+func (r *roomContext) StopForward(forwardID string) (room.Forward, error) {
+	r.forwardsMu.Lock()
+	defer r.forwardsMu.Unlock()
+
+	f, exist := r.forwards[forwardID]
+	if !exist {
+		return room.Forward{}, ErrForwardNotFound
+	}
+	delete(r.forwards, forwardID)
+	r.peerContextPool.RemoveTrackListener(f)
+
+	// NOTE: Forward of the unpublished track is already stopped
+	if err := f.Stop(); err != nil && !errors.Is(err, egress.ErrForwardStopped) {
+		return room.Forward{}, err
+	}
+	return forwardInfo(f), nil
+}
+
+func (r *roomContext) stopForwards() {
+	r.forwardsMu.Lock()
+	ids := make([]string, 0, len(r.forwards))
+	for id := range r.forwards {
+		ids = append(ids, id)
+	}
+	r.forwardsMu.Unlock()
+
+	for _, id := range ids {
+		if _, err := r.StopForward(id); err != nil {
+			log.Printf("[RTPForward] Unable stop forward %s of canceled room. Err: %s", id, err)
+		}
+	}
+}
+
+func (r *roomContext) Forward(forwardID string) (room.Forward, error) {
+	r.forwardsMu.Lock()
+	defer r.forwardsMu.Unlock()
+
+	f, exist := r.forwards[forwardID]
+	if !exist {
+		return room.Forward{}, ErrForwardNotFound
+	}
+	return forwardInfo(f), nil
+}
+
+func (r *roomContext) Forwards() []room.Forward {
+	r.forwardsMu.Lock()
+	defer r.forwardsMu.Unlock()
+
+	result := make([]room.Forward, 0, len(r.forwards))
+	for _, f := range r.forwards {
+		result = append(result, forwardInfo(f))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartedAt.Before(result[j].StartedAt)
+	})
+	return result
+}
+
+// NOTE: Srtp key is not reported, it's only in the sdp for the receiver
+func forwardInfo(f *egress.RTPForward) room.Forward {
+	stats := f.Stats()
+	return room.Forward{
+		ForwardId:     f.ID(),
+		TrackId:       f.Track().ID(),
+		ParticipantId: f.Track().SourcePeerID,
+		Kind:          room.TrackKind(f.Track().Kind().String()),
+		Target:        f.Target(),
+		Srtp:          f.SRTP(),
+		State:         room.EgressState(f.State()),
+		StartedAt:     f.StartedAt(),
+		Packets:       int64(stats.Packets),
+		Bytes:         int64(stats.Bytes),
+		Dropped:       int64(stats.Dropped),
+		Sdp:           f.SDP(),
+	}
+}
@@ -0,0 +1,101 @@
+package room
+
+import (
+	"errors"
+	"log"
+	"sort"
+
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/ingest"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/sfu"
+	"github.com/romashorodok/conferencing-platform/pkg/controller/room"
+)
+
+// Synthetic participant joins first, so tracks of the source are spread to everyone in the room
+func (r *roomContext) StartIngest(allocators *ingest.AllocatorsContext, peer *sfu.PeerContext, params ingest.Params) (room.Ingest, error) {
+	if err := r.peerContextPool.Add(peer); err != nil {
+		_ = peer.Close(sfu.ErrPeerConnectionClosed)
+		return room.Ingest{}, err
+	}
+
+	i, err := allocators.Allocate(peer, params)
+	if err != nil {
+		_ = r.peerContextPool.Remove(peer)
+		return room.Ingest{}, err
+	}
+
+	r.ingestMu.Lock()
+	r.ingest[i.ID()] = i
+	r.ingestMu.Unlock()
+
+	log.Printf("[Ingest] room %s %s ingest %s started", r.roomID, i.Protocol(), i.ID())
+	return ingestInfo(i), nil
+}
+
+func (r *roomContext) StopIngest(ingestID string) (room.Ingest, error) {
+	r.ingestMu.Lock()
+	i, exist := r.ingest[ingestID]
+	if !exist {
+		r.ingestMu.Unlock()
+		return room.Ingest{}, ErrIngestNotFound
+	}
+	delete(r.ingest, ingestID)
+	r.ingestMu.Unlock()
+
+	// NOTE: Ended or failed source is already stopped, participant is still in the pool
+	err := i.Stop()
+	if removeErr := r.peerContextPool.Remove(i.Participant()); removeErr != nil {
+		log.Printf("[Ingest] %s unable remove participant. Err: %s", ingestID, removeErr)
+	}
+	if err != nil && !errors.Is(err, ingest.ErrIngestStopped) {
+		return room.Ingest{}, err
+	}
+	return ingestInfo(i), nil
+}
+
+func (r *roomContext) stopIngest() {
+	r.ingestMu.Lock()
+	ids := make([]string, 0, len(r.ingest))
+	for id := range r.ingest {
+		ids = append(ids, id)
+	}
+	r.ingestMu.Unlock()
+
+	for _, id := range ids {
+		if _, err := r.StopIngest(id); err != nil {
+			log.Printf("[Ingest] Unable stop ingest %s of canceled room. Err: %s", id, err)
+		}
+	}
+}
+
+func (r *roomContext) Ingest() []room.Ingest {
+	r.ingestMu.Lock()
+	defer r.ingestMu.Unlock()
+
+	result := make([]room.Ingest, 0, len(r.ingest))
+	for _, i := range r.ingest {
+		result = append(result, ingestInfo(i))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartedAt.Before(result[j].StartedAt)
+	})
+	return result
+}
+
+// NOTE: Url is not reported, rtsp url usually has the credentials
+func ingestInfo(i ingest.Ingest) room.Ingest {
+	info := room.Ingest{
+		IngestId:      i.ID(),
+		Protocol:      room.IngestProtocol(i.Protocol()),
+		ParticipantId: i.Participant().PeerID(),
+		State:         room.EgressState(i.State()),
+		StartedAt:     i.StartedAt(),
+	}
+	if port := i.Port(); port != 0 {
+		info.Port = &port
+	}
+	if err := i.Err(); err != nil {
+		message := err.Error()
+		info.Error = &message
+	}
+	return info
+}
@@ -0,0 +1,91 @@
+package room
+
+import (
+	"log"
+	"time"
+
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/recorder"
+	"github.com/romashorodok/conferencing-platform/pkg/controller/room"
+)
+
+// Recording state which is sent to participants on change and on join
+type RecordingMessage struct {
+	Active      bool          `json:"active"`
+	RecordingID string        `json:"recordingId,omitempty"`
+	Mode        recorder.Mode `json:"mode,omitempty"`
+	StartedAt   *time.Time    `json:"startedAt,omitempty"`
+}
+
+func (r *roomContext) StartRecording(allocators *recorder.AllocatorsContext, mode recorder.Mode, baseDir string) (room.Recording, error) {
+	r.recordingMu.Lock()
+	defer r.recordingMu.Unlock()
+
+	if r.recording != nil {
+		return room.Recording{}, ErrRecordingAlreadyStarted
+	}
+
+	recording, err := allocators.Allocate(mode, baseDir, r.roomID)
+	if err != nil {
+		return room.Recording{}, err
+	}
+
+	r.peerContextPool.AddTrackListenerWithSnapshot(recording)
+	r.recording = recording
+
+	log.Printf("[Recorder] room %s %s recording %s started at %s", r.roomID, recording.Mode(), recording.ID(), recording.Dir())
+	r.peerContextPool.Broadcast("recording", r.recordingMessage())
+	return room.Recording{
+		RecordingId: recording.ID(),
+		RoomId:      r.roomID,
+		Mode:        room.RecordingMode(recording.Mode()),
+		Active:      true,
+		StartedAt:   recording.StartedAt(),
+	}, nil
+}
+
+func (r *roomContext) StopRecording() (room.Recording, error) {
+	r.recordingMu.Lock()
+	defer r.recordingMu.Unlock()
+
+	if r.recording == nil {
+		return room.Recording{}, ErrRecordingNotStarted
+	}
+
+	recording := r.recording
+	r.recording = nil
+	r.peerContextPool.RemoveTrackListener(recording)
+	r.peerContextPool.Broadcast("recording", r.recordingMessage())
+
+	manifest, err := recording.Stop()
+	if err != nil {
+		return room.Recording{}, err
+	}
+
+	return room.Recording{
+		RecordingId: manifest.RecordingID,
+		RoomId:      r.roomID,
+		Mode:        room.RecordingMode(manifest.Mode),
+		Active:      false,
+		StartedAt:   manifest.StartedAt,
+		StoppedAt:   &manifest.StoppedAt,
+	}, nil
+}
+
+func (r *roomContext) RecordingState() RecordingMessage {
+	r.recordingMu.Lock()
+	defer r.recordingMu.Unlock()
+	return r.recordingMessage()
+}
+
+func (r *roomContext) recordingMessage() RecordingMessage {
+	if r.recording == nil {
+		return RecordingMessage{}
+	}
+	startedAt := r.recording.StartedAt()
+	return RecordingMessage{
+		Active:      true,
+		RecordingID: r.recording.ID(),
+		Mode:        r.recording.Mode(),
+		StartedAt:   &startedAt,
+	}
+}
@@ -0,0 +1,51 @@
+package room
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/sfu"
+)
+
+var ErrPeerResourceNotFound = errors.New("peer resource not found")
+
+const _SDP_CONTENT_TYPE = "application/sdp"
+
+// Peers of the room which are signaled over http, e.g. WHIP encoder or WHEP player
+type resourceStore struct {
+	resourcesMu sync.Mutex
+	resources   map[string]*sfu.PeerContext
+}
+
+func (s *resourceStore) Add(peerContext *sfu.PeerContext) string {
+	s.resourcesMu.Lock()
+	defer s.resourcesMu.Unlock()
+
+	resourceID := uuid.NewString()
+	s.resources[resourceID] = peerContext
+	return resourceID
+}
+
+func (s *resourceStore) Get(resourceID string) (*sfu.PeerContext, error) {
+	s.resourcesMu.Lock()
+	defer s.resourcesMu.Unlock()
+
+	peerContext, exist := s.resources[resourceID]
+	if !exist {
+		return nil, ErrPeerResourceNotFound
+	}
+	return peerContext, nil
+}
+
+func (s *resourceStore) Remove(resourceID string) {
+	s.resourcesMu.Lock()
+	defer s.resourcesMu.Unlock()
+	delete(s.resources, resourceID)
+}
+
+func newResourceStore() *resourceStore {
+	return &resourceStore{
+		resources: make(map[string]*sfu.PeerContext),
+	}
+}
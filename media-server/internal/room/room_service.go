@@ -3,11 +3,16 @@ package room
 import (
 	"context"
 	"errors"
+	"log"
 	"log/slog"
+	"regexp"
 	"sync"
 
 	"github.com/google/uuid"
 	webrtc "github.com/pion/webrtc/v4"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/egress"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/ingest"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/recorder"
 	"github.com/romashorodok/conferencing-platform/media-server/pkg/sfu"
 	"github.com/romashorodok/conferencing-platform/pkg/controller/room"
 	"github.com/romashorodok/conferencing-platform/pkg/executils"
@@ -16,9 +21,19 @@ import (
 )
 
 var (
-	ErrRoomAlreadyExists = errors.New("room already exists")
-	ErrRoomNotExist      = errors.New("room not exist")
-	ErrRoomCancelByUser  = errors.New("room canceled by user")
+	ErrRoomAlreadyExists    = errors.New("room already exists")
+	ErrInvalidRoomID        = errors.New("room id must be up to 128 letters, digits, spaces, '_', '-' or '.' and start with a letter or digit")
+	ErrRoomNotExist         = errors.New("room not exist")
+	ErrRoomCancelByUser     = errors.New("room canceled by user")
+	ErrIdentityRequired     = errors.New("identity user required")
+	ErrRoomPermissionDenied = errors.New("room control is allowed only to admin or room owner")
+
+	ErrRecordingAlreadyStarted = errors.New("room recording already started")
+	ErrRecordingNotStarted     = errors.New("room recording not started")
+
+	ErrEgressNotFound  = errors.New("room egress not found")
+	ErrForwardNotFound = errors.New("room rtp forward not found")
+	ErrIngestNotFound  = errors.New("room ingest not found")
 )
 
 type RoomNotifier struct {
@@ -78,32 +93,137 @@ func NewRoomNotifier() *RoomNotifier {
 type roomContext struct {
 	roomID          string
 	peerContextPool *sfu.PeerContextPool
+	sessions        *sessionStore
+	whip            *resourceStore
+	whep            *resourceStore
+
+	// NOTE: Nil for the room created without identity
+	ownerID uuid.UUID
+
+	// NOTE: Room id may be used again after restart, chat is stored by the instance
+	instanceID uuid.UUID
+
+	// NOTE: Identity users which joined the room, they keep access to its chat after leave
+	membersMu sync.Mutex
+	members   map[uuid.UUID]struct{}
+
+	recordingMu sync.Mutex
+	recording   recorder.Recorder
+
+	egressMu sync.Mutex
+	egress   map[string]egress.Egress
+
+	forwardsMu sync.Mutex
+	forwards   map[string]*egress.RTPForward
+
+	ingestMu sync.Mutex
+	ingest   map[string]ingest.Ingest
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+}
+
+func (r *roomContext) Cancel(err error) {
+	if _, stopErr := r.StopRecording(); stopErr != nil && !errors.Is(stopErr, ErrRecordingNotStarted) {
+		log.Println("[Recorder] Unable stop recording of canceled room. Err:", stopErr)
+	}
+	r.stopEgress()
+	r.stopForwards()
+	r.stopIngest()
+	r.cancel(err)
+}
+
+func (r *roomContext) IsOwner(userID uuid.UUID) bool {
+	return r.ownerID != uuid.Nil && r.ownerID == userID
+}
+
+func (r *roomContext) AddMember(userID uuid.UUID) {
+	r.membersMu.Lock()
+	defer r.membersMu.Unlock()
+	r.members[userID] = struct{}{}
+}
+
+func (r *roomContext) IsMember(userID uuid.UUID) bool {
+	r.membersMu.Lock()
+	defer r.membersMu.Unlock()
+	_, exist := r.members[userID]
+	return exist
+}
+
+func (r *roomContext) HasParticipant(peerID string) bool {
+	for _, peer := range r.peerContextPool.Get() {
+		if peer.PeerID() == peerID {
+			return true
+		}
+	}
+	return false
 }
 
 func (r *roomContext) Info() room.Room {
 	participants := make([]room.Participant, 0)
 
 	for _, p := range r.peerContextPool.Get() {
+		tracks := make([]room.Track, 0)
+		for _, t := range p.PublishTracks() {
+			tracks = append(tracks, room.Track{
+				Id:            t.TrackID,
+				Kind:          room.TrackKind(t.Kind),
+				ParticipantId: t.ParticipantID,
+				StreamId:      t.StreamID,
+				Codec:         t.Codec,
+				Source:        room.TrackSource(t.Source),
+				Label:         t.Label,
+				Muted:         t.Muted,
+				Inactive:      t.Inactive,
+				Width:         t.Width,
+				Height:        t.Height,
+			})
+		}
+
+		retransmissions := p.Retransmissions()
 		participants = append(participants, room.Participant{
-			Id: p.PeerID(),
+			Id:     p.PeerID(),
+			Tracks: tracks,
+			Retransmissions: room.Retransmissions{
+				Hits:   int64(retransmissions.Hits),
+				Misses: int64(retransmissions.Misses),
+			},
 		})
 	}
 
 	return room.Room{
 		RoomId:       r.roomID,
 		Participants: participants,
+		Egress:       r.Egress(),
+		Ingest:       r.Ingest(),
 	}
 }
 
 type NewRoomContextParams struct {
-	RoomID string
+	RoomID  string
+	OwnerID uuid.UUID
 }
 
 func NewRoomContext(params NewRoomContextParams) *roomContext {
-	return &roomContext{
+	ctx, cancel := context.WithCancelCause(context.Background())
+
+	room := &roomContext{
 		roomID:          params.RoomID,
+		instanceID:      uuid.New(),
+		ownerID:         params.OwnerID,
 		peerContextPool: sfu.NewPeerContextPool(),
+		sessions:        newSessionStore(),
+		whip:            newResourceStore(),
+		whep:            newResourceStore(),
+		members:         make(map[uuid.UUID]struct{}),
+		egress:          make(map[string]egress.Egress),
+		forwards:        make(map[string]*egress.RTPForward),
+		ingest:          make(map[string]ingest.Ingest),
+		ctx:             ctx,
+		cancel:          cancel,
 	}
+	go room.peerContextPool.HandleActiveSpeakers(ctx)
+	return room
 }
 
 type RoomService struct {
@@ -123,6 +243,14 @@ func (s *RoomService) GetRoom(roomID string) *roomContext {
 	return room
 }
 
+func (s *RoomService) RoomInstance(roomID string, userID uuid.UUID) (uuid.UUID, bool) {
+	room := s.GetRoom(roomID)
+	if room == nil || userID == uuid.Nil || !room.IsMember(userID) {
+		return uuid.Nil, false
+	}
+	return room.instanceID, true
+}
+
 func (s *RoomService) ListRoom() []room.Room {
 	result := make([]room.Room, 0)
 	for _, room := range s.roomContextMap {
@@ -142,6 +270,9 @@ func (s *RoomService) ListRoom() []room.Room {
 // 	return nil
 // }
 
+// NOTE: Room id is a directory name of the recordings and hls egress
+var _roomIDPattern = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{N} _.-]{0,127}$`)
+
 func NullableRoomID(roomID *string) string {
 	if roomID != nil && *roomID != "" {
 		return *roomID
@@ -154,12 +285,16 @@ func (s *RoomService) CreateRoom(option *RoomCreateOption) (*roomContext, error)
 	defer s.Unlock()
 
 	roomID := NullableRoomID(option.RoomID)
+	if !_roomIDPattern.MatchString(roomID) {
+		return nil, ErrInvalidRoomID
+	}
 	if _, exist := s.roomContextMap[roomID]; exist {
 		return nil, ErrRoomAlreadyExists
 	}
 
 	s.roomContextMap[roomID] = NewRoomContext(NewRoomContextParams{
-		RoomID: roomID,
+		RoomID:  roomID,
+		OwnerID: option.OwnerID,
 	})
 
 	room, exist := s.roomContextMap[roomID]
@@ -0,0 +1,72 @@
+package room
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// Room id is a directory name of the recordings and hls egress
+func TestCreateRoomID(t *testing.T) {
+	s := NewRoomService(NewRoomServiceParams{RoomNotifier: NewRoomNotifier()})
+
+	tests := []struct {
+		roomID string
+		err    error
+	}{
+		{roomID: "daily"},
+		{roomID: "Team sync 2.0"},
+		{roomID: "кімната_1"},
+		{roomID: ""},
+		{roomID: "..", err: ErrInvalidRoomID},
+		{roomID: ".hidden", err: ErrInvalidRoomID},
+		{roomID: "../recordings", err: ErrInvalidRoomID},
+		{roomID: "room/../../x", err: ErrInvalidRoomID},
+		{roomID: `room\x`, err: ErrInvalidRoomID},
+		{roomID: "/tmp", err: ErrInvalidRoomID},
+		{roomID: strings.Repeat("a", 129), err: ErrInvalidRoomID},
+	}
+
+	for _, tt := range tests {
+		roomID := tt.roomID
+		room, err := s.CreateRoom(&RoomCreateOption{RoomID: &roomID})
+		if !errors.Is(err, tt.err) {
+			t.Fatalf("room id %q err %v, want %v", tt.roomID, err, tt.err)
+		}
+		if err == nil && room == nil {
+			t.Fatalf("room id %q is not created", tt.roomID)
+		}
+	}
+}
+
+// Chat history is stored by the room instance, room created again with the same id has new one
+func TestRoomInstance(t *testing.T) {
+	userID := uuid.New()
+	roomID := "daily"
+
+	instances := make(map[uuid.UUID]struct{})
+	for i := 0; i < 2; i++ {
+		s := NewRoomService(NewRoomServiceParams{RoomNotifier: NewRoomNotifier()})
+		room, err := s.CreateRoom(&RoomCreateOption{RoomID: &roomID})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, member := s.RoomInstance(roomID, userID); member {
+			t.Fatal("user is member before join")
+		}
+		room.AddMember(userID)
+
+		instanceID, member := s.RoomInstance(roomID, userID)
+		if !member || instanceID == uuid.Nil {
+			t.Fatalf("member %t of the instance %s", member, instanceID)
+		}
+		instances[instanceID] = struct{}{}
+	}
+
+	if len(instances) != 2 {
+		t.Fatal("recreated room has the same instance")
+	}
+}
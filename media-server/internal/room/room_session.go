@@ -0,0 +1,126 @@
+package room
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/sfu"
+)
+
+var ErrSessionNotFound = errors.New("session not found or expired")
+
+// How long peer context waits for the client to resume after websocket drop
+const _SESSION_RESUME_GRACE = 30 * time.Second
+
+type SessionMessage struct {
+	Token  string `json:"token"`
+	PeerID string `json:"peerId"`
+}
+
+type roomSession struct {
+	peerContext *sfu.PeerContext
+	// NOTE: Nil for anonymous participant
+	userID uuid.UUID
+	// NOTE: Each websocket attach has own generation. Old websocket which drops after resume must not expire the session
+	generation uint64
+	expire     *time.Timer
+}
+
+type sessionStore struct {
+	sessionsMu sync.Mutex
+	sessions   map[string]*roomSession
+	grace      time.Duration
+}
+
+func (s *sessionStore) Add(peerContext *sfu.PeerContext, userID uuid.UUID) (token string, generation uint64) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	token = uuid.NewString()
+	s.sessions[token] = &roomSession{
+		peerContext: peerContext,
+		userID:      userID,
+	}
+	return token, 0
+}
+
+// Identity user of the session
+func (s *sessionStore) UserID(token string) (uuid.UUID, bool) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	session, exist := s.sessions[token]
+	if !exist || session.userID == uuid.Nil {
+		return uuid.Nil, false
+	}
+	return session.userID, true
+}
+
+// Attach new websocket to the existing session
+func (s *sessionStore) Resume(token string) (*sfu.PeerContext, uint64, error) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	session, exist := s.sessions[token]
+	if !exist {
+		return nil, 0, ErrSessionNotFound
+	}
+
+	select {
+	case <-session.peerContext.Done():
+		delete(s.sessions, token)
+		return nil, 0, ErrSessionNotFound
+	default:
+	}
+
+	if session.expire != nil {
+		session.expire.Stop()
+		session.expire = nil
+	}
+	session.generation++
+	return session.peerContext, session.generation, nil
+}
+
+// Websocket of the generation dropped. Session expires after grace period if client don't resume it
+func (s *sessionStore) Detach(token string, generation uint64, onExpire func()) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	session, exist := s.sessions[token]
+	if !exist || session.generation != generation {
+		return
+	}
+
+	session.expire = time.AfterFunc(s.grace, func() {
+		s.sessionsMu.Lock()
+		current, exist := s.sessions[token]
+		expired := exist && current == session && current.generation == generation
+		if expired {
+			delete(s.sessions, token)
+		}
+		s.sessionsMu.Unlock()
+
+		if expired {
+			onExpire()
+		}
+	})
+}
+
+func (s *sessionStore) Remove(token string) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	if session, exist := s.sessions[token]; exist && session.expire != nil {
+		session.expire.Stop()
+	}
+	delete(s.sessions, token)
+}
+
+func newSessionStore() *sessionStore {
+	return &sessionStore{
+		sessions: make(map[string]*roomSession),
+		grace:    _SESSION_RESUME_GRACE,
+	}
+}
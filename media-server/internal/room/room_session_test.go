@@ -0,0 +1,148 @@
+package room
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	webrtc "github.com/pion/webrtc/v4"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/sfu"
+)
+
+func newTestPeerContext(t *testing.T) *sfu.PeerContext {
+	t.Helper()
+
+	peerContext, err := sfu.NewPeerContext(sfu.NewPeerContextParams{
+		Context: context.Background(),
+		API:     webrtc.NewAPI(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = peerContext.Close(sfu.ErrPeerConnectionClosed) })
+	return peerContext
+}
+
+func newTestSessionStore(grace time.Duration) *sessionStore {
+	s := newSessionStore()
+	s.grace = grace
+	return s
+}
+
+func TestSessionUserID(t *testing.T) {
+	s := newSessionStore()
+	userID := uuid.New()
+
+	anonymous, _ := s.Add(newTestPeerContext(t), uuid.Nil)
+	identified, _ := s.Add(newTestPeerContext(t), userID)
+
+	if _, exist := s.UserID(anonymous); exist {
+		t.Fatal("anonymous session has user")
+	}
+	if got, exist := s.UserID(identified); !exist || got != userID {
+		t.Fatalf("session user %s, want %s", got, userID)
+	}
+}
+
+func TestSessionResume(t *testing.T) {
+	s := newSessionStore()
+	peerContext := newTestPeerContext(t)
+
+	if _, _, err := s.Resume("unknown"); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("resume unknown session err %v", err)
+	}
+
+	token, generation := s.Add(peerContext, uuid.Nil)
+	for i := 1; i <= 2; i++ {
+		resumed, next, err := s.Resume(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resumed != peerContext || next != generation+uint64(i) {
+			t.Fatalf("resumed generation %d, want the same peer with generation %d", next, generation+uint64(i))
+		}
+	}
+}
+
+func TestSessionExpire(t *testing.T) {
+	s := newTestSessionStore(10 * time.Millisecond)
+	token, generation := s.Add(newTestPeerContext(t), uuid.Nil)
+
+	expired := make(chan struct{})
+	s.Detach(token, generation, func() { close(expired) })
+
+	select {
+	case <-expired:
+	case <-time.After(time.Second):
+		t.Fatal("detached session is not expired")
+	}
+	if _, _, err := s.Resume(token); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("resume expired session err %v", err)
+	}
+}
+
+// Client resumed within the grace period, old websocket drops after that
+func TestSessionResumeWithinGrace(t *testing.T) {
+	s := newTestSessionStore(20 * time.Millisecond)
+	token, generation := s.Add(newTestPeerContext(t), uuid.Nil)
+
+	expired := make(chan struct{}, 2)
+	onExpire := func() { expired <- struct{}{} }
+
+	s.Detach(token, generation, onExpire)
+	_, resumed, err := s.Resume(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Detach(token, generation, onExpire)
+
+	select {
+	case <-expired:
+		t.Fatal("resumed session is expired")
+	case <-time.After(5 * s.grace):
+	}
+	if _, _, err = s.Resume(token); err != nil {
+		t.Fatalf("resume after the old websocket drop err %v", err)
+	}
+
+	s.Detach(token, resumed, onExpire)
+	select {
+	case <-expired:
+		t.Fatal("stale generation expired the session")
+	case <-time.After(5 * s.grace):
+	}
+}
+
+func TestSessionResumeClosedPeer(t *testing.T) {
+	s := newSessionStore()
+	peerContext := newTestPeerContext(t)
+	token, _ := s.Add(peerContext, uuid.Nil)
+
+	_ = peerContext.Close(sfu.ErrPeerConnectionClosed)
+	if _, _, err := s.Resume(token); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("resume of closed peer err %v", err)
+	}
+	if _, exist := s.sessions[token]; exist {
+		t.Fatal("session of closed peer is kept")
+	}
+}
+
+func TestSessionRemove(t *testing.T) {
+	s := newTestSessionStore(10 * time.Millisecond)
+	token, generation := s.Add(newTestPeerContext(t), uuid.Nil)
+
+	expired := make(chan struct{}, 1)
+	s.Detach(token, generation, func() { expired <- struct{}{} })
+	s.Remove(token)
+
+	select {
+	case <-expired:
+		t.Fatal("removed session is expired")
+	case <-time.After(5 * s.grace):
+	}
+	if _, _, err := s.Resume(token); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("resume removed session err %v", err)
+	}
+}
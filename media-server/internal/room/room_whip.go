@@ -0,0 +1,50 @@
+package room
+
+import (
+	"errors"
+	"strings"
+
+	webrtc "github.com/pion/webrtc/v4"
+)
+
+var ErrWhipFragmentMalformed = errors.New("malformed trickle ice sdp fragment")
+
+const _WHIP_FRAGMENT_CONTENT_TYPE = "application/trickle-ice-sdpfrag"
+
+// First value of the sdp attribute, session or media level
+func sdpAttribute(sdp, name string) string {
+	prefix := "a=" + name + ":"
+	for _, line := range strings.Split(sdp, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, prefix) {
+			return strings.TrimPrefix(line, prefix)
+		}
+	}
+	return ""
+}
+
+// Candidates of the trickle-ice-sdpfrag. Candidate belongs to the media section of the last mid
+func parseTrickleFragment(frag string) (ufrag string, candidates []webrtc.ICECandidateInit, err error) {
+	var mid *string
+	for _, line := range strings.Split(frag, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "a=ice-ufrag:"):
+			ufrag = strings.TrimPrefix(line, "a=ice-ufrag:")
+		case strings.HasPrefix(line, "m="):
+			mid = nil
+		case strings.HasPrefix(line, "a=mid:"):
+			value := strings.TrimPrefix(line, "a=mid:")
+			mid = &value
+		case strings.HasPrefix(line, "a=candidate:"):
+			if mid == nil {
+				return "", nil, ErrWhipFragmentMalformed
+			}
+			candidates = append(candidates, webrtc.ICECandidateInit{
+				Candidate: strings.TrimPrefix(line, "a="),
+				SDPMid:    mid,
+			})
+		}
+	}
+	return ufrag, candidates, nil
+}
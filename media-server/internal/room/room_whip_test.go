@@ -0,0 +1,102 @@
+package room
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSDPAttribute(t *testing.T) {
+	sdp := "v=0\r\n" +
+		"a=ice-ufrag:session\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=ice-ufrag:media\r\n" +
+		"a=ice-pwd:secret\r\n"
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"ice-ufrag", "session"},
+		{"ice-pwd", "secret"},
+		{"ice-options", ""},
+	}
+	for _, tt := range tests {
+		if got := sdpAttribute(sdp, tt.name); got != tt.want {
+			t.Fatalf("%s %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseTrickleFragment(t *testing.T) {
+	tests := []struct {
+		name       string
+		frag       string
+		ufrag      string
+		candidates []string
+		err        error
+	}{
+		{
+			name: "candidates of media sections",
+			frag: "a=ice-ufrag:EsAw\r\n" +
+				"a=ice-pwd:P2uYro0UCOQ4zxjKXaWCBui1\r\n" +
+				"m=audio 9 RTP/AVP 0\r\n" +
+				"a=mid:0\r\n" +
+				"a=candidate:1387637174 1 udp 2122260223 192.0.2.1 61764 typ host generation 0\r\n" +
+				"a=candidate:3471623853 1 udp 2122194687 198.51.100.1 61765 typ host generation 0\r\n" +
+				"m=video 9 RTP/AVP 96\r\n" +
+				"a=mid:1\r\n" +
+				"a=candidate:473322822 1 tcp 1518280447 192.0.2.1 9 typ host tcptype active\r\n" +
+				"a=end-of-candidates\r\n",
+			ufrag: "EsAw",
+			candidates: []string{
+				"0 candidate:1387637174 1 udp 2122260223 192.0.2.1 61764 typ host generation 0",
+				"0 candidate:3471623853 1 udp 2122194687 198.51.100.1 61765 typ host generation 0",
+				"1 candidate:473322822 1 tcp 1518280447 192.0.2.1 9 typ host tcptype active",
+			},
+		},
+		{
+			name:  "lf line endings",
+			frag:  "a=ice-ufrag:EsAw\nm=audio 9 RTP/AVP 0\na=mid:0\na=candidate:1 1 udp 1 192.0.2.1 1 typ host\n",
+			ufrag: "EsAw",
+			candidates: []string{
+				"0 candidate:1 1 udp 1 192.0.2.1 1 typ host",
+			},
+		},
+		{
+			name:  "end of candidates only",
+			frag:  "a=ice-ufrag:EsAw\r\nm=audio 9 RTP/AVP 0\r\na=mid:0\r\na=end-of-candidates\r\n",
+			ufrag: "EsAw",
+		},
+		{
+			name: "candidate without mid",
+			frag: "a=ice-ufrag:EsAw\r\na=candidate:1 1 udp 1 192.0.2.1 1 typ host\r\n",
+			err:  ErrWhipFragmentMalformed,
+		},
+		{
+			name: "mid of the previous media section",
+			frag: "m=audio 9 RTP/AVP 0\r\na=mid:0\r\nm=video 9 RTP/AVP 96\r\na=candidate:1 1 udp 1 192.0.2.1 1 typ host\r\n",
+			err:  ErrWhipFragmentMalformed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ufrag, candidates, err := parseTrickleFragment(tt.frag)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err %v, want %v", err, tt.err)
+			}
+			if ufrag != tt.ufrag {
+				t.Fatalf("ufrag %q, want %q", ufrag, tt.ufrag)
+			}
+
+			got := make([]string, 0, len(candidates))
+			for _, c := range candidates {
+				got = append(got, *c.SDPMid+" "+c.Candidate)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.candidates) {
+				t.Fatalf("candidates %q, want %q", got, tt.candidates)
+			}
+		})
+	}
+}
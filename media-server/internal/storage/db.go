@@ -39,6 +39,9 @@ func Prepare(ctx context.Context, db DBTX) (*Queries, error) {
 	if q.detachUserRefreshTokenStmt, err = db.PrepareContext(ctx, detachUserRefreshToken); err != nil {
 		return nil, fmt.Errorf("error preparing query DetachUserRefreshToken: %w", err)
 	}
+	if q.getChatMessagesStmt, err = db.PrepareContext(ctx, getChatMessages); err != nil {
+		return nil, fmt.Errorf("error preparing query GetChatMessages: %w", err)
+	}
 	if q.getPrivateKeyStmt, err = db.PrepareContext(ctx, getPrivateKey); err != nil {
 		return nil, fmt.Errorf("error preparing query GetPrivateKey: %w", err)
 	}
@@ -54,6 +57,9 @@ func Prepare(ctx context.Context, db DBTX) (*Queries, error) {
 	if q.getUserPrivateKeyStmt, err = db.PrepareContext(ctx, getUserPrivateKey); err != nil {
 		return nil, fmt.Errorf("error preparing query GetUserPrivateKey: %w", err)
 	}
+	if q.newChatMessageStmt, err = db.PrepareContext(ctx, newChatMessage); err != nil {
+		return nil, fmt.Errorf("error preparing query NewChatMessage: %w", err)
+	}
 	if q.newPrivateKeyStmt, err = db.PrepareContext(ctx, newPrivateKey); err != nil {
 		return nil, fmt.Errorf("error preparing query NewPrivateKey: %w", err)
 	}
@@ -93,6 +99,11 @@ func (q *Queries) Close() error {
 			err = fmt.Errorf("error closing detachUserRefreshTokenStmt: %w", cerr)
 		}
 	}
+	if q.getChatMessagesStmt != nil {
+		if cerr := q.getChatMessagesStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing getChatMessagesStmt: %w", cerr)
+		}
+	}
 	if q.getPrivateKeyStmt != nil {
 		if cerr := q.getPrivateKeyStmt.Close(); cerr != nil {
 			err = fmt.Errorf("error closing getPrivateKeyStmt: %w", cerr)
@@ -118,6 +129,11 @@ func (q *Queries) Close() error {
 			err = fmt.Errorf("error closing getUserPrivateKeyStmt: %w", cerr)
 		}
 	}
+	if q.newChatMessageStmt != nil {
+		if cerr := q.newChatMessageStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing newChatMessageStmt: %w", cerr)
+		}
+	}
 	if q.newPrivateKeyStmt != nil {
 		if cerr := q.newPrivateKeyStmt.Close(); cerr != nil {
 			err = fmt.Errorf("error closing newPrivateKeyStmt: %w", cerr)
@@ -177,11 +193,13 @@ type Queries struct {
 	delRefreshTokenStmt        *sql.Stmt
 	detachUserPrivateKeyStmt   *sql.Stmt
 	detachUserRefreshTokenStmt *sql.Stmt
+	getChatMessagesStmt        *sql.Stmt
 	getPrivateKeyStmt          *sql.Stmt
 	getPrivateKeyWithUserStmt  *sql.Stmt
 	getUserStmt                *sql.Stmt
 	getUserByUsernameStmt      *sql.Stmt
 	getUserPrivateKeyStmt      *sql.Stmt
+	newChatMessageStmt         *sql.Stmt
 	newPrivateKeyStmt          *sql.Stmt
 	newRefreshTokenStmt        *sql.Stmt
 	newUserStmt                *sql.Stmt
@@ -196,11 +214,13 @@ func (q *Queries) WithTx(tx *sql.Tx) *Queries {
 		delRefreshTokenStmt:        q.delRefreshTokenStmt,
 		detachUserPrivateKeyStmt:   q.detachUserPrivateKeyStmt,
 		detachUserRefreshTokenStmt: q.detachUserRefreshTokenStmt,
+		getChatMessagesStmt:        q.getChatMessagesStmt,
 		getPrivateKeyStmt:          q.getPrivateKeyStmt,
 		getPrivateKeyWithUserStmt:  q.getPrivateKeyWithUserStmt,
 		getUserStmt:                q.getUserStmt,
 		getUserByUsernameStmt:      q.getUserByUsernameStmt,
 		getUserPrivateKeyStmt:      q.getUserPrivateKeyStmt,
+		newChatMessageStmt:         q.newChatMessageStmt,
 		newPrivateKeyStmt:          q.newPrivateKeyStmt,
 		newRefreshTokenStmt:        q.newRefreshTokenStmt,
 		newUserStmt:                q.newUserStmt,
@@ -11,6 +11,15 @@ import (
 	"github.com/google/uuid"
 )
 
+type ChatMessage struct {
+	ID             uuid.UUID
+	RoomID         string
+	RoomInstanceID uuid.UUID
+	UserID         uuid.UUID
+	Body           string
+	CreatedAt      time.Time
+}
+
 type PrivateKey struct {
 	ID         uuid.UUID
 	JwsMessage json.RawMessage
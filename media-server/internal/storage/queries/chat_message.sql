@@ -0,0 +1,28 @@
+-- name: NewChatMessage :one
+INSERT INTO chat_messages (
+    room_id,
+    room_instance_id,
+    user_id,
+    body
+) VALUES (
+    @room_id,
+    @room_instance_id,
+    @user_id,
+    @body
+) RETURNING id, created_at;
+
+-- name: GetChatMessages :many
+SELECT
+    chat_messages.id,
+    chat_messages.room_id,
+    chat_messages.user_id,
+    users.username,
+    chat_messages.body,
+    chat_messages.created_at
+FROM chat_messages
+JOIN users ON users.id = chat_messages.user_id
+WHERE chat_messages.room_instance_id = @room_instance_id
+AND
+    (chat_messages.created_at, chat_messages.id) < (@before::timestamptz, @before_id::uuid)
+ORDER BY chat_messages.created_at DESC, chat_messages.id DESC
+LIMIT @lim;
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE chat_messages (
+    id UUID NOT NULL DEFAULT uuid_generate_v4(),
+    room_id varchar(255) NOT NULL,
+    -- NOTE: Room created again with the same id has new instance and empty chat
+    room_instance_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    body text NOT NULL,
+
+    created_at TIMESTAMPTZ(6) NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY(id),
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX chat_messages_room_instance_id_created_at_idx ON chat_messages(room_instance_id, created_at DESC, id DESC);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS chat_messages CASCADE;
+-- +goose StatementEnd
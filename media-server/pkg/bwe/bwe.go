@@ -0,0 +1,25 @@
+package bwe
+
+import (
+	"github.com/pion/interceptor/pkg/cc"
+)
+
+type BandwidthEstimator struct {
+	estimator cc.BandwidthEstimator
+}
+
+func (b *BandwidthEstimator) GetTargetBitrate() int {
+	return b.estimator.GetTargetBitrate()
+}
+
+func (b *BandwidthEstimator) OnTargetBitrateChange(f func(bitrate int)) {
+	b.estimator.OnTargetBitrateChange(f)
+}
+
+func (b *BandwidthEstimator) GetStats() map[string]interface{} {
+	return b.estimator.GetStats()
+}
+
+func NewBandwidthEstimator(estimator cc.BandwidthEstimator) *BandwidthEstimator {
+	return &BandwidthEstimator{estimator}
+}
@@ -0,0 +1,143 @@
+package egress
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/sfu"
+)
+
+var (
+	ErrInvalidEgressURL    = errors.New("invalid egress url")
+	ErrUnsupportedProtocol = errors.New("egress protocol is not supported")
+	ErrEgressStopped       = errors.New("egress is stopped")
+	ErrInvalidHlsDir       = errors.New("invalid hls dir")
+)
+
+type Protocol string
+
+const (
+	PROTOCOL_RTMP Protocol = "rtmp"
+	PROTOCOL_SRT  Protocol = "srt"
+	// Playlist and segments are written into the directory, viewers get them over http
+	PROTOCOL_HLS Protocol = "hls"
+)
+
+const HLS_PLAYLIST = "index.m3u8"
+
+// Playlist of the hls egress relative to the base directory
+func HlsPlaylist(roomID, egressID string) string {
+	return path.Join(roomID, egressID, HLS_PLAYLIST)
+}
+
+// Directory of the hls egress. It's removed on stop, so it must not leave the base directory
+func HlsDir(baseDir, roomID, egressID string) (string, error) {
+	if !filepath.IsLocal(roomID) || !filepath.IsLocal(egressID) {
+		return "", errors.Join(ErrInvalidHlsDir, fmt.Errorf("room id: %q egress id: %q", roomID, egressID))
+	}
+	return filepath.Join(baseDir, filepath.Dir(HlsPlaylist(roomID, egressID))), nil
+}
+
+type State string
+
+const (
+	STATE_ACTIVE  State = "active"
+	STATE_FAILED  State = "failed"
+	STATE_STOPPED State = "stopped"
+)
+
+// Live stream of the room which consumes published tracks until it's stopped or the remote side fails
+type Egress interface {
+	sfu.TrackListener
+
+	ID() string
+	Protocol() Protocol
+	// NOTE: Empty when it's the whole room
+	ParticipantID() string
+	StartedAt() time.Time
+	State() State
+	// NOTE: Reason of the failed state
+	Err() error
+	Stop() error
+}
+
+type Params struct {
+	RoomID string
+	// NOTE: Only tracks of the participant, otherwise the whole room
+	ParticipantID string
+	// NOTE: Taken from the url scheme when it's not set
+	Protocol Protocol
+	// NOTE: Remote server of the stream, empty for hls
+	URL string
+	// NOTE: Directory of the egress which writes files, e.g. hls
+	BaseDir string
+}
+
+type Allocator = func(params Params, target *url.URL) (Egress, error)
+
+// Scheme of the url, e.g. rtmps is served by rtmp allocator
+func ProtocolOf(target *url.URL) (Protocol, error) {
+	switch target.Scheme {
+	case "rtmp", "rtmps":
+		return PROTOCOL_RTMP, nil
+	case "srt":
+		return PROTOCOL_SRT, nil
+	default:
+		return "", errors.Join(ErrUnsupportedProtocol, fmt.Errorf("scheme: %s", target.Scheme))
+	}
+}
+
+type AllocatorsContext struct {
+	allocators map[Protocol]Allocator
+}
+
+func (ctx *AllocatorsContext) Register(protocol Protocol, alloc Allocator) {
+	if _, ok := ctx.allocators[protocol]; ok {
+		log.Panic("Invalid egress protocol")
+		os.Exit(1)
+	}
+	ctx.allocators[protocol] = alloc
+}
+
+func (ctx *AllocatorsContext) Allocate(params Params) (Egress, error) {
+	var target *url.URL
+	if params.URL != "" {
+		var err error
+		if target, err = url.Parse(params.URL); err != nil || target.Host == "" {
+			return nil, errors.Join(ErrInvalidEgressURL, err)
+		}
+
+		protocol, err := ProtocolOf(target)
+		if err != nil {
+			return nil, err
+		}
+		if params.Protocol != "" && params.Protocol != protocol {
+			return nil, errors.Join(ErrInvalidEgressURL, fmt.Errorf("url is not %s", params.Protocol))
+		}
+		params.Protocol = protocol
+	}
+
+	// NOTE: Only hls is served by the media server itself
+	if (target == nil) != (params.Protocol == PROTOCOL_HLS) {
+		return nil, errors.Join(ErrInvalidEgressURL, fmt.Errorf("url is required only for the remote server"))
+	}
+
+	alloc, ok := ctx.allocators[params.Protocol]
+	if !ok {
+		return nil, errors.Join(ErrUnsupportedProtocol, fmt.Errorf("protocol: %s", params.Protocol))
+	}
+	return alloc(params, target)
+}
+
+// Egress needs gstreamer, allocators are registered by the media server
+func NewAllocatorsContext() *AllocatorsContext {
+	return &AllocatorsContext{
+		allocators: make(map[Protocol]Allocator),
+	}
+}
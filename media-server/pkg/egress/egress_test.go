@@ -0,0 +1,28 @@
+package egress
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestHlsDir(t *testing.T) {
+	base := t.TempDir()
+
+	dir, err := HlsDir(base, "daily", "egress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(base, "daily", "egress"); dir != want {
+		t.Fatalf("dir %s, want %s", dir, want)
+	}
+	if filepath.Join(base, HlsPlaylist("daily", "egress")) != filepath.Join(dir, HLS_PLAYLIST) {
+		t.Fatal("playlist is not in the hls dir")
+	}
+
+	for _, roomID := range []string{"", "..", "../hls", "room/../../x", "/tmp"} {
+		if _, err := HlsDir(base, roomID, "egress"); !errors.Is(err, ErrInvalidHlsDir) {
+			t.Fatalf("room id %q err %v, want %v", roomID, err, ErrInvalidHlsDir)
+		}
+	}
+}
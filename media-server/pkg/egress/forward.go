@@ -0,0 +1,266 @@
+package egress
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pion/rtp"
+	"github.com/pion/srtp/v3"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/sfu"
+)
+
+var (
+	ErrInvalidForwardTarget = errors.New("invalid rtp forward target, expected host:port")
+	ErrForwardTargetDenied  = errors.New("rtp forward target must be unicast address out of the server host")
+	ErrInvalidSRTPKey       = errors.New("srtp key must be base64 of 16 bytes master key and 14 bytes master salt")
+	ErrForwardStopped       = errors.New("rtp forward is stopped")
+)
+
+const (
+	// NOTE: Rtp read loop must not wait for the network
+	_FORWARD_BUFFER = 512
+
+	_SRTP_KEY_LEN  = 16
+	_SRTP_SALT_LEN = 14
+)
+
+type ForwardStats struct {
+	Packets uint64
+	Bytes   uint64
+	// NOTE: Packets lost on the full buffer or on the failed write
+	Dropped uint64
+}
+
+// Hidden subscriber of one track. Packets are mirrored as plain rtp or srtp to the udp target
+type RTPForward struct {
+	id        string
+	track     *sfu.TrackContext
+	downTrack *sfu.DownTrack
+	target    *net.UDPAddr
+	conn      *net.UDPConn
+	startedAt time.Time
+
+	// NOTE: Nil when it's plain rtp
+	srtp    *srtp.Context
+	srtpKey string
+	ssrc    uint32
+
+	packets chan *rtp.Packet
+	done    chan struct{}
+	stopped sync.Once
+
+	stateMu sync.Mutex
+	state   State
+
+	sent    atomic.Uint64
+	bytes   atomic.Uint64
+	dropped atomic.Uint64
+}
+
+func (f *RTPForward) ID() string {
+	return f.id
+}
+
+func (f *RTPForward) Track() *sfu.TrackContext {
+	return f.track
+}
+
+func (f *RTPForward) Target() string {
+	return f.target.String()
+}
+
+func (f *RTPForward) SRTP() bool {
+	return f.srtp != nil
+}
+
+func (f *RTPForward) StartedAt() time.Time {
+	return f.startedAt
+}
+
+func (f *RTPForward) State() State {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	return f.state
+}
+
+func (f *RTPForward) Stats() ForwardStats {
+	return ForwardStats{
+		Packets: f.sent.Load(),
+		Bytes:   f.bytes.Load(),
+		Dropped: f.dropped.Load(),
+	}
+}
+
+// Session description for the receiver, e.g. ffmpeg -protocol_whitelist file,udp,rtp -i forward.sdp
+func (f *RTPForward) SDP() string {
+	codec := f.track.Codec()
+	_, encoding, _ := strings.Cut(codec.MimeType, "/")
+
+	addrType := "IP4"
+	if f.target.IP.To4() == nil {
+		addrType = "IP6"
+	}
+	profile := "RTP/AVP"
+	if f.SRTP() {
+		profile = "RTP/SAVP"
+	}
+	rtpmap := fmt.Sprintf("%d %s/%d", codec.PayloadType, encoding, codec.ClockRate)
+	if codec.Channels > 0 {
+		rtpmap += fmt.Sprintf("/%d", codec.Channels)
+	}
+
+	lines := []string{
+		"v=0",
+		fmt.Sprintf("o=- %d 0 IN %s %s", f.ssrc, addrType, f.target.IP),
+		"s=" + f.track.ID(),
+		fmt.Sprintf("c=IN %s %s", addrType, f.target.IP),
+		"t=0 0",
+		fmt.Sprintf("m=%s %d %s %d", f.track.Kind(), f.target.Port, profile, codec.PayloadType),
+		"a=rtpmap:" + rtpmap,
+	}
+	if codec.SDPFmtpLine != "" {
+		lines = append(lines, fmt.Sprintf("a=fmtp:%d %s", codec.PayloadType, codec.SDPFmtpLine))
+	}
+	if f.SRTP() {
+		lines = append(lines, "a=crypto:1 AES_CM_128_HMAC_SHA1_80 inline:"+f.srtpKey)
+	}
+	lines = append(lines,
+		fmt.Sprintf("a=ssrc:%d cname:%s", f.ssrc, f.track.StreamID()),
+		"a=recvonly",
+	)
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// Simulcast layers are switched by the down track, so ssrc is rewritten to keep one stream for the receiver.
+// Header extensions are negotiated with the publisher, they mean nothing for the receiver
+func (f *RTPForward) WriteRTP(pkt *rtp.Packet) error {
+	header := pkt.Header.Clone()
+	header.SSRC = f.ssrc
+	header.PayloadType = uint8(f.track.Codec().PayloadType)
+	header.Extension = false
+	header.Extensions = nil
+
+	select {
+	case f.packets <- &rtp.Packet{Header: header, Payload: pkt.Payload}:
+	default:
+		f.dropped.Add(1)
+	}
+	return nil
+}
+
+func (f *RTPForward) run() {
+	defer close(f.done)
+
+	for pkt := range f.packets {
+		buf, err := pkt.Marshal()
+		if err == nil && f.srtp != nil {
+			buf, err = f.srtp.EncryptRTP(nil, buf, nil)
+		}
+		if err != nil {
+			log.Printf("[RTPForward] %s unable encode packet %d. Err: %s", f.id, pkt.SequenceNumber, err)
+			f.dropped.Add(1)
+			continue
+		}
+
+		// NOTE: Receiver may be not listening yet, connected udp socket fails on icmp unreachable
+		if _, err = f.conn.Write(buf); err != nil {
+			f.dropped.Add(1)
+			continue
+		}
+		f.sent.Add(1)
+		f.bytes.Add(uint64(len(buf)))
+	}
+}
+
+func (f *RTPForward) Stop() error {
+	err := ErrForwardStopped
+	f.stopped.Do(func() {
+		f.track.RemoveSink(f.downTrack)
+		close(f.packets)
+		<-f.done
+
+		if closeErr := f.conn.Close(); closeErr != nil {
+			log.Printf("[RTPForward] %s unable close socket. Err: %s", f.id, closeErr)
+		}
+
+		f.stateMu.Lock()
+		f.state = STATE_STOPPED
+		f.stateMu.Unlock()
+
+		log.Printf("[RTPForward] %s of track %s to %s stopped", f.id, f.track.ID(), f.target)
+		err = nil
+	})
+	return err
+}
+
+func (f *RTPForward) TrackPublished(t *sfu.TrackContext) {}
+
+// Forward is kept stopped until it's removed, so the counters are still available
+func (f *RTPForward) TrackUnpublished(t *sfu.TrackContext) {
+	if t != f.track {
+		return
+	}
+	_ = f.Stop()
+}
+
+var _ sfu.TrackListener = (*RTPForward)(nil)
+
+// Server must not reflect the media to itself, to the cloud metadata or to the whole network
+func forwardTargetAllowed(ip net.IP) bool {
+	return !ip.IsUnspecified() &&
+		!ip.IsLoopback() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsMulticast() &&
+		!ip.Equal(net.IPv4bcast)
+}
+
+func NewRTPForward(t *sfu.TrackContext, target, srtpKey string) (*RTPForward, error) {
+	addr, err := net.ResolveUDPAddr("udp", target)
+	if err != nil || addr.Port == 0 || addr.IP == nil {
+		return nil, errors.Join(ErrInvalidForwardTarget, err)
+	}
+	if !forwardTargetAllowed(addr.IP) {
+		return nil, errors.Join(ErrInvalidForwardTarget, ErrForwardTargetDenied)
+	}
+
+	f := &RTPForward{
+		id:      uuid.NewString(),
+		track:   t,
+		target:  addr,
+		ssrc:    rand.Uint32(),
+		packets: make(chan *rtp.Packet, _FORWARD_BUFFER),
+		done:    make(chan struct{}),
+		state:   STATE_ACTIVE,
+	}
+
+	if srtpKey != "" {
+		key, err := base64.StdEncoding.DecodeString(srtpKey)
+		if err != nil || len(key) != _SRTP_KEY_LEN+_SRTP_SALT_LEN {
+			return nil, errors.Join(ErrInvalidSRTPKey, err)
+		}
+		if f.srtp, err = srtp.CreateContext(key[:_SRTP_KEY_LEN], key[_SRTP_KEY_LEN:], srtp.ProtectionProfileAes128CmHmacSha1_80); err != nil {
+			return nil, err
+		}
+		f.srtpKey = srtpKey
+	}
+
+	if f.conn, err = net.DialUDP("udp", nil, addr); err != nil {
+		return nil, err
+	}
+	f.startedAt = time.Now()
+
+	go f.run()
+	f.downTrack = t.AddSink(f)
+
+	log.Printf("[RTPForward] %s of track %s to %s started. SRTP: %t", f.id, t.ID(), addr, f.SRTP())
+	return f, nil
+}
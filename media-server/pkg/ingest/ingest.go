@@ -0,0 +1,147 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/sfu"
+)
+
+var (
+	ErrInvalidIngestSource = errors.New("invalid ingest source")
+	ErrUnsupportedProtocol = errors.New("ingest protocol is not supported")
+	ErrUnsupportedCodec    = errors.New("ingest codec is not supported")
+	ErrIngestStopped       = errors.New("ingest is stopped")
+)
+
+// NOTE: Privileged ports belong to the system services of the host
+const _RTP_MIN_PORT = 1024
+
+type Protocol string
+
+const (
+	// NOTE: Source is pulled from the rtsp server, e.g. ip camera
+	PROTOCOL_RTSP Protocol = "rtsp"
+	// NOTE: Media server listens for the plain rtp of one stream on the udp port
+	PROTOCOL_RTP Protocol = "rtp"
+)
+
+type Codec string
+
+const (
+	CODEC_VP8  Codec = "vp8"
+	CODEC_VP9  Codec = "vp9"
+	CODEC_H264 Codec = "h264"
+	CODEC_OPUS Codec = "opus"
+	CODEC_PCMU Codec = "pcmu"
+	CODEC_PCMA Codec = "pcma"
+)
+
+type State string
+
+const (
+	STATE_ACTIVE  State = "active"
+	STATE_FAILED  State = "failed"
+	STATE_STOPPED State = "stopped"
+)
+
+// External source of the room. Its tracks are published by the synthetic participant until it's stopped or the source fails
+type Ingest interface {
+	ID() string
+	Protocol() Protocol
+	// NOTE: Publish only peer without websocket, it's in the room pool
+	Participant() *sfu.PeerContext
+	// NOTE: Udp port of the rtp, zero for rtsp
+	Port() int
+	StartedAt() time.Time
+	State() State
+	// NOTE: Reason of the failed state
+	Err() error
+	Stop() error
+}
+
+type Params struct {
+	RoomID   string
+	Protocol Protocol
+	// NOTE: Rtsp url of the source
+	URL string
+	// NOTE: Rtp port and codec of the stream
+	Port        int
+	Codec       Codec
+	PayloadType uint8
+	// NOTE: Label of the published tracks
+	Label string
+}
+
+type Allocator = func(peer *sfu.PeerContext, params Params, source *url.URL) (Ingest, error)
+
+type AllocatorsContext struct {
+	allocators map[Protocol]Allocator
+}
+
+func (ctx *AllocatorsContext) Register(protocol Protocol, alloc Allocator) {
+	if _, ok := ctx.allocators[protocol]; ok {
+		log.Panic("Invalid ingest protocol")
+		os.Exit(1)
+	}
+	ctx.allocators[protocol] = alloc
+}
+
+func (ctx *AllocatorsContext) Allocate(peer *sfu.PeerContext, params Params) (Ingest, error) {
+	var source *url.URL
+
+	switch params.Protocol {
+	case PROTOCOL_RTSP:
+		var err error
+		if source, err = url.Parse(params.URL); err != nil || source.Host == "" {
+			return nil, errors.Join(ErrInvalidIngestSource, err)
+		}
+		if source.Scheme != "rtsp" && source.Scheme != "rtsps" {
+			return nil, errors.Join(ErrInvalidIngestSource, fmt.Errorf("scheme: %s", source.Scheme))
+		}
+		if err = sourceHostAllowed(source.Hostname()); err != nil {
+			return nil, errors.Join(ErrInvalidIngestSource, err)
+		}
+	case PROTOCOL_RTP:
+		if params.Port < _RTP_MIN_PORT || params.Port > 65535 {
+			return nil, errors.Join(ErrInvalidIngestSource, fmt.Errorf("port: %d", params.Port))
+		}
+		switch params.Codec {
+		case CODEC_VP8, CODEC_VP9, CODEC_H264, CODEC_OPUS, CODEC_PCMU, CODEC_PCMA:
+		default:
+			return nil, errors.Join(ErrUnsupportedCodec, fmt.Errorf("codec: %q", params.Codec))
+		}
+	}
+
+	alloc, ok := ctx.allocators[params.Protocol]
+	if !ok {
+		return nil, errors.Join(ErrUnsupportedProtocol, fmt.Errorf("protocol: %s", params.Protocol))
+	}
+	return alloc(peer, params, source)
+}
+
+// Media server must not pull from itself or from the cloud metadata, e.g. rtsp://169.254.169.254
+func sourceHostAllowed(host string) error {
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		if ip.IsUnspecified() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+			return fmt.Errorf("host: %s", host)
+		}
+	}
+	return nil
+}
+
+// Ingest needs gstreamer, allocators are registered by the media server
+func NewAllocatorsContext() *AllocatorsContext {
+	return &AllocatorsContext{
+		allocators: make(map[Protocol]Allocator),
+	}
+}
@@ -0,0 +1,67 @@
+package ingest
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/sfu"
+)
+
+func newTestAllocators(allocated *int) *AllocatorsContext {
+	alloc := func(_ *sfu.PeerContext, _ Params, _ *url.URL) (Ingest, error) {
+		*allocated++
+		return nil, nil
+	}
+
+	ctx := NewAllocatorsContext()
+	ctx.Register(PROTOCOL_RTSP, alloc)
+	ctx.Register(PROTOCOL_RTP, alloc)
+	return ctx
+}
+
+func TestAllocateValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		params Params
+		err    error
+	}{
+		{name: "rtsp", params: Params{Protocol: PROTOCOL_RTSP, URL: "rtsp://192.0.2.10:554/stream"}},
+		{name: "rtsps", params: Params{Protocol: PROTOCOL_RTSP, URL: "rtsps://192.0.2.10/stream"}},
+		{name: "rtsp wrong scheme", params: Params{Protocol: PROTOCOL_RTSP, URL: "http://192.0.2.10/stream"}, err: ErrInvalidIngestSource},
+		{name: "rtsp without host", params: Params{Protocol: PROTOCOL_RTSP, URL: "rtsp:///stream"}, err: ErrInvalidIngestSource},
+		{name: "rtsp malformed", params: Params{Protocol: PROTOCOL_RTSP, URL: "rtsp://%zz"}, err: ErrInvalidIngestSource},
+		{name: "rtsp loopback", params: Params{Protocol: PROTOCOL_RTSP, URL: "rtsp://127.0.0.1:554/stream"}, err: ErrInvalidIngestSource},
+		{name: "rtsp localhost", params: Params{Protocol: PROTOCOL_RTSP, URL: "rtsp://localhost/stream"}, err: ErrInvalidIngestSource},
+		{name: "rtsp ipv6 loopback", params: Params{Protocol: PROTOCOL_RTSP, URL: "rtsp://[::1]/stream"}, err: ErrInvalidIngestSource},
+		{name: "rtsp cloud metadata", params: Params{Protocol: PROTOCOL_RTSP, URL: "rtsp://169.254.169.254/latest"}, err: ErrInvalidIngestSource},
+		{name: "rtsp unspecified", params: Params{Protocol: PROTOCOL_RTSP, URL: "rtsp://0.0.0.0/stream"}, err: ErrInvalidIngestSource},
+		{name: "rtsp multicast", params: Params{Protocol: PROTOCOL_RTSP, URL: "rtsp://239.0.0.1/stream"}, err: ErrInvalidIngestSource},
+		{name: "rtp", params: Params{Protocol: PROTOCOL_RTP, Port: 5004, Codec: CODEC_VP8}},
+		{name: "rtp highest port", params: Params{Protocol: PROTOCOL_RTP, Port: 65535, Codec: CODEC_OPUS}},
+		{name: "rtp without port", params: Params{Protocol: PROTOCOL_RTP, Codec: CODEC_VP8}, err: ErrInvalidIngestSource},
+		{name: "rtp privileged port", params: Params{Protocol: PROTOCOL_RTP, Port: 1023, Codec: CODEC_VP8}, err: ErrInvalidIngestSource},
+		{name: "rtp port out of range", params: Params{Protocol: PROTOCOL_RTP, Port: 65536, Codec: CODEC_VP8}, err: ErrInvalidIngestSource},
+		{name: "rtp without codec", params: Params{Protocol: PROTOCOL_RTP, Port: 5004}, err: ErrUnsupportedCodec},
+		{name: "rtp unknown codec", params: Params{Protocol: PROTOCOL_RTP, Port: 5004, Codec: "av1"}, err: ErrUnsupportedCodec},
+		{name: "unknown protocol", params: Params{Protocol: "srt", URL: "srt://192.0.2.10:9000"}, err: ErrUnsupportedProtocol},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var allocated int
+			_, err := newTestAllocators(&allocated).Allocate(nil, tt.params)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err %v, want %v", err, tt.err)
+			}
+
+			want := 1
+			if tt.err != nil {
+				want = 0
+			}
+			if allocated != want {
+				t.Fatalf("allocated %d times, want %d", allocated, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package recorder
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v4/pkg/media/samplebuilder"
+)
+
+const (
+	_IVF_HEADER_SIZE = 32
+	_IVF_TIMEBASE    = 90000
+	// NOTE: Packets which are later than this are dropped with the frame
+	_SAMPLE_MAX_LATE = 256
+)
+
+// IVF writer of vp8/vp9. Unlike pion ivfwriter frame pts is the rtp timestamp, so pauses and
+// frame drops don't shift the timeline
+type ivfWriter struct {
+	file    *os.File
+	builder *samplebuilder.SampleBuilder
+
+	count     uint32
+	started   bool
+	firstTS   uint32
+	lastTS    uint32
+	unwrapped uint64
+}
+
+func newIVFWriter(path string, fourcc string, depacketizer rtp.Depacketizer) (*ivfWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &ivfWriter{
+		file:    file,
+		builder: samplebuilder.New(_SAMPLE_MAX_LATE, depacketizer, _IVF_TIMEBASE),
+	}
+	if err = w.writeHeader(fourcc); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func newVP8Writer(path string) (*ivfWriter, error) {
+	return newIVFWriter(path, "VP80", &codecs.VP8Packet{})
+}
+
+func newVP9Writer(path string) (*ivfWriter, error) {
+	return newIVFWriter(path, "VP90", &codecs.VP9Packet{})
+}
+
+func (w *ivfWriter) writeHeader(fourcc string) error {
+	header := make([]byte, _IVF_HEADER_SIZE)
+	copy(header[0:], "DKIF")
+	binary.LittleEndian.PutUint16(header[4:], 0)
+	binary.LittleEndian.PutUint16(header[6:], _IVF_HEADER_SIZE)
+	copy(header[8:], fourcc)
+	// NOTE: Frame size is in the keyframe, players don't rely on the header
+	binary.LittleEndian.PutUint16(header[12:], 640)
+	binary.LittleEndian.PutUint16(header[14:], 480)
+	binary.LittleEndian.PutUint32(header[16:], _IVF_TIMEBASE)
+	binary.LittleEndian.PutUint32(header[20:], 1)
+	_, err := w.file.Write(header)
+	return err
+}
+
+func (w *ivfWriter) WriteRTP(pkt *rtp.Packet) error {
+	w.builder.Push(pkt)
+
+	for {
+		sample := w.builder.Pop()
+		if sample == nil {
+			return nil
+		}
+		if err := w.writeFrame(sample.Data, sample.PacketTimestamp); err != nil {
+			return err
+		}
+	}
+}
+
+func (w *ivfWriter) writeFrame(frame []byte, timestamp uint32) error {
+	if !w.started {
+		w.started = true
+		w.firstTS = timestamp
+		w.lastTS = timestamp
+	}
+	w.unwrapped += uint64(timestamp - w.lastTS)
+	w.lastTS = timestamp
+
+	header := make([]byte, 12)
+	binary.LittleEndian.PutUint32(header[0:], uint32(len(frame)))
+	binary.LittleEndian.PutUint64(header[4:], w.unwrapped)
+	if _, err := w.file.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.file.Write(frame); err != nil {
+		return err
+	}
+	w.count++
+	return nil
+}
+
+func (w *ivfWriter) Close() error {
+	if _, err := w.file.Seek(24, io.SeekStart); err == nil {
+		count := make([]byte, 4)
+		binary.LittleEndian.PutUint32(count, w.count)
+		_, _ = w.file.Write(count)
+	}
+	return w.file.Close()
+}
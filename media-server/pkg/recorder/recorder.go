@@ -0,0 +1,66 @@
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/sfu"
+)
+
+var ErrInvalidRecorderMode = errors.New("invalid recorder mode")
+
+type Mode string
+
+const (
+	// Each track to own file
+	MODE_TRACKS Mode = "tracks"
+	// All tracks of the room mixed into one file
+	MODE_COMPOSITE Mode = "composite"
+)
+
+// Room recording which consumes published tracks until it's stopped
+type Recorder interface {
+	sfu.TrackListener
+
+	ID() string
+	Mode() Mode
+	StartedAt() time.Time
+	Dir() string
+	Stop() (*Manifest, error)
+}
+
+type Allocator = func(baseDir, roomID string) (Recorder, error)
+
+type AllocatorsContext struct {
+	allocators map[Mode]Allocator
+}
+
+func (ctx *AllocatorsContext) Register(mode Mode, alloc Allocator) {
+	if _, ok := ctx.allocators[mode]; ok {
+		log.Panic("Invalid recorder mode")
+		os.Exit(1)
+	}
+	ctx.allocators[mode] = alloc
+}
+
+func (ctx *AllocatorsContext) Allocate(mode Mode, baseDir, roomID string) (Recorder, error) {
+	alloc, ok := ctx.allocators[mode]
+	if !ok {
+		return nil, errors.Join(ErrInvalidRecorderMode, fmt.Errorf("mode: %s", mode))
+	}
+	return alloc(baseDir, roomID)
+}
+
+// Per track recording is always available, it doesn't need gstreamer
+func NewAllocatorsContext() *AllocatorsContext {
+	ctx := &AllocatorsContext{
+		allocators: make(map[Mode]Allocator),
+	}
+	ctx.Register(MODE_TRACKS, func(baseDir, roomID string) (Recorder, error) {
+		return NewRecording(baseDir, roomID)
+	})
+	return ctx
+}
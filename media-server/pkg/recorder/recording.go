@@ -0,0 +1,236 @@
+package recorder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/sfu"
+)
+
+var (
+	ErrRecordingStopped    = errors.New("recording is stopped")
+	ErrInvalidRecordingDir = errors.New("invalid recording dir")
+)
+
+const _MANIFEST_FILE = "manifest.json"
+
+// Timing of one track file. Offsets are relative to the recording start, so files can be aligned later
+type ManifestTrack struct {
+	TrackID       string `json:"trackId"`
+	ParticipantID string `json:"participantId"`
+	Kind          string `json:"kind"`
+	Codec         string `json:"codec"`
+	Source        string `json:"source"`
+	ClockRate     uint32 `json:"clockRate"`
+	File          string `json:"file"`
+
+	StartedAt         time.Time  `json:"startedAt"`
+	FirstPacketAt     *time.Time `json:"firstPacketAt,omitempty"`
+	FirstRTPTimestamp uint32     `json:"firstRtpTimestamp"`
+	StoppedAt         *time.Time `json:"stoppedAt,omitempty"`
+
+	StartOffsetMs int64 `json:"startOffsetMs"`
+	EndOffsetMs   int64 `json:"endOffsetMs"`
+}
+
+func NewManifestTrack(t *sfu.TrackContext, file string) ManifestTrack {
+	metadata := t.Metadata()
+	return ManifestTrack{
+		TrackID:       t.ID(),
+		ParticipantID: metadata.ParticipantID,
+		Kind:          metadata.Kind,
+		Codec:         metadata.Codec,
+		Source:        string(metadata.Source),
+		ClockRate:     t.GetClockRate(),
+		File:          file,
+		StartedAt:     time.Now(),
+	}
+}
+
+type Manifest struct {
+	RecordingID string    `json:"recordingId"`
+	RoomID      string    `json:"roomId"`
+	Mode        Mode      `json:"mode"`
+	StartedAt   time.Time `json:"startedAt"`
+	StoppedAt   time.Time `json:"stoppedAt"`
+	// NOTE: Output of composite recording, per track files are in the tracks
+	File   string          `json:"file,omitempty"`
+	Tracks []ManifestTrack `json:"tracks"`
+}
+
+// Offsets are computed from the recording start and tracks are ordered by them
+func (m *Manifest) SetTracks(entries []ManifestTrack) {
+	m.Tracks = make([]ManifestTrack, 0, len(entries))
+	for _, entry := range entries {
+		start := entry.StartedAt
+		if entry.FirstPacketAt != nil {
+			start = *entry.FirstPacketAt
+		}
+		entry.StartOffsetMs = start.Sub(m.StartedAt).Milliseconds()
+		if entry.StoppedAt != nil {
+			entry.EndOffsetMs = entry.StoppedAt.Sub(m.StartedAt).Milliseconds()
+		}
+		m.Tracks = append(m.Tracks, entry)
+	}
+	sort.Slice(m.Tracks, func(i, j int) bool {
+		return m.Tracks[i].StartOffsetMs < m.Tracks[j].StartOffsetMs
+	})
+}
+
+func (m *Manifest) Write(dir string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, _MANIFEST_FILE), data, 0o644)
+}
+
+// Recording of the room. Each track goes to own file, manifest is written on stop
+type Recording struct {
+	id        string
+	roomID    string
+	dir       string
+	startedAt time.Time
+
+	// NOTE: Track ids are chosen by publishers and may collide across participants
+	tracksMu sync.Mutex
+	tracks   map[*sfu.TrackContext]*trackRecorder
+	finished []ManifestTrack
+	stopped  bool
+}
+
+func (r *Recording) ID() string {
+	return r.id
+}
+
+func (r *Recording) Mode() Mode {
+	return MODE_TRACKS
+}
+
+func (r *Recording) StartedAt() time.Time {
+	return r.startedAt
+}
+
+func (r *Recording) Dir() string {
+	return r.dir
+}
+
+func (r *Recording) AddTrack(t *sfu.TrackContext) error {
+	r.tracksMu.Lock()
+	defer r.tracksMu.Unlock()
+
+	if r.stopped {
+		return ErrRecordingStopped
+	}
+	if _, exist := r.tracks[t]; exist {
+		return nil
+	}
+
+	track, err := newTrackRecorder(r.dir, t)
+	if err != nil {
+		return err
+	}
+	r.tracks[t] = track
+	log.Printf("[Recorder] recording %s start track %s", r.id, t.ID())
+	return nil
+}
+
+// Track file is closed, the entry stays in the manifest
+func (r *Recording) RemoveTrack(t *sfu.TrackContext) {
+	r.tracksMu.Lock()
+	track, exist := r.tracks[t]
+	delete(r.tracks, t)
+	r.tracksMu.Unlock()
+
+	if !exist {
+		return
+	}
+
+	entry := track.Stop()
+	r.tracksMu.Lock()
+	r.finished = append(r.finished, entry)
+	r.tracksMu.Unlock()
+}
+
+func (r *Recording) TrackPublished(t *sfu.TrackContext) {
+	if err := r.AddTrack(t); err != nil {
+		log.Printf("[Recorder] recording %s unable add track %s. Err: %s", r.id, t.ID(), err)
+	}
+}
+
+func (r *Recording) TrackUnpublished(t *sfu.TrackContext) {
+	r.RemoveTrack(t)
+}
+
+func (r *Recording) Stop() (*Manifest, error) {
+	r.tracksMu.Lock()
+	if r.stopped {
+		r.tracksMu.Unlock()
+		return nil, ErrRecordingStopped
+	}
+	r.stopped = true
+	tracks := r.tracks
+	r.tracks = make(map[*sfu.TrackContext]*trackRecorder)
+	r.tracksMu.Unlock()
+
+	entries := make([]ManifestTrack, 0, len(tracks))
+	for _, track := range tracks {
+		entries = append(entries, track.Stop())
+	}
+
+	r.tracksMu.Lock()
+	entries = append(entries, r.finished...)
+	r.tracksMu.Unlock()
+
+	manifest := &Manifest{
+		RecordingID: r.id,
+		RoomID:      r.roomID,
+		Mode:        MODE_TRACKS,
+		StartedAt:   r.startedAt,
+		StoppedAt:   time.Now(),
+	}
+	manifest.SetTracks(entries)
+	if err := manifest.Write(r.dir); err != nil {
+		return nil, err
+	}
+
+	log.Printf("[Recorder] recording %s stopped. Tracks: %d", r.id, len(manifest.Tracks))
+	return manifest, nil
+}
+
+var _ Recorder = (*Recording)(nil)
+
+// Directory of the new recording, id of the recording is the directory name. Room id must not leave the base dir
+func NewRecordingDir(baseDir, roomID string) (id string, dir string, err error) {
+	if !filepath.IsLocal(roomID) {
+		return "", "", errors.Join(ErrInvalidRecordingDir, fmt.Errorf("room id: %q", roomID))
+	}
+
+	id = uuid.NewString()
+	dir = filepath.Join(baseDir, roomID, id)
+	err = os.MkdirAll(dir, 0o755)
+	return
+}
+
+func NewRecording(baseDir, roomID string) (*Recording, error) {
+	id, dir, err := NewRecordingDir(baseDir, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Recording{
+		id:        id,
+		roomID:    roomID,
+		dir:       dir,
+		startedAt: time.Now(),
+		tracks:    make(map[*sfu.TrackContext]*trackRecorder),
+	}, nil
+}
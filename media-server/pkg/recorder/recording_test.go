@@ -0,0 +1,99 @@
+package recorder
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/sfu"
+)
+
+func TestManifestSetTracks(t *testing.T) {
+	startedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	at := func(ms int) *time.Time {
+		t := startedAt.Add(time.Duration(ms) * time.Millisecond)
+		return &t
+	}
+
+	m := &Manifest{StartedAt: startedAt}
+	m.SetTracks([]ManifestTrack{
+		{File: "late.ogg", StartedAt: *at(100), FirstPacketAt: at(2500), StoppedAt: at(9000)},
+		// NOTE: Track without packets starts when it's attached
+		{File: "silent.ivf", StartedAt: *at(1000), StoppedAt: at(3000)},
+		{File: "active.ivf", StartedAt: *at(0), FirstPacketAt: at(40)},
+	})
+
+	tests := []struct {
+		file  string
+		start int64
+		end   int64
+	}{
+		{"active.ivf", 40, 0},
+		{"silent.ivf", 1000, 3000},
+		{"late.ogg", 2500, 9000},
+	}
+	if len(m.Tracks) != len(tests) {
+		t.Fatalf("tracks %d, want %d", len(m.Tracks), len(tests))
+	}
+	for i, tt := range tests {
+		track := m.Tracks[i]
+		if track.File != tt.file || track.StartOffsetMs != tt.start || track.EndOffsetMs != tt.end {
+			t.Fatalf("track %d %s %d-%d, want %s %d-%d", i, track.File, track.StartOffsetMs, track.EndOffsetMs, tt.file, tt.start, tt.end)
+		}
+	}
+}
+
+func TestNewRecordingDir(t *testing.T) {
+	base := t.TempDir()
+
+	_, dir, err := NewRecordingDir(base, "room")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(dir) != filepath.Join(base, "room") {
+		t.Fatalf("dir %s is not in the room dir", dir)
+	}
+
+	for _, roomID := range []string{"", "..", "../room", "room/../../x", "/tmp/room"} {
+		if _, _, err := NewRecordingDir(base, roomID); !errors.Is(err, ErrInvalidRecordingDir) {
+			t.Fatalf("room id %q err %v, want %v", roomID, err, ErrInvalidRecordingDir)
+		}
+	}
+}
+
+// Track ids come from the publisher sdp, they may repeat and must not pick the file path
+func TestRecordingTrackIDs(t *testing.T) {
+	rec, err := NewRecording(t.TempDir(), "room")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := newTestTrack(t, "first", "../../audio")
+	second := newTestTrack(t, "second", "../../audio")
+	for _, track := range []*sfu.TrackContext{first, second} {
+		if err = rec.AddTrack(track); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manifest, err := rec.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Tracks) != 2 {
+		t.Fatalf("tracks %d, want both tracks of the same id", len(manifest.Tracks))
+	}
+	if manifest.Tracks[0].File == manifest.Tracks[1].File {
+		t.Fatal("tracks share the file")
+	}
+	for _, track := range manifest.Tracks {
+		if track.TrackID != "../../audio" {
+			t.Fatalf("manifest track id %q, want the publisher one", track.TrackID)
+		}
+		if _, err = os.Stat(filepath.Join(rec.Dir(), track.File)); err != nil || filepath.Base(track.File) != track.File {
+			t.Fatalf("file %q is not in the recording dir. Err: %v", track.File, err)
+		}
+	}
+}
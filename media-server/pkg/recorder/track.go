@@ -0,0 +1,140 @@
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pion/rtp"
+	webrtc "github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media/h264writer"
+	"github.com/pion/webrtc/v4/pkg/media/oggwriter"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/sfu"
+)
+
+var ErrCodecNotSupported = errors.New("codec is not supported by recorder")
+
+// NOTE: Rtp read loop must not wait for the disk
+const _TRACK_RECORDER_BUFFER = 512
+
+type mediaWriter interface {
+	WriteRTP(*rtp.Packet) error
+	Close() error
+}
+
+// NOTE: Name is generated by server, track id of the publisher sdp is not safe for the file system
+func newMediaWriter(dir string, codec webrtc.RTPCodecParameters, name string) (mediaWriter, string, error) {
+	var (
+		writer mediaWriter
+		file   string
+		err    error
+	)
+
+	switch strings.ToLower(codec.MimeType) {
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		file = name + ".ivf"
+		writer, err = newVP8Writer(filepath.Join(dir, file))
+	case strings.ToLower(webrtc.MimeTypeVP9):
+		file = name + ".ivf"
+		writer, err = newVP9Writer(filepath.Join(dir, file))
+	case strings.ToLower(webrtc.MimeTypeH264):
+		file = name + ".h264"
+		writer, err = h264writer.New(filepath.Join(dir, file))
+	case strings.ToLower(webrtc.MimeTypeOpus):
+		file = name + ".ogg"
+		writer, err = oggwriter.New(filepath.Join(dir, file), codec.ClockRate, codec.Channels)
+	default:
+		return nil, "", errors.Join(ErrCodecNotSupported, fmt.Errorf("mime type: %s", codec.MimeType))
+	}
+	return writer, file, err
+}
+
+// Hidden subscriber of one track. Packets are written to the file by own goroutine
+type trackRecorder struct {
+	track     *sfu.TrackContext
+	downTrack *sfu.DownTrack
+	writer    mediaWriter
+
+	packets chan *rtp.Packet
+	done    chan struct{}
+	stopped sync.Once
+
+	entryMu sync.Mutex
+	entry   ManifestTrack
+}
+
+func (r *trackRecorder) WriteRTP(pkt *rtp.Packet) error {
+	r.entryMu.Lock()
+	if r.entry.FirstPacketAt == nil {
+		now := time.Now()
+		r.entry.FirstPacketAt = &now
+		r.entry.FirstRTPTimestamp = pkt.Timestamp
+	}
+	r.entryMu.Unlock()
+
+	clone := &rtp.Packet{
+		Header:  pkt.Header.Clone(),
+		Payload: pkt.Payload,
+	}
+	select {
+	case r.packets <- clone:
+	default:
+		log.Printf("[Recorder] track %s buffer is full. Drop packet %d", r.track.ID(), pkt.SequenceNumber)
+	}
+	return nil
+}
+
+func (r *trackRecorder) run() {
+	defer close(r.done)
+
+	for pkt := range r.packets {
+		if err := r.writer.WriteRTP(pkt); err != nil {
+			log.Printf("[Recorder] track %s unable write packet. Err: %s", r.track.ID(), err)
+		}
+	}
+
+	if err := r.writer.Close(); err != nil {
+		log.Printf("[Recorder] track %s unable close file. Err: %s", r.track.ID(), err)
+	}
+}
+
+func (r *trackRecorder) Stop() ManifestTrack {
+	r.stopped.Do(func() {
+		r.track.RemoveSink(r.downTrack)
+		close(r.packets)
+		<-r.done
+
+		r.entryMu.Lock()
+		now := time.Now()
+		r.entry.StoppedAt = &now
+		r.entryMu.Unlock()
+	})
+
+	r.entryMu.Lock()
+	defer r.entryMu.Unlock()
+	return r.entry
+}
+
+func newTrackRecorder(dir string, t *sfu.TrackContext) (*trackRecorder, error) {
+	writer, file, err := newMediaWriter(dir, t.Codec(), uuid.NewString())
+	if err != nil {
+		return nil, err
+	}
+
+	r := &trackRecorder{
+		track:   t,
+		writer:  writer,
+		packets: make(chan *rtp.Packet, _TRACK_RECORDER_BUFFER),
+		done:    make(chan struct{}),
+		entry:   NewManifestTrack(t, file),
+	}
+
+	go r.run()
+	r.downTrack = t.AddSink(r)
+	return r, nil
+}
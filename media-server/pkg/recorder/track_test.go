@@ -0,0 +1,137 @@
+package recorder
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	webrtc "github.com/pion/webrtc/v4"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/sfu"
+)
+
+func newTestTrack(t *testing.T, peerID, trackID string) *sfu.TrackContext {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return sfu.NewTrackContext(ctx, sfu.NewTrackContextParams{
+		SourcePeerID: peerID,
+		ID:           trackID,
+		StreamID:     peerID,
+		SSRC:         1,
+		Kind:         webrtc.RTPCodecTypeAudio,
+		CodecParams: webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2},
+		},
+	})
+}
+
+func testOpusPacket(seq uint16) *rtp.Packet {
+	return &rtp.Packet{
+		Header:  rtp.Header{Version: 2, SequenceNumber: seq, Timestamp: uint32(seq) * 960, SSRC: 1},
+		Payload: []byte{0xfc, 0xff, 0xfe},
+	}
+}
+
+func TestNewMediaWriter(t *testing.T) {
+	tests := []struct {
+		mimeType string
+		file     string
+		err      error
+	}{
+		{mimeType: webrtc.MimeTypeVP8, file: "track.ivf"},
+		{mimeType: "VIDEO/vp8", file: "track.ivf"},
+		{mimeType: webrtc.MimeTypeVP9, file: "track.ivf"},
+		{mimeType: webrtc.MimeTypeH264, file: "track.h264"},
+		{mimeType: webrtc.MimeTypeOpus, file: "track.ogg"},
+		{mimeType: webrtc.MimeTypeAV1, err: ErrCodecNotSupported},
+		{mimeType: webrtc.MimeTypeG722, err: ErrCodecNotSupported},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mimeType, func(t *testing.T) {
+			dir := t.TempDir()
+			codec := webrtc.RTPCodecParameters{
+				RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: tt.mimeType, ClockRate: 48000, Channels: 2},
+			}
+
+			writer, file, err := newMediaWriter(dir, codec, "track")
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+			defer writer.Close()
+
+			if file != tt.file {
+				t.Fatalf("file %q, want %q", file, tt.file)
+			}
+			if _, err = os.Stat(filepath.Join(dir, file)); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// Rtp read loop keeps writing while the recording stops, no packet may go to the closed buffer
+func TestTrackRecorderStopWhileWriting(t *testing.T) {
+	dir := t.TempDir()
+	track := newTestTrack(t, "peer", "audio")
+
+	r, err := newTrackRecorder(dir, track)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for seq := uint16(1); ; seq++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := track.WriteSinks("", testOpusPacket(seq)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		r.entryMu.Lock()
+		started := r.entry.FirstPacketAt != nil
+		r.entryMu.Unlock()
+		if started {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("recorder got no packets")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	entry := r.Stop()
+	close(stop)
+	wg.Wait()
+
+	if entry.FirstPacketAt == nil || entry.StoppedAt == nil || entry.StoppedAt.Before(*entry.FirstPacketAt) {
+		t.Fatalf("entry timing first packet %v stopped %v", entry.FirstPacketAt, entry.StoppedAt)
+	}
+	if again := r.Stop(); again.StoppedAt != entry.StoppedAt {
+		t.Fatal("second stop changed the entry")
+	}
+	if info, err := os.Stat(filepath.Join(dir, entry.File)); err != nil || info.Size() == 0 {
+		t.Fatalf("track file is not written. Err: %v", err)
+	}
+}
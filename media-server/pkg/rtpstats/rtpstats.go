@@ -1,17 +1,58 @@
 package rtpstats
 
 import (
+	"sync"
+
 	"github.com/pion/interceptor/pkg/stats"
+	webrtc "github.com/pion/webrtc/v4"
 )
 
+// Counters of NACKs answered by the SFU packet cache
+type RetransmissionStats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
 type RtpStats struct {
 	getter stats.Getter
+
+	retransmissionsMu sync.Mutex
+	retransmissions   map[webrtc.SSRC]*RetransmissionStats
 }
 
 func (rStat *RtpStats) GetGetter() stats.Getter {
 	return rStat.getter
 }
 
+func (rStat *RtpStats) RecordRetransmissions(ssrc webrtc.SSRC, hits, misses int) {
+	rStat.retransmissionsMu.Lock()
+	defer rStat.retransmissionsMu.Unlock()
+
+	s, exist := rStat.retransmissions[ssrc]
+	if !exist {
+		s = &RetransmissionStats{}
+		rStat.retransmissions[ssrc] = s
+	}
+	s.Hits += uint64(hits)
+	s.Misses += uint64(misses)
+}
+
+// Sum of all ssrcs of the peer connection
+func (rStat *RtpStats) TotalRetransmissions() RetransmissionStats {
+	rStat.retransmissionsMu.Lock()
+	defer rStat.retransmissionsMu.Unlock()
+
+	var total RetransmissionStats
+	for _, s := range rStat.retransmissions {
+		total.Hits += s.Hits
+		total.Misses += s.Misses
+	}
+	return total
+}
+
 func NewRtpStats(getter stats.Getter) *RtpStats {
-	return &RtpStats{getter}
+	return &RtpStats{
+		getter:          getter,
+		retransmissions: make(map[webrtc.SSRC]*RetransmissionStats),
+	}
 }
@@ -0,0 +1,15 @@
+package rtpstats
+
+import "testing"
+
+func TestTotalRetransmissions(t *testing.T) {
+	s := NewRtpStats(nil)
+
+	s.RecordRetransmissions(1, 3, 1)
+	s.RecordRetransmissions(2, 2, 0)
+	s.RecordRetransmissions(1, 1, 2)
+
+	if total := s.TotalRetransmissions(); total.Hits != 6 || total.Misses != 3 {
+		t.Fatalf("total hits %d misses %d, want 6 and 3", total.Hits, total.Misses)
+	}
+}
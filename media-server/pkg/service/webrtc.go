@@ -6,9 +6,14 @@ import (
 
 	ice "github.com/pion/ice/v3"
 	"github.com/pion/interceptor"
-	"github.com/pion/interceptor/pkg/intervalpli"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/gcc"
+	"github.com/pion/interceptor/pkg/nack"
 	"github.com/pion/interceptor/pkg/stats"
+	"github.com/pion/interceptor/pkg/twcc"
+	"github.com/pion/sdp/v3"
 	webrtc "github.com/pion/webrtc/v4"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/bwe"
 	"github.com/romashorodok/conferencing-platform/media-server/pkg/rtpstats"
 	"github.com/romashorodok/conferencing-platform/media-server/pkg/variables"
 	"go.uber.org/fx"
@@ -30,11 +35,17 @@ var (
 	)
 )
 
-func webrtcAPI(params webrtcAPI_Params) (*webrtc.API, chan *rtpstats.RtpStats, error) {
+const (
+	_BWE_INITIAL_BITRATE = 1_000_000
+	_BWE_MIN_BITRATE     = 100_000
+	_BWE_MAX_BITRATE     = 10_000_000
+)
+
+func webrtcAPI(params webrtcAPI_Params) (*webrtc.API, chan *rtpstats.RtpStats, chan *bwe.BandwidthEstimator, error) {
 	mediaEngine := &webrtc.MediaEngine{}
 	err := mediaEngine.RegisterDefaultCodecs()
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	mediaSettings := webrtc.SettingEngine{}
@@ -44,12 +55,12 @@ func webrtcAPI(params webrtcAPI_Params) (*webrtc.API, chan *rtpstats.RtpStats, e
 
 	udpPort, err := variables.ParseInt(WEBRTC_PORT)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	udpMux, err := ice.NewMultiUDPMuxFromPort(udpPort)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	mediaSettings.SetICEUDPMux(udpMux)
@@ -58,16 +69,12 @@ func webrtcAPI(params webrtcAPI_Params) (*webrtc.API, chan *rtpstats.RtpStats, e
 		mediaSettings.SetNAT1To1IPs([]string{ONE_TO_NAT_PUBLIC_IP}, webrtc.ICECandidateTypeHost)
 	}
 
+	// NOTE: Keyframes are requested by subscribers through the track context instead of interval PLI
 	interceptorRegistry := &interceptor.Registry{}
-	pli, err := intervalpli.NewReceiverInterceptor()
-	if err != nil {
-		return nil, nil, err
-	}
-	interceptorRegistry.Add(pli)
 
 	statsInterceptorFactory, err := stats.NewInterceptor()
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	rtpStatsCh := make(chan *rtpstats.RtpStats, 1)
@@ -77,15 +84,64 @@ func webrtcAPI(params webrtcAPI_Params) (*webrtc.API, chan *rtpstats.RtpStats, e
 	})
 	interceptorRegistry.Add(statsInterceptorFactory)
 
-	if err := webrtc.RegisterDefaultInterceptors(mediaEngine, interceptorRegistry); err != nil {
-		return nil, nil, err
+	// NOTE: Pacer is no-op, the rate is controlled by picking simulcast layers or pausing tracks
+	congestionController, err := cc.NewInterceptor(func() (cc.BandwidthEstimator, error) {
+		return gcc.NewSendSideBWE(
+			gcc.SendSideBWEInitialBitrate(_BWE_INITIAL_BITRATE),
+			gcc.SendSideBWEMinBitrate(_BWE_MIN_BITRATE),
+			gcc.SendSideBWEMaxBitrate(_BWE_MAX_BITRATE),
+			gcc.SendSideBWEPacer(gcc.NewNoOpPacer()),
+		)
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	bweCh := make(chan *bwe.BandwidthEstimator, 1)
+	congestionController.OnNewPeerConnection(func(_ string, estimator cc.BandwidthEstimator) {
+		bweCh <- bwe.NewBandwidthEstimator(estimator)
+	})
+	interceptorRegistry.Add(congestionController)
+
+	// NOTE: Publishers send their audio level, it's used for active speaker detection
+	if err = mediaEngine.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: sdp.AudioLevelURI}, webrtc.RTPCodecTypeAudio); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// NOTE: Transport-cc sequence numbers of the sent packets, send side bwe relies on them.
+	// Header extension itself is registered by the twcc sender below
+	twccHeaderExtension, err := twcc.NewHeaderExtensionInterceptor()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	interceptorRegistry.Add(twccHeaderExtension)
+
+	// NOTE: Only NACK generator for publishers. Subscriber NACKs are answered by the sfu packet cache,
+	// default responder would keep own copy of every sent packet and retransmit twice.
+	// Nack feedback is already advertised by the default codecs
+	nackGenerator, err := nack.NewGeneratorInterceptor()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	interceptorRegistry.Add(nackGenerator)
+
+	if err = webrtc.ConfigureRTCPReports(interceptorRegistry); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err = webrtc.ConfigureSimulcastExtensionHeaders(mediaEngine); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err = webrtc.ConfigureTWCCSender(mediaEngine, interceptorRegistry); err != nil {
+		return nil, nil, nil, err
 	}
 
 	return webrtc.NewAPI(
 		webrtc.WithMediaEngine(mediaEngine),
 		webrtc.WithSettingEngine(mediaSettings),
 		webrtc.WithInterceptorRegistry(interceptorRegistry),
-	), rtpStatsCh, nil
+	), rtpStatsCh, bweCh, nil
 }
 
 var WebrtcModule = fx.Module("webrtc", fx.Provide(
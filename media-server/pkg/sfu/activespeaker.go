@@ -0,0 +1,200 @@
+package sfu
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+	webrtc "github.com/pion/webrtc/v4"
+)
+
+const (
+	// Audio level is in -dBov, 0 is the loudest and 127 is silence. Quieter than this is not a speech
+	_SPEAKER_LEVEL_THRESHOLD = 60
+	// Weight of the new packet level. Audio packet goes each 20ms, so it's around 400ms of smoothing
+	_SPEAKER_SMOOTHING = 0.05
+	// Dominant speaker changes only if other one is louder by this margin
+	_SPEAKER_SWITCH_MARGIN = 6
+	_SPEAKER_STALE         = time.Second
+	_SPEAKER_INTERVAL      = 300 * time.Millisecond
+)
+
+type ActiveSpeaker struct {
+	PeerID  string `json:"peerId"`
+	TrackID string `json:"trackId"`
+	// Smoothed loudness from 0 to 127, higher is louder
+	Level int `json:"level"`
+}
+
+type ActiveSpeakersMessage struct {
+	Dominant string          `json:"dominant"`
+	Speakers []ActiveSpeaker `json:"speakers"`
+}
+
+type speakerLevel struct {
+	trackID    string
+	smoothed   float64
+	lastUpdate time.Time
+}
+
+// Room wide detector of who is talking, fed by the ssrc-audio-level extension of published audio
+type ActiveSpeakerDetector struct {
+	levelsMu sync.Mutex
+	levels   map[string]*speakerLevel
+
+	dominant string
+	last     ActiveSpeakersMessage
+}
+
+func (d *ActiveSpeakerDetector) Observe(peerID, trackID string, level uint8) {
+	loudness := 0.0
+	if level <= _SPEAKER_LEVEL_THRESHOLD {
+		loudness = float64(127 - level)
+	}
+
+	d.levelsMu.Lock()
+	defer d.levelsMu.Unlock()
+
+	speaker, exist := d.levels[peerID]
+	if !exist {
+		speaker = &speakerLevel{}
+		d.levels[peerID] = speaker
+	}
+	speaker.trackID = trackID
+	speaker.smoothed += (loudness - speaker.smoothed) * _SPEAKER_SMOOTHING
+	speaker.lastUpdate = time.Now()
+}
+
+func (d *ActiveSpeakerDetector) Remove(peerID string) {
+	d.levelsMu.Lock()
+	defer d.levelsMu.Unlock()
+	delete(d.levels, peerID)
+}
+
+func (d *ActiveSpeakerDetector) speakers() []ActiveSpeaker {
+	d.levelsMu.Lock()
+	defer d.levelsMu.Unlock()
+
+	threshold := float64(127 - _SPEAKER_LEVEL_THRESHOLD)
+	result := make([]ActiveSpeaker, 0)
+	for peerID, speaker := range d.levels {
+		// NOTE: Muted or DTX track don't send packets
+		if time.Since(speaker.lastUpdate) > _SPEAKER_STALE {
+			speaker.smoothed = 0
+			continue
+		}
+		if speaker.smoothed < threshold {
+			continue
+		}
+		result = append(result, ActiveSpeaker{
+			PeerID:  peerID,
+			TrackID: speaker.trackID,
+			Level:   int(speaker.smoothed),
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Level == result[j].Level {
+			return result[i].PeerID < result[j].PeerID
+		}
+		return result[i].Level > result[j].Level
+	})
+	return result
+}
+
+// Returns active speakers and true when they changed since the previous call
+func (d *ActiveSpeakerDetector) Detect() (ActiveSpeakersMessage, bool) {
+	speakers := d.speakers()
+
+	var current *ActiveSpeaker
+	for i := range speakers {
+		if speakers[i].PeerID == d.dominant {
+			current = &speakers[i]
+			break
+		}
+	}
+
+	switch {
+	case len(speakers) == 0:
+		// NOTE: Keep the last dominant speaker on silence, clients don't need to reorder tiles
+	case current == nil:
+		d.dominant = speakers[0].PeerID
+	case speakers[0].Level-current.Level >= _SPEAKER_SWITCH_MARGIN:
+		d.dominant = speakers[0].PeerID
+	}
+
+	msg := ActiveSpeakersMessage{
+		Dominant: d.dominant,
+		Speakers: speakers,
+	}
+	if msg.Dominant == d.last.Dominant && sameSpeakers(msg.Speakers, d.last.Speakers) {
+		return msg, false
+	}
+	d.last = msg
+	return msg, true
+}
+
+func sameSpeakers(a, b []ActiveSpeaker) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].PeerID != b[i].PeerID {
+			return false
+		}
+	}
+	return true
+}
+
+// Periodically detect speakers and call f on change
+func (d *ActiveSpeakerDetector) Run(ctx context.Context, f func(ActiveSpeakersMessage)) {
+	ticker := time.NewTicker(_SPEAKER_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if msg, changed := d.Detect(); changed {
+				f(msg)
+			}
+		}
+	}
+}
+
+func NewActiveSpeakerDetector() *ActiveSpeakerDetector {
+	return &ActiveSpeakerDetector{
+		levels: make(map[string]*speakerLevel),
+	}
+}
+
+// Negotiated id of the audio level extension or zero if publisher don't send it
+func audioLevelExtensionID(recv *webrtc.RTPReceiver) uint8 {
+	for _, ext := range recv.GetParameters().HeaderExtensions {
+		if ext.URI == sdp.AudioLevelURI {
+			return uint8(ext.ID)
+		}
+	}
+	return 0
+}
+
+func parseAudioLevel(id uint8, pkt *rtp.Packet) (uint8, bool) {
+	if id == 0 {
+		return 0, false
+	}
+
+	payload := pkt.GetExtension(id)
+	if payload == nil {
+		return 0, false
+	}
+
+	var ext rtp.AudioLevelExtension
+	if err := ext.Unmarshal(payload); err != nil {
+		return 0, false
+	}
+	return ext.Level, true
+}
@@ -0,0 +1,231 @@
+package sfu
+
+import (
+	"context"
+	"log"
+
+	"github.com/google/uuid"
+	webrtc "github.com/pion/webrtc/v4"
+)
+
+const _AUDIO_MIX_STREAM_ID = "audio-mix"
+
+// NOTE: Mix is made by the server, it has no publisher
+const AUDIO_MIX_PARTICIPANT_ID = "server"
+
+var AUDIO_MIX_CODEC = webrtc.RTPCodecCapability{
+	MimeType:    webrtc.MimeTypeOpus,
+	ClockRate:   48000,
+	Channels:    2,
+	SDPFmtpLine: "minptime=10;useinbandfec=1",
+}
+
+// Marks the synthetic track, media is written by the mixer pipeline
+var FILTER_AUDIO_MIX = &Filter{
+	Name: "audio mix",
+	MimeTypes: []MimeType{
+		MIME_TYPE_AUDIO,
+	},
+}
+
+// Pipeline which mixes audio of the room into the output track. Room tracks come as listener events
+type AudioMixer interface {
+	TrackListener
+	Start() error
+	Close() error
+}
+
+// Tracks of the excluded peer are not mixed, subscriber must not hear own voice
+type AudioMixerAllocator = func(output *TrackContext, excludePeerID string) (AudioMixer, error)
+
+func (ctx *AllocatorsContext) RegisterAudioMixer(alloc AudioMixerAllocator) {
+	ctx.audioMixer = alloc
+}
+
+func (ctx *AllocatorsContext) AllocateAudioMixer(output *TrackContext, excludePeerID string) (AudioMixer, error) {
+	if ctx.audioMixer == nil {
+		return nil, ErrAudioMixUnsupported
+	}
+	return ctx.audioMixer(output, excludePeerID)
+}
+
+// Track of one subscriber which is not published by any peer
+func newAudioMixTrackContext(ctx context.Context, api *webrtc.API) (*TrackContext, error) {
+	id := uuid.NewString()
+
+	media, err := NewTrackWriterSample(AUDIO_MIX_CODEC, id, _AUDIO_MIX_STREAM_ID)
+	if err != nil {
+		return nil, err
+	}
+
+	params := NewTrackContextParams{
+		SourcePeerID: AUDIO_MIX_PARTICIPANT_ID,
+		ID:           id,
+		StreamID:     _AUDIO_MIX_STREAM_ID,
+		CodecParams: webrtc.RTPCodecParameters{
+			RTPCodecCapability: AUDIO_MIX_CODEC,
+		},
+		Kind: webrtc.RTPCodecTypeAudio,
+		API:  api,
+	}
+
+	c, cancel := context.WithCancel(ctx)
+	t := &TrackContext{
+		SourcePeerID: params.SourcePeerID,
+		webrtc:       params.API,
+		id:           params.ID,
+		streamID:     params.StreamID,
+		codecParams:  params.CodecParams,
+		codecKind:    params.Kind,
+		layers:       make(map[string]*trackLayer),
+		metadata:     newTrackMetadata(params),
+		observers:    make([]chan TrackContextMessage[any], 0),
+		filter:       FILTER_AUDIO_MIX,
+		media:        media,
+		ctx:          c,
+		cancel:       cancel,
+	}
+	t.metadata.Label = "Audio mix"
+	t.touch()
+	return t, nil
+}
+
+type AudioMixMessage struct {
+	Enabled bool `json:"enabled"`
+}
+
+// Client matches the mixed track by the ids
+type AudioMixState struct {
+	Enabled  bool   `json:"enabled"`
+	TrackID  string `json:"trackId,omitempty"`
+	StreamID string `json:"streamId,omitempty"`
+}
+
+type audioMix struct {
+	track *TrackContext
+	mixer AudioMixer
+}
+
+// Subscriber gets one mixed track instead of audio tracks of other participants
+func (p *PeerContext) SetAudioMix(msg AudioMixMessage) error {
+	if msg.Enabled {
+		if err := p.enableAudioMix(); err != nil {
+			return err
+		}
+	} else {
+		p.disableAudioMix()
+	}
+
+	p.reconciler.Request()
+	return p.Signal.DispatchEvent("audio-mix", p.AudioMixState())
+}
+
+// Slot is reserved under the mix lock, so only one mix is allocated.
+// NOTE: Pool is not touched under the mix lock, pool calls peer close under own lock
+func (p *PeerContext) enableAudioMix() error {
+	p.audioMixMu.Lock()
+	if p.audioMix != nil || p.audioMixReserved {
+		p.audioMixMu.Unlock()
+		return nil
+	}
+	p.audioMixReserved = true
+	p.audioMixMu.Unlock()
+
+	track, err := newAudioMixTrackContext(p.ctx, p.webrtc)
+	if err != nil {
+		p.releaseAudioMixReservation()
+		return err
+	}
+
+	mixer, err := p.pipeAllocContext.AllocateAudioMixer(track, p.peerID)
+	if err != nil {
+		p.releaseAudioMixReservation()
+		_ = track.Close()
+		return err
+	}
+	if err = mixer.Start(); err != nil {
+		p.releaseAudioMixReservation()
+		_ = mixer.Close()
+		_ = track.Close()
+		return err
+	}
+	mix := &audioMix{
+		track: track,
+		mixer: mixer,
+	}
+
+	p.spreader.AddTrackListenerWithSnapshot(mixer)
+
+	p.audioMixMu.Lock()
+	reserved := p.audioMixReserved
+	p.audioMixReserved = false
+	select {
+	case <-p.Done():
+		p.audioMixMu.Unlock()
+		p.closeAudioMix(mix)
+		return p.Err()
+	default:
+	}
+	if !reserved {
+		p.audioMixMu.Unlock()
+		p.closeAudioMix(mix)
+		return nil
+	}
+	p.audioMix = mix
+	p.audioMixMu.Unlock()
+
+	log.Printf("[AudioMix] %s enabled mix %s", p.peerID, track.ID())
+	return nil
+}
+
+func (p *PeerContext) releaseAudioMixReservation() {
+	p.audioMixMu.Lock()
+	p.audioMixReserved = false
+	p.audioMixMu.Unlock()
+}
+
+func (p *PeerContext) disableAudioMix() {
+	p.audioMixMu.Lock()
+	mix := p.audioMix
+	p.audioMix = nil
+	p.audioMixReserved = false
+	p.audioMixMu.Unlock()
+
+	if mix != nil {
+		p.closeAudioMix(mix)
+		log.Printf("[AudioMix] %s disabled mix %s", p.peerID, mix.track.ID())
+	}
+}
+
+func (p *PeerContext) closeAudioMix(mix *audioMix) {
+	p.spreader.RemoveTrackListener(mix.mixer)
+	if err := mix.mixer.Close(); err != nil {
+		log.Printf("[AudioMix] %s unable close mixer. Err: %s", p.peerID, err)
+	}
+	_ = mix.track.Close()
+}
+
+func (p *PeerContext) AudioMixState() AudioMixState {
+	p.audioMixMu.Lock()
+	defer p.audioMixMu.Unlock()
+
+	if p.audioMix == nil {
+		return AudioMixState{}
+	}
+	return AudioMixState{
+		Enabled:  true,
+		TrackID:  p.audioMix.track.ID(),
+		StreamID: p.audioMix.track.StreamID(),
+	}
+}
+
+// Nil when subscriber receives audio tracks as is
+func (p *PeerContext) AudioMixTrack() *TrackContext {
+	p.audioMixMu.Lock()
+	defer p.audioMixMu.Unlock()
+
+	if p.audioMix == nil {
+		return nil
+	}
+	return p.audioMix.track
+}
@@ -0,0 +1,136 @@
+package sfu
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	webrtc "github.com/pion/webrtc/v4"
+)
+
+const (
+	_DATA_CHANNEL_LABEL = "room"
+	// NOTE: Larger messages are fragmented by sctp, keep relay cheap
+	_DATA_MESSAGE_MAX_SIZE = 16 * 1024
+
+	_DATA_RATE_LIMIT = 20
+	_DATA_RATE_BURST = 40
+)
+
+type DataMessageType string
+
+const (
+	DataMessageTypeMessage DataMessageType = "message"
+	DataMessageTypeError   DataMessageType = "error"
+)
+
+// Envelope of the room data channel. Sender sets only To and Payload, empty To is broadcast
+type DataMessage struct {
+	Type    DataMessageType `json:"type"`
+	From    string          `json:"from,omitempty"`
+	To      string          `json:"to,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// Token bucket of the sender
+type dataRateLimiter struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func (l *dataRateLimiter) Allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * _DATA_RATE_LIMIT
+	if l.tokens > _DATA_RATE_BURST {
+		l.tokens = _DATA_RATE_BURST
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+func newDataRateLimiter() *dataRateLimiter {
+	return &dataRateLimiter{
+		tokens: _DATA_RATE_BURST,
+		last:   time.Now(),
+	}
+}
+
+type dataChannel struct {
+	channel *webrtc.DataChannel
+	limiter *dataRateLimiter
+}
+
+func (d *dataChannel) Send(msg DataMessage) error {
+	if d.channel.ReadyState() != webrtc.DataChannelStateOpen {
+		return ErrDataChannelNotOpen
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return d.channel.SendText(string(data))
+}
+
+// Open application channel of the room. Messages are relayed by the spreader to other participants
+func (p *PeerContext) OpenDataChannel() error {
+	channel, err := p.peerConnection.CreateDataChannel(_DATA_CHANNEL_LABEL, nil)
+	if err != nil {
+		return err
+	}
+
+	p.dataChannel = &dataChannel{
+		channel: channel,
+		limiter: newDataRateLimiter(),
+	}
+
+	channel.OnMessage(func(raw webrtc.DataChannelMessage) {
+		if err := p.relayData(raw.Data); err != nil {
+			log.Printf("[DataChannel] %s unable relay message. Err: %s", p.peerID, err)
+			_ = p.dataChannel.Send(DataMessage{
+				Type:  DataMessageTypeError,
+				Error: err.Error(),
+			})
+		}
+	})
+	return nil
+}
+
+func (p *PeerContext) relayData(data []byte) error {
+	if len(data) > _DATA_MESSAGE_MAX_SIZE {
+		return ErrDataMessageTooLarge
+	}
+	if !p.dataChannel.limiter.Allow() {
+		return ErrDataMessageRateLimited
+	}
+
+	var msg DataMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return err
+	}
+
+	// NOTE: Sender is never trusted
+	msg.Type = DataMessageTypeMessage
+	msg.From = p.peerID
+	msg.Error = ""
+
+	return p.spreader.RelayData(p, msg)
+}
+
+func (p *PeerContext) SendData(msg DataMessage) error {
+	if p.dataChannel == nil {
+		return ErrDataChannelNotOpen
+	}
+	return p.dataChannel.Send(msg)
+}
@@ -0,0 +1,333 @@
+package sfu
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtp"
+	webrtc "github.com/pion/webrtc/v4"
+)
+
+// Per subscriber writer of the track. Each subscriber has own local track, because they may receive
+// different simulcast layers or be paused. Sequence number and timestamp are rewritten to be continuous
+// on layer switch or resume
+type DownTrack struct {
+	writerMu sync.Mutex
+
+	// NOTE: Sink down track has no local track, it writes into the sink
+	track        webrtc.TrackLocal
+	writer       TrackWriterRTP
+	trackContext *TrackContext
+
+	preferredRID string
+	targetRID    string
+	targetSet    bool
+	currentRID   string
+	active       bool
+
+	bandwidthPaused  atomic.Bool
+	subscriberPaused atomic.Bool
+
+	started       bool
+	lastSeq       uint16
+	lastTimestamp uint32
+	lastWrite     time.Time
+
+	seqOffset       uint16
+	timestampOffset uint32
+
+	segments []downTrackSegment
+}
+
+// Forwarded range of sequence numbers which came from one layer with the same offsets.
+// Used to map NACKed sequence number back to the cached source packet
+type downTrackSegment struct {
+	startSeq        uint16
+	rid             string
+	seqOffset       uint16
+	timestampOffset uint32
+}
+
+const _DOWN_TRACK_MAX_SEGMENTS = 8
+
+func (d *DownTrack) GetLocalTrack() webrtc.TrackLocal {
+	return d.track
+}
+
+// Layer requested by the subscriber. Bandwidth allocation never goes above it
+func (d *DownTrack) SetPreferredLayer(rid string) {
+	d.writerMu.Lock()
+	defer d.writerMu.Unlock()
+
+	d.preferredRID = rid
+	d.setTargetLayer(rid)
+}
+
+func (d *DownTrack) PreferredLayer() string {
+	d.writerMu.Lock()
+	defer d.writerMu.Unlock()
+	return d.preferredRID
+}
+
+func (d *DownTrack) SetTargetLayer(rid string) {
+	d.writerMu.Lock()
+	defer d.writerMu.Unlock()
+	d.setTargetLayer(rid)
+}
+
+func (d *DownTrack) setTargetLayer(rid string) {
+	if d.targetSet && d.targetRID == rid {
+		return
+	}
+	d.targetRID = rid
+	d.targetSet = true
+
+	if !d.active || d.currentRID != rid {
+		d.trackContext.RequestKeyframe(rid)
+	}
+}
+
+func (d *DownTrack) TargetLayer() string {
+	d.writerMu.Lock()
+	defer d.writerMu.Unlock()
+	return d.targetRID
+}
+
+func (d *DownTrack) CurrentLayer() string {
+	d.writerMu.Lock()
+	defer d.writerMu.Unlock()
+	return d.currentRID
+}
+
+func (d *DownTrack) RequestKeyframe() {
+	d.writerMu.Lock()
+	defer d.writerMu.Unlock()
+	d.requestKeyframe()
+}
+
+func (d *DownTrack) requestKeyframe() {
+	if !d.targetSet {
+		d.trackContext.RequestKeyframes()
+		return
+	}
+	d.trackContext.RequestKeyframe(d.targetRID)
+}
+
+// Stop forwarding because subscriber downlink can't sustain even the lowest layer.
+// Transceiver stays untouched, resume starts from a keyframe
+func (d *DownTrack) SetBandwidthPaused(paused bool) {
+	if d.bandwidthPaused.Swap(paused) == paused {
+		return
+	}
+
+	d.writerMu.Lock()
+	defer d.writerMu.Unlock()
+
+	if paused {
+		d.active = false
+		return
+	}
+	if !d.subscriberPaused.Load() {
+		d.requestKeyframe()
+	}
+}
+
+func (d *DownTrack) BandwidthPaused() bool {
+	return d.bandwidthPaused.Load()
+}
+
+// Stop forwarding because subscriber don't render the track, e.g. tile is hidden.
+// Same as bandwidth pause, sender and sdp stay untouched
+func (d *DownTrack) SetSubscriberPaused(paused bool) {
+	if d.subscriberPaused.Swap(paused) == paused {
+		return
+	}
+
+	d.writerMu.Lock()
+	defer d.writerMu.Unlock()
+
+	if paused {
+		d.active = false
+		return
+	}
+	if !d.bandwidthPaused.Load() {
+		d.requestKeyframe()
+	}
+}
+
+// Next packet is written only from a keyframe, sequence number continues from the last written
+func (d *DownTrack) deactivate() {
+	d.writerMu.Lock()
+	defer d.writerMu.Unlock()
+	d.active = false
+}
+
+func (d *DownTrack) SubscriberPaused() bool {
+	return d.subscriberPaused.Load()
+}
+
+func (d *DownTrack) Paused() bool {
+	return d.BandwidthPaused() || d.SubscriberPaused()
+}
+
+func (d *DownTrack) switchLayer(rid string, pkt *rtp.Packet) {
+	if d.started {
+		elapsed := time.Since(d.lastWrite).Seconds()
+		timestampStep := uint32(elapsed * float64(d.trackContext.GetClockRate()))
+		if timestampStep == 0 {
+			timestampStep = 1
+		}
+
+		d.seqOffset = pkt.SequenceNumber - d.lastSeq - 1
+		d.timestampOffset = pkt.Timestamp - d.lastTimestamp - timestampStep
+	}
+
+	if d.currentRID != rid {
+		log.Printf("[DownTrack] track %s switch layer %q -> %q", d.trackContext.ID(), d.currentRID, rid)
+	}
+	d.currentRID = rid
+	d.active = true
+	d.started = true
+
+	d.segments = append(d.segments, downTrackSegment{
+		startSeq:        pkt.SequenceNumber - d.seqOffset,
+		rid:             rid,
+		seqOffset:       d.seqOffset,
+		timestampOffset: d.timestampOffset,
+	})
+	if len(d.segments) > _DOWN_TRACK_MAX_SEGMENTS {
+		d.segments = d.segments[len(d.segments)-_DOWN_TRACK_MAX_SEGMENTS:]
+	}
+}
+
+// Find the source packet of the forwarded sequence number and rewrite it the same way it was sent
+func (d *DownTrack) lookup(seq uint16) (*rtp.Packet, bool) {
+	d.writerMu.Lock()
+	defer d.writerMu.Unlock()
+
+	// NOTE: Not sent yet
+	if !d.started || int16(d.lastSeq-seq) < 0 {
+		return nil, false
+	}
+
+	for i := len(d.segments) - 1; i >= 0; i-- {
+		segment := d.segments[i]
+		if int16(seq-segment.startSeq) < 0 {
+			continue
+		}
+
+		layer, exist := d.trackContext.Layer(segment.rid)
+		if !exist {
+			return nil, false
+		}
+
+		cached, exist := layer.Packet(seq + segment.seqOffset)
+		if !exist {
+			return nil, false
+		}
+
+		out := &rtp.Packet{
+			Header:  cached.Header.Clone(),
+			Payload: cached.Payload,
+		}
+		out.SequenceNumber = seq
+		out.Timestamp = cached.Timestamp - segment.timestampOffset
+		return out, true
+	}
+	return nil, false
+}
+
+// Answer subscriber NACK from the layer packet cache. Retransmission goes on the media ssrc with the original
+// payload type, receiver takes it as a late packet.
+// NOTE: RTX is negotiated only on the way up. Sender of pion v4 beta don't allocate repair ssrc for local tracks,
+// so there is no ssrc-group to announce and rtx packets sent on the made up ssrc would be dropped by the browser
+func (d *DownTrack) Retransmit(seqs []uint16) (hits, misses int) {
+	for _, seq := range seqs {
+		pkt, exist := d.lookup(seq)
+		if !exist {
+			misses++
+			continue
+		}
+
+		if err := d.writer.WriteRTP(pkt); err != nil {
+			log.Printf("[DownTrack] track %s unable retransmit %d. Err: %s", d.trackContext.ID(), seq, err)
+			continue
+		}
+		hits++
+	}
+	return hits, misses
+}
+
+func (d *DownTrack) WriteRTP(rid string, pkt *rtp.Packet) error {
+	if d.Paused() {
+		return nil
+	}
+
+	d.writerMu.Lock()
+	defer d.writerMu.Unlock()
+
+	if !d.targetSet {
+		// NOTE: Subscriber don't have preferences. Start from the first layer which gives a keyframe
+		d.targetRID = rid
+		d.targetSet = true
+	}
+
+	if rid == d.targetRID && (!d.active || rid != d.currentRID) {
+		if !isKeyframe(d.trackContext.codecParams.MimeType, pkt) {
+			d.trackContext.RequestKeyframe(rid)
+		} else {
+			d.switchLayer(rid, pkt)
+		}
+	}
+
+	if !d.active || rid != d.currentRID {
+		return nil
+	}
+
+	out := *pkt
+	out.SequenceNumber = pkt.SequenceNumber - d.seqOffset
+	out.Timestamp = pkt.Timestamp - d.timestampOffset
+
+	d.lastSeq = out.SequenceNumber
+	d.lastTimestamp = out.Timestamp
+	d.lastWrite = time.Now()
+
+	return d.writer.WriteRTP(&out)
+}
+
+func NewDownTrack(t *TrackContext) (*DownTrack, error) {
+	track, err := webrtc.NewTrackLocalStaticRTP(t.codecParams.RTPCodecCapability, t.ID(), t.StreamID())
+	if err != nil {
+		return nil, err
+	}
+
+	d := &DownTrack{
+		track:        track,
+		writer:       track,
+		trackContext: t,
+	}
+	if !t.IsSimulcast() {
+		d.targetSet = true
+	}
+	return d, nil
+}
+
+// Down track of the server side consumer, e.g. recorder. Simulcast track is consumed from the best layer
+func NewSinkDownTrack(t *TrackContext, sink TrackWriterRTP) *DownTrack {
+	d := &DownTrack{
+		writer:       sink,
+		trackContext: t,
+	}
+	if !t.IsSimulcast() {
+		d.targetSet = true
+		return d
+	}
+	if layers := t.LayerBitrates(); len(layers) > 0 {
+		d.preferredRID = layers[len(layers)-1].RID
+		d.targetRID = d.preferredRID
+		d.targetSet = true
+	}
+	return d
+}
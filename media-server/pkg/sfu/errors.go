@@ -4,7 +4,6 @@ import "errors"
 
 var (
 	ErrPeerConnectionClosed         = errors.New("peerConnection is closed")
-	ErrSignalRetry                  = errors.New("unable dispatch signal offer")
 	ErrRoomAlreadyExists            = errors.New("room already exists")
 	ErrRoomNotExist                 = errors.New("room not exist")
 	ErrRoomIDIsEmpty                = errors.New("room id is empty")
@@ -13,24 +12,39 @@ var (
 	ErrTrackAlreadyExists           = errors.New("track already exists")
 	ErrTrackNotFound                = errors.New("track not found")
 	ErrUnsupportedTrack             = errors.New("track codec is not supported")
+	ErrTrackSourceUnknown           = errors.New("unknown track source")
 	ErrDescNotFound                 = errors.New("session desc not found")
 	ErrEmptyPipelinesArg            = errors.New("require at least one pipeline")
 	ErrInvalidPipelineAllocatorName = errors.New("Invalid pipeline allocator name")
+	ErrAudioMixUnsupported          = errors.New("audio mixer is not registered")
 
 	// ** Subscriber
 	ErrWatchTrackDetachNotFound       = errors.New("subscriber track not found. ")
 	ErrWatchTrackDetachSenderNotFound = errors.New("subscriber sender not found. ")
+	ErrSubscriptionTooLarge           = errors.New("subscription has too many ids")
 
 	// ** ActiveTrackContext
 	ErrSwitchActiveTrackNotFoundSender      = errors.New("active track context not found sender")
 	ErrSwitchActiveTrackNotFoundTransiv     = errors.New("active track context not found transiver")
 	ErrSwitchActiveTrackUnableCreateTransiv = errors.New("unable re-create new transiver")
+	ErrTrackNotSimulcast                    = errors.New("track is not simulcast")
+	ErrSimulcastLayerNotFound               = errors.New("simulcast layer not found")
+	ErrTrackPauseUnsupported                = errors.New("filtered track can't be paused per subscriber")
 
-	// ** SessionDesc
-	ErrSubmitEmptyPendingSessionDesc = errors.New("don't have pending session desc to submit. ")
-	ErrSubmitOfferStateEmpty         = errors.New("passed empty offer hash")
-	ErrSubmitOfferRaceCondition      = errors.New("submit offer race condition found")
+	// ** Negotiator
+	ErrSubmitOfferStateEmpty       = errors.New("passed empty offer hash")
+	ErrSubmitOfferRaceCondition    = errors.New("submit offer race condition found")
+	ErrNegotiationUnexpectedAnswer = errors.New("answer without pending offer")
+
+	// ** DataChannel
+	ErrDataChannelNotOpen     = errors.New("data channel is not open")
+	ErrDataMessageTooLarge    = errors.New("data message is too large")
+	ErrDataMessageRateLimited = errors.New("data message rate limit exceeded")
+	ErrParticipantNotFound    = errors.New("participant not found")
 
 	// ** TransceiverPool
 	ErrNotFoundTransceiver = errors.New("not found transceiver")
+
+	// ** Ingest
+	ErrIngestPeerNotPublishOnly = errors.New("ingest track needs publish only peer")
 )
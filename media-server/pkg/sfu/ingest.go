@@ -0,0 +1,94 @@
+package sfu
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/pion/rtp"
+	webrtc "github.com/pion/webrtc/v4"
+)
+
+type IngestTrackParams struct {
+	Codec       webrtc.RTPCodecCapability
+	PayloadType webrtc.PayloadType
+	Kind        webrtc.RTPCodecType
+	StreamID    string
+	Label       string
+}
+
+// Publish track of the server side source, e.g. rtsp camera. Packets come from the ingest pipeline,
+// not from the peer connection, but they are spread the same way as the browser ones
+type IngestTrack struct {
+	peer  *PeerContext
+	pub   *PublishTrackContext
+	layer *trackLayer
+
+	unpublished sync.Once
+}
+
+func (i *IngestTrack) TrackContext() *TrackContext {
+	return i.pub.trackContext
+}
+
+func (i *IngestTrack) WriteRTP(pkt *rtp.Packet) error {
+	tctx := i.pub.trackContext
+	select {
+	case <-tctx.Done():
+		return ErrTrackNotFound
+	default:
+	}
+
+	i.peer.writeTrackRTP(tctx, i.layer, pkt, tctx, 0)
+	return nil
+}
+
+func (i *IngestTrack) Close() error {
+	i.unpublished.Do(func() {
+		tctx := i.pub.trackContext
+		i.peer.publishTrackDelete(i.pub)
+		i.peer.spreader.TrackUnpublished(i.peer, tctx)
+		_ = tctx.Close()
+	})
+	return nil
+}
+
+// NOTE: Track has no peer connection, keyframe is not requested and comes by the encoder interval
+func (p *PeerContext) PublishIngestTrack(params IngestTrackParams) (*IngestTrack, error) {
+	if !p.publishOnly {
+		return nil, ErrIngestPeerNotPublishOnly
+	}
+
+	tctx := NewTrackContext(p.ctx, NewTrackContextParams{
+		SourcePeerID: p.peerID,
+		ID:           uuid.NewString(),
+		StreamID:     params.StreamID,
+		PayloadType:  params.PayloadType,
+		CodecParams: webrtc.RTPCodecParameters{
+			RTPCodecCapability: params.Codec,
+			PayloadType:        params.PayloadType,
+		},
+		Kind:             params.Kind,
+		API:              p.webrtc,
+		PipeAllocContext: p.pipeAllocContext,
+	})
+	if params.Label != "" {
+		tctx.UpdateMetadata(func(m *TrackMetadata) {
+			m.Label = params.Label
+		})
+	}
+
+	layer, exist := tctx.Layer("")
+	if !exist {
+		_ = tctx.Close()
+		return nil, ErrSimulcastLayerNotFound
+	}
+
+	i := &IngestTrack{
+		peer:  p,
+		pub:   NewPublishTrackContext(tctx),
+		layer: layer,
+	}
+	p.publishTrack(i.pub)
+	p.spreader.TrackPublished(p, tctx)
+	return i, nil
+}
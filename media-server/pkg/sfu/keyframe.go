@@ -0,0 +1,51 @@
+package sfu
+
+import (
+	"sync"
+	"time"
+)
+
+const _KEYFRAME_REQUEST_INTERVAL = time.Millisecond * 500
+
+// Coalesce keyframe requests of all subscribers for one layer. Publisher receives at most one request
+// per interval, request which came too early is delayed until the interval ends instead of dropped
+type keyframeRequester struct {
+	requestMu sync.Mutex
+	last      time.Time
+	pending   bool
+	interval  time.Duration
+	send      func()
+}
+
+func (k *keyframeRequester) Request() {
+	k.requestMu.Lock()
+	defer k.requestMu.Unlock()
+
+	if k.pending {
+		return
+	}
+
+	since := time.Since(k.last)
+	if since >= k.interval {
+		k.last = time.Now()
+		go k.send()
+		return
+	}
+
+	k.pending = true
+	time.AfterFunc(k.interval-since, func() {
+		k.requestMu.Lock()
+		k.pending = false
+		k.last = time.Now()
+		k.requestMu.Unlock()
+
+		k.send()
+	})
+}
+
+func newKeyframeRequester(interval time.Duration, send func()) *keyframeRequester {
+	return &keyframeRequester{
+		interval: interval,
+		send:     send,
+	}
+}
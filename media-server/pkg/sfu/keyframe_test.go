@@ -0,0 +1,95 @@
+package sfu
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type testKeyframeSender struct {
+	mu   sync.Mutex
+	sent []time.Time
+}
+
+func (s *testKeyframeSender) send() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, time.Now())
+}
+
+func (s *testKeyframeSender) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sent)
+}
+
+func (s *testKeyframeSender) waitCount(t *testing.T, want int, timeout time.Duration) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for s.count() < want && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if s.count() != want {
+		t.Fatalf("sent %d requests, want %d", s.count(), want)
+	}
+}
+
+func TestKeyframeRequesterFirstImmediately(t *testing.T) {
+	sender := &testKeyframeSender{}
+	k := newKeyframeRequester(time.Hour, sender.send)
+
+	k.Request()
+	sender.waitCount(t, 1, time.Second)
+}
+
+// Requests of all subscribers within the interval go to the publisher as one delayed request
+func TestKeyframeRequesterCoalesce(t *testing.T) {
+	const interval = 50 * time.Millisecond
+
+	sender := &testKeyframeSender{}
+	k := newKeyframeRequester(interval, sender.send)
+
+	k.Request()
+	sender.waitCount(t, 1, time.Second)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			k.Request()
+		}()
+	}
+	wg.Wait()
+
+	sender.waitCount(t, 2, time.Second)
+	time.Sleep(2 * interval)
+	if sender.count() != 2 {
+		t.Fatalf("sent %d requests, want early ones coalesced into the second", sender.count())
+	}
+
+	sender.mu.Lock()
+	gap := sender.sent[1].Sub(sender.sent[0])
+	sender.mu.Unlock()
+	if gap < interval {
+		t.Fatalf("delayed request sent after %s, want at least %s", gap, interval)
+	}
+}
+
+func TestKeyframeRequesterAfterInterval(t *testing.T) {
+	const interval = 20 * time.Millisecond
+
+	sender := &testKeyframeSender{}
+	k := newKeyframeRequester(interval, sender.send)
+
+	k.Request()
+	sender.waitCount(t, 1, time.Second)
+
+	time.Sleep(interval)
+	k.Request()
+	if k.pending {
+		t.Fatal("request after the interval is delayed, want it sent immediately")
+	}
+	sender.waitCount(t, 2, time.Second)
+}
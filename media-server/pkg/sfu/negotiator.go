@@ -0,0 +1,300 @@
+package sfu
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	webrtc "github.com/pion/webrtc/v4"
+)
+
+type NegotiationState int
+
+const (
+	NegotiationStable NegotiationState = iota
+	NegotiationHaveLocalOffer
+	NegotiationClosed
+)
+
+func (s NegotiationState) String() string {
+	switch s {
+	case NegotiationStable:
+		return "stable"
+	case NegotiationHaveLocalOffer:
+		return "have-local-offer"
+	case NegotiationClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// Offer which is not answered in this time is sent again
+const _NEGOTIATION_OFFER_TIMEOUT = 5 * time.Second
+
+// NOTE: Client which don't answer at all is closed, in-flight offer can't be rolled back.
+// It's longer than the session resume grace, resumed session restarts ice and sends the offer again
+const _NEGOTIATION_OFFER_MAX_RETRIES = 6
+
+type negotiationSignal interface {
+	SendOffer(offer webrtc.SessionDescription, hash string) error
+	SendAnswer(answer webrtc.SessionDescription) error
+}
+
+// Offer/answer state of the peer connection. Server is the impolite peer, on glare it keeps own offer and
+// sends it again, the client rolls back its offer, answers and offers once more from stable.
+// Renegotiation requests during in-flight offer are coalesced into one offer which is sent after the answer
+// NOTE: Pion v4.0.0-beta.7 rejects any rollback in checkNextSignalingState, even though setDescription
+// has the rollback case. In-flight offer leaves only by the answer or by close
+type Negotiator struct {
+	mu sync.Mutex
+
+	peerConnection *webrtc.PeerConnection
+	signal         negotiationSignal
+
+	state   NegotiationState
+	pending bool
+	// NOTE: Ice restart requested during in-flight offer, next offer has new credentials
+	pendingRestart bool
+
+	offer          webrtc.SessionDescription
+	offerHash      string
+	negotiatedHash string
+	offerTimer     *time.Timer
+	offerTimeout   time.Duration
+	offerRetries   int
+
+	// NOTE: Offering client may trickle candidates before its offer is applied
+	candidates []webrtc.ICECandidateInit
+}
+
+func (n *Negotiator) State() NegotiationState {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.state
+}
+
+// Ask for renegotiation. Never blocks on in-flight offer
+func (n *Negotiator) Negotiate() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	switch n.state {
+	case NegotiationClosed:
+		return ErrPeerConnectionClosed
+	case NegotiationHaveLocalOffer:
+		n.pending = true
+		return nil
+	default:
+		return n.createOffer(nil)
+	}
+}
+
+// Offer with new ice credentials. In-flight offer may be lost with the old network, it's sent again
+// and the restart goes right after its answer
+func (n *Negotiator) RestartICE() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	switch n.state {
+	case NegotiationClosed:
+		return ErrPeerConnectionClosed
+	case NegotiationHaveLocalOffer:
+		n.pendingRestart = true
+		n.offerRetries = 0
+		return n.sendOffer()
+	}
+	return n.createOffer(&webrtc.OfferOptions{ICERestart: true})
+}
+
+func (n *Negotiator) createOffer(options *webrtc.OfferOptions) error {
+	if n.peerConnection.ConnectionState() == webrtc.PeerConnectionStateClosed {
+		n.close()
+		return ErrPeerConnectionClosed
+	}
+
+	offer, err := n.peerConnection.CreateOffer(options)
+	if err != nil {
+		return err
+	}
+
+	if err = n.peerConnection.SetLocalDescription(offer); err != nil {
+		return err
+	}
+
+	n.state = NegotiationHaveLocalOffer
+	n.pending = false
+	if options != nil && options.ICERestart {
+		n.pendingRestart = false
+	}
+	n.offer = offer
+	n.offerHash = generateHash(offer.SDP)
+	n.offerRetries = 0
+
+	return n.sendOffer()
+}
+
+// Send in-flight offer and wait for the answer
+func (n *Negotiator) sendOffer() error {
+	n.stopOfferTimer()
+
+	hash := n.offerHash
+	n.offerTimer = time.AfterFunc(n.offerTimeout, func() {
+		n.onOfferTimeout(hash)
+	})
+
+	return n.signal.SendOffer(n.offer, hash)
+}
+
+func (n *Negotiator) onOfferTimeout(hash string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.state != NegotiationHaveLocalOffer || n.offerHash != hash {
+		return
+	}
+
+	if n.offerRetries >= _NEGOTIATION_OFFER_MAX_RETRIES {
+		log.Printf("[Negotiator] offer %s not answered after %d retries. Close peer connection", hash, n.offerRetries)
+		n.offerTimer = nil
+		n.close()
+		// NOTE: State change handlers run in own goroutine, peer context is closed by the room
+		if err := n.peerConnection.Close(); err != nil {
+			log.Println("[Negotiator] Unable close peer connection. Err:", err)
+		}
+		return
+	}
+
+	n.offerRetries++
+	log.Printf("[Negotiator] offer %s not answered. Send again", hash)
+	if err := n.sendOffer(); err != nil {
+		log.Println("[Negotiator] Unable send offer again. Err:", err)
+	}
+}
+
+func (n *Negotiator) stopOfferTimer() {
+	if n.offerTimer != nil {
+		n.offerTimer.Stop()
+		n.offerTimer = nil
+	}
+}
+
+// Apply answer on own offer and send coalesced renegotiation if it was requested meanwhile
+func (n *Negotiator) HandleAnswer(answer webrtc.SessionDescription) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.state != NegotiationHaveLocalOffer {
+		return ErrNegotiationUnexpectedAnswer
+	}
+
+	if err := n.peerConnection.SetRemoteDescription(answer); err != nil {
+		return err
+	}
+	n.flushCandidates()
+
+	n.stopOfferTimer()
+	n.state = NegotiationStable
+	n.negotiatedHash = n.offerHash
+
+	switch {
+	case n.pendingRestart:
+		return n.createOffer(&webrtc.OfferOptions{ICERestart: true})
+	case n.pending:
+		return n.createOffer(nil)
+	}
+	return nil
+}
+
+// Apply remote offer and send answer. On glare remote offer is ignored, own offer is sent again for the client rollback
+func (n *Negotiator) HandleOffer(offer webrtc.SessionDescription) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	switch n.state {
+	case NegotiationClosed:
+		return ErrPeerConnectionClosed
+	case NegotiationHaveLocalOffer:
+		log.Println("[Negotiator] glare. Keep own offer")
+		n.offerRetries = 0
+		return n.sendOffer()
+	}
+
+	if err := n.peerConnection.SetRemoteDescription(offer); err != nil {
+		return err
+	}
+	n.flushCandidates()
+
+	answer, err := n.peerConnection.CreateAnswer(nil)
+	if err != nil {
+		return err
+	}
+
+	if err = n.peerConnection.SetLocalDescription(answer); err != nil {
+		return err
+	}
+
+	if err = n.signal.SendAnswer(answer); err != nil {
+		return err
+	}
+
+	if n.pending {
+		return n.createOffer(nil)
+	}
+	return nil
+}
+
+// Add remote candidate or keep it until remote description is set
+func (n *Negotiator) AddCandidate(candidate webrtc.ICECandidateInit) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.peerConnection.RemoteDescription() == nil {
+		n.candidates = append(n.candidates, candidate)
+		return nil
+	}
+	return n.peerConnection.AddICECandidate(candidate)
+}
+
+func (n *Negotiator) flushCandidates() {
+	for _, candidate := range n.candidates {
+		if err := n.peerConnection.AddICECandidate(candidate); err != nil {
+			log.Println("[Negotiator] Unable add queued candidate. Err:", err)
+		}
+	}
+	n.candidates = nil
+}
+
+// Client confirms which offer it applied. The answer already moved the state, here is only validation
+func (n *Negotiator) Commit(hash string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if hash == "" {
+		return ErrSubmitOfferStateEmpty
+	}
+	if hash != n.negotiatedHash && hash != n.offerHash {
+		return ErrSubmitOfferRaceCondition
+	}
+	return nil
+}
+
+func (n *Negotiator) Close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.close()
+}
+
+func (n *Negotiator) close() {
+	n.stopOfferTimer()
+	n.state = NegotiationClosed
+	n.pending = false
+}
+
+func NewNegotiator(peerConnection *webrtc.PeerConnection, signal negotiationSignal) *Negotiator {
+	return &Negotiator{
+		peerConnection: peerConnection,
+		signal:         signal,
+		offerTimeout:   _NEGOTIATION_OFFER_TIMEOUT,
+	}
+}
@@ -0,0 +1,362 @@
+package sfu
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	webrtc "github.com/pion/webrtc/v4"
+)
+
+type testNegotiationSignal struct {
+	mu      sync.Mutex
+	offers  []webrtc.SessionDescription
+	hashes  []string
+	answers []webrtc.SessionDescription
+}
+
+func (s *testNegotiationSignal) SendOffer(offer webrtc.SessionDescription, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offers = append(s.offers, offer)
+	s.hashes = append(s.hashes, hash)
+	return nil
+}
+
+func (s *testNegotiationSignal) SendAnswer(answer webrtc.SessionDescription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.answers = append(s.answers, answer)
+	return nil
+}
+
+func (s *testNegotiationSignal) offerCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.offers)
+}
+
+func (s *testNegotiationSignal) lastOffer() (webrtc.SessionDescription, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.offers[len(s.offers)-1], s.hashes[len(s.hashes)-1]
+}
+
+func newTestPeerConnection(t *testing.T) *webrtc.PeerConnection {
+	t.Helper()
+
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+		t.Fatal(err)
+	}
+
+	pc, err := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine)).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+	return pc
+}
+
+// Server peer with negotiator and the client peer which plays the browser
+func newTestNegotiator(t *testing.T) (*Negotiator, *testNegotiationSignal, *webrtc.PeerConnection) {
+	t.Helper()
+
+	server := newTestPeerConnection(t)
+	if _, err := server.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo); err != nil {
+		t.Fatal(err)
+	}
+
+	signal := &testNegotiationSignal{}
+	return NewNegotiator(server, signal), signal, newTestPeerConnection(t)
+}
+
+func answerOffer(t *testing.T, client *webrtc.PeerConnection, offer webrtc.SessionDescription) webrtc.SessionDescription {
+	t.Helper()
+
+	if err := client.SetRemoteDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	answer, err := client.CreateAnswer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = client.SetLocalDescription(answer); err != nil {
+		t.Fatal(err)
+	}
+	return answer
+}
+
+func TestNegotiatorOfferAnswer(t *testing.T) {
+	n, signal, client := newTestNegotiator(t)
+
+	if err := n.Negotiate(); err != nil {
+		t.Fatal(err)
+	}
+	if n.State() != NegotiationHaveLocalOffer {
+		t.Fatalf("state %s, want %s", n.State(), NegotiationHaveLocalOffer)
+	}
+
+	offer, hash := signal.lastOffer()
+	if err := n.HandleAnswer(answerOffer(t, client, offer)); err != nil {
+		t.Fatal(err)
+	}
+	if n.State() != NegotiationStable {
+		t.Fatalf("state %s, want %s", n.State(), NegotiationStable)
+	}
+	if signal.offerCount() != 1 {
+		t.Fatalf("sent %d offers, want 1", signal.offerCount())
+	}
+
+	if err := n.Commit(hash); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Commit(""); !errors.Is(err, ErrSubmitOfferStateEmpty) {
+		t.Fatalf("commit empty hash err %v", err)
+	}
+	if err := n.Commit("stale"); !errors.Is(err, ErrSubmitOfferRaceCondition) {
+		t.Fatalf("commit stale hash err %v", err)
+	}
+}
+
+func TestNegotiatorUnexpectedAnswer(t *testing.T) {
+	n, _, _ := newTestNegotiator(t)
+
+	err := n.HandleAnswer(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer})
+	if !errors.Is(err, ErrNegotiationUnexpectedAnswer) {
+		t.Fatalf("err %v, want %v", err, ErrNegotiationUnexpectedAnswer)
+	}
+}
+
+func TestNegotiatorGlare(t *testing.T) {
+	n, signal, client := newTestNegotiator(t)
+
+	if err := n.Negotiate(); err != nil {
+		t.Fatal(err)
+	}
+	_, hash := signal.lastOffer()
+
+	// NOTE: Client offers at the same time, e.g. adds a camera
+	if _, err := client.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio); err != nil {
+		t.Fatal(err)
+	}
+	clientOffer, err := client.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = n.HandleOffer(clientOffer); err != nil {
+		t.Fatal(err)
+	}
+	if len(signal.answers) != 0 {
+		t.Fatalf("sent %d answers, want remote offer ignored", len(signal.answers))
+	}
+	offer, resentHash := signal.lastOffer()
+	if signal.offerCount() != 2 || resentHash != hash {
+		t.Fatalf("sent %d offers, want own offer %s sent again", signal.offerCount(), hash)
+	}
+	if n.State() != NegotiationHaveLocalOffer {
+		t.Fatalf("state %s, want %s", n.State(), NegotiationHaveLocalOffer)
+	}
+
+	// NOTE: Client rolled back own offer, it answers and offers again from stable
+	if err = n.HandleAnswer(answerOffer(t, client, offer)); err != nil {
+		t.Fatal(err)
+	}
+	clientOffer, err = client.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = client.SetLocalDescription(clientOffer); err != nil {
+		t.Fatal(err)
+	}
+	// NOTE: Pion fails to gather again while the first gathering of the server is running
+	<-webrtc.GatheringCompletePromise(n.peerConnection)
+	if err = n.HandleOffer(clientOffer); err != nil {
+		t.Fatal(err)
+	}
+	if len(signal.answers) != 1 {
+		t.Fatalf("sent %d answers, want 1", len(signal.answers))
+	}
+	if err = client.SetRemoteDescription(signal.answers[0]); err != nil {
+		t.Fatal(err)
+	}
+	if n.State() != NegotiationStable {
+		t.Fatalf("state %s, want %s", n.State(), NegotiationStable)
+	}
+}
+
+func TestNegotiatorCoalescePending(t *testing.T) {
+	n, signal, client := newTestNegotiator(t)
+
+	for i := 0; i < 4; i++ {
+		if err := n.Negotiate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if signal.offerCount() != 1 {
+		t.Fatalf("sent %d offers while in-flight, want 1", signal.offerCount())
+	}
+
+	offer, _ := signal.lastOffer()
+	if err := n.HandleAnswer(answerOffer(t, client, offer)); err != nil {
+		t.Fatal(err)
+	}
+	if signal.offerCount() != 2 {
+		t.Fatalf("sent %d offers after answer, want one coalesced", signal.offerCount())
+	}
+
+	offer, _ = signal.lastOffer()
+	if err := n.HandleAnswer(answerOffer(t, client, offer)); err != nil {
+		t.Fatal(err)
+	}
+	if signal.offerCount() != 2 || n.State() != NegotiationStable {
+		t.Fatalf("sent %d offers in state %s, want 2 in %s", signal.offerCount(), n.State(), NegotiationStable)
+	}
+}
+
+func TestNegotiatorClosed(t *testing.T) {
+	n, _, client := newTestNegotiator(t)
+
+	n.Close()
+	if n.State() != NegotiationClosed {
+		t.Fatalf("state %s, want %s", n.State(), NegotiationClosed)
+	}
+	if err := n.Negotiate(); !errors.Is(err, ErrPeerConnectionClosed) {
+		t.Fatalf("negotiate err %v", err)
+	}
+	if err := n.RestartICE(); !errors.Is(err, ErrPeerConnectionClosed) {
+		t.Fatalf("restart ice err %v", err)
+	}
+
+	if _, err := client.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio); err != nil {
+		t.Fatal(err)
+	}
+	clientOffer, err := client.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = n.HandleOffer(clientOffer); !errors.Is(err, ErrPeerConnectionClosed) {
+		t.Fatalf("handle offer err %v", err)
+	}
+}
+
+func TestNegotiatorClosedPeerConnection(t *testing.T) {
+	n, signal, _ := newTestNegotiator(t)
+
+	if err := n.peerConnection.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Negotiate(); !errors.Is(err, ErrPeerConnectionClosed) {
+		t.Fatalf("negotiate err %v", err)
+	}
+	if n.State() != NegotiationClosed || signal.offerCount() != 0 {
+		t.Fatalf("sent %d offers in state %s", signal.offerCount(), n.State())
+	}
+}
+
+// Client which don't answer can't keep the negotiation stuck in the in-flight offer
+func TestNegotiatorOfferRetriesCapped(t *testing.T) {
+	n, signal, _ := newTestNegotiator(t)
+	n.offerTimeout = 10 * time.Millisecond
+
+	if err := n.Negotiate(); err != nil {
+		t.Fatal(err)
+	}
+	_, hash := signal.lastOffer()
+	// NOTE: Coalesced renegotiation can't go without the answer
+	if err := n.Negotiate(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := _NEGOTIATION_OFFER_MAX_RETRIES + 1
+	deadline := time.Now().Add(2 * time.Second)
+	for n.State() != NegotiationClosed && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(10 * n.offerTimeout)
+
+	if signal.offerCount() != want {
+		t.Fatalf("sent %d offers, want %d", signal.offerCount(), want)
+	}
+	if _, resentHash := signal.lastOffer(); resentHash != hash {
+		t.Fatalf("sent offer %s, want the same offer %s", resentHash, hash)
+	}
+	if n.State() != NegotiationClosed || n.peerConnection.ConnectionState() != webrtc.PeerConnectionStateClosed {
+		t.Fatalf("state %s with %s peer connection, want closed", n.State(), n.peerConnection.ConnectionState())
+	}
+	if err := n.Negotiate(); !errors.Is(err, ErrPeerConnectionClosed) {
+		t.Fatalf("negotiate err %v", err)
+	}
+}
+
+// Retries start over when the client is back, e.g. resumed session
+func TestNegotiatorOfferRetriesRestart(t *testing.T) {
+	n, signal, client := newTestNegotiator(t)
+	n.offerTimeout = 10 * time.Millisecond
+
+	if err := n.Negotiate(); err != nil {
+		t.Fatal(err)
+	}
+	// NOTE: Pion can't restart ice while the candidates are still gathered
+	<-webrtc.GatheringCompletePromise(n.peerConnection)
+	for signal.offerCount() < _NEGOTIATION_OFFER_MAX_RETRIES {
+		time.Sleep(time.Millisecond)
+	}
+	if err := n.RestartICE(); err != nil {
+		t.Fatal(err)
+	}
+	offer, _ := signal.lastOffer()
+
+	if err := n.HandleAnswer(answerOffer(t, client, offer)); err != nil {
+		t.Fatal(err)
+	}
+	if n.State() == NegotiationClosed {
+		t.Fatal("resumed client is closed")
+	}
+}
+
+func TestNegotiatorRestartICEInFlight(t *testing.T) {
+	n, signal, client := newTestNegotiator(t)
+
+	if err := n.Negotiate(); err != nil {
+		t.Fatal(err)
+	}
+	_, hash := signal.lastOffer()
+
+	if err := n.RestartICE(); err != nil {
+		t.Fatal(err)
+	}
+	offer, resentHash := signal.lastOffer()
+	if signal.offerCount() != 2 || resentHash != hash {
+		t.Fatalf("sent %d offers, want in-flight offer %s sent again", signal.offerCount(), hash)
+	}
+
+	// NOTE: Pion can't restart ice while the candidates are still gathered
+	<-webrtc.GatheringCompletePromise(n.peerConnection)
+	if err := n.HandleAnswer(answerOffer(t, client, offer)); err != nil {
+		t.Fatal(err)
+	}
+	if signal.offerCount() != 3 {
+		t.Fatalf("sent %d offers, want ice restart after the answer", signal.offerCount())
+	}
+
+	restart, _ := signal.lastOffer()
+	if sdpAttribute(t, restart.SDP, "ice-ufrag") == sdpAttribute(t, offer.SDP, "ice-ufrag") {
+		t.Fatal("ice credentials are not changed")
+	}
+}
+
+func sdpAttribute(t *testing.T, sdp, name string) string {
+	t.Helper()
+
+	for _, line := range strings.Split(sdp, "\r\n") {
+		if value, found := strings.CutPrefix(line, "a="+name+":"); found {
+			return value
+		}
+	}
+	t.Fatalf("sdp has no %s", name)
+	return ""
+}
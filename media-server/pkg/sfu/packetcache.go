@@ -0,0 +1,44 @@
+package sfu
+
+import (
+	"sync"
+
+	"github.com/pion/rtp"
+)
+
+// Around one second of 720p video
+const _PACKET_CACHE_SIZE = 1024
+
+// Bounded history of the received layer packets, indexed by the source sequence number.
+// Shared by all down tracks of the layer, each of them maps own rewritten sequence number back to the source one
+type packetCache struct {
+	mu      sync.RWMutex
+	packets [_PACKET_CACHE_SIZE]*rtp.Packet
+}
+
+func (c *packetCache) Push(pkt *rtp.Packet) {
+	// NOTE: Interceptors of the subscriber may change header extensions in place, keep own copy of the header
+	cached := &rtp.Packet{
+		Header:  pkt.Header.Clone(),
+		Payload: pkt.Payload,
+	}
+
+	c.mu.Lock()
+	c.packets[pkt.SequenceNumber%_PACKET_CACHE_SIZE] = cached
+	c.mu.Unlock()
+}
+
+func (c *packetCache) Get(seq uint16) (*rtp.Packet, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	pkt := c.packets[seq%_PACKET_CACHE_SIZE]
+	if pkt == nil || pkt.SequenceNumber != seq {
+		return nil, false
+	}
+	return pkt, true
+}
+
+func newPacketCache() *packetCache {
+	return &packetCache{}
+}
@@ -0,0 +1,96 @@
+package sfu
+
+import (
+	"testing"
+
+	webrtc "github.com/pion/webrtc/v4"
+)
+
+func TestPacketCache(t *testing.T) {
+	c := newPacketCache()
+
+	for _, seq := range []uint16{1, 2, 65535} {
+		c.Push(testPacket(seq, uint32(seq), []byte{byte(seq)}))
+	}
+	for _, seq := range []uint16{1, 2, 65535} {
+		pkt, exist := c.Get(seq)
+		if !exist || pkt.SequenceNumber != seq {
+			t.Fatalf("packet %d is not cached", seq)
+		}
+	}
+	if _, exist := c.Get(3); exist {
+		t.Fatal("packet 3 is not pushed, want miss")
+	}
+}
+
+func TestPacketCacheEviction(t *testing.T) {
+	c := newPacketCache()
+
+	c.Push(testPacket(10, 0, nil))
+	c.Push(testPacket(10+_PACKET_CACHE_SIZE, 0, nil))
+
+	if _, exist := c.Get(10); exist {
+		t.Fatal("packet out of the cache size is still there")
+	}
+	if _, exist := c.Get(10 + _PACKET_CACHE_SIZE); !exist {
+		t.Fatal("newer packet is not cached")
+	}
+}
+
+// Interceptors of the subscriber may set header extensions on the forwarded packet
+func TestPacketCacheOwnHeader(t *testing.T) {
+	c := newPacketCache()
+
+	pkt := testPacket(1, 0, nil)
+	c.Push(pkt)
+	if err := pkt.Header.SetExtension(1, []byte{0xff}); err != nil {
+		t.Fatal(err)
+	}
+
+	cached, _ := c.Get(1)
+	if cached.Header.Extension || len(cached.Header.Extensions) != 0 {
+		t.Fatal("cached header is changed with the forwarded packet")
+	}
+}
+
+// Nacked sequence number of the subscriber is mapped back to the source packet of the layer it came from
+func TestDownTrackRetransmit(t *testing.T) {
+	track := newTestTrack("video", webrtc.RTPCodecTypeVideo, webrtc.MimeTypeVP8,
+		layerBitrate{RID: "l", Bitrate: 150_000},
+		layerBitrate{RID: "h", Bitrate: 1_500_000},
+	)
+	sink := &testRTPSink{}
+	d := NewSinkDownTrack(track, sink)
+
+	write := func(rid string, seq uint16, payload []byte) {
+		pkt := testPacket(seq, uint32(seq)*3000, payload)
+		layer, _ := track.Layer(rid)
+		layer.cache(pkt)
+		if err := d.WriteRTP(rid, pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("h", 100, testVP8Keyframe)
+	write("h", 101, testVP8Delta)
+	d.SetTargetLayer("l")
+	write("l", 7000, testVP8Keyframe)
+	write("l", 7001, testVP8Delta)
+	assertSequenceNumbers(t, sink, 100, 101, 102, 103)
+
+	sent := sink.packets
+	sink.packets = nil
+
+	hits, misses := d.Retransmit([]uint16{101, 102, 104})
+	if hits != 2 || misses != 1 {
+		t.Fatalf("hits %d misses %d, want 2 and 1 for the not sent packet", hits, misses)
+	}
+	assertSequenceNumbers(t, sink, 101, 102)
+
+	for i, pkt := range sink.packets {
+		original := sent[i+1]
+		if pkt.Timestamp != original.Timestamp || string(pkt.Payload) != string(original.Payload) {
+			t.Fatalf("retransmitted %d differs from the sent one", pkt.SequenceNumber)
+		}
+	}
+}
@@ -3,19 +3,19 @@ package sfu
 import (
 	"context"
 	"crypto/sha256"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
-	"reflect"
+	"sort"
+	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/pion/rtp"
 	webrtc "github.com/pion/webrtc/v4"
-	"github.com/pion/webrtc/v4/pkg/rtcerr"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/bwe"
 	"github.com/romashorodok/conferencing-platform/media-server/pkg/rtpstats"
 )
 
@@ -25,127 +25,6 @@ func generateHash(data string) string {
 	return fmt.Sprintf("%x", hash.Sum(nil))
 }
 
-type SessionDesc struct {
-	negotiated atomic.Pointer[webrtc.SessionDescription]
-	pending    atomic.Pointer[webrtc.SessionDescription]
-
-	negotiatedHash atomic.Value /* string|nil */
-	pendingHash    atomic.Value /* string|nil */
-
-	retry           atomic.Bool
-	sessionMu       sync.Mutex
-	sessionMuLocked atomic.Bool
-
-	ctxDeadline       atomic.Pointer[context.Context]
-	ctxDeadlineCancel atomic.Pointer[context.CancelFunc]
-
-	pctx context.Context
-}
-
-func (s *SessionDesc) LoadDeadlineContext() context.Context {
-	val := s.ctxDeadline.Load()
-	if val == nil {
-		return nil
-	}
-	return *val
-}
-
-func (s *SessionDesc) StoreDeadlineContext(ctx context.Context, cancel context.CancelFunc) {
-	s.ctxDeadline.Store(&ctx)
-	s.ctxDeadlineCancel.Store(&cancel)
-}
-
-func (s *SessionDesc) SetPendingDesc(desc *webrtc.SessionDescription) error {
-	log.Println("[SetPendingDesc] Try lock. Desc:", desc.Type)
-	locked := s.sessionMu.TryLock()
-loop:
-	for !locked {
-		deadline := s.LoadDeadlineContext()
-		if deadline != nil {
-			select {
-			case <-deadline.Done():
-				deadlineTime, _ := deadline.Deadline()
-				log.Printf("[SetPendingDesc] Deadline exhausted at %s. Desc:%s", deadlineTime, desc.Type)
-				s.sessionMu = sync.Mutex{}
-			default:
-			}
-		}
-
-		select {
-		case <-s.pctx.Done():
-			return ErrPeerConnectionClosed
-		default:
-			locked := s.sessionMu.TryLock()
-			if locked {
-				log.Println("[SetPendingDesc] Retry locked. Desc:", desc.Type)
-				break loop
-			}
-			time.Sleep(time.Millisecond * 200)
-			continue
-		}
-	}
-	log.Println("[SetPendingDesc] Already locked. Desc:", desc.Type)
-	s.sessionMuLocked.Store(true)
-
-	ctxDeadline, cancel := context.WithDeadline(s.pctx, time.Now().Add(time.Second))
-	s.StoreDeadlineContext(ctxDeadline, cancel)
-
-	s.pendingHash.Store(generateHash(desc.SDP))
-	s.pending.Store(desc)
-	s.retry.Store(true)
-
-	return nil
-}
-
-var EmptyOfferHash string = ""
-
-func (s *SessionDesc) Submit(offerHash string) error {
-	pending := s.pending.Load()
-
-	if pending == nil {
-		log.Println("[SessionDescSubmit] Pending nil")
-		return ErrSubmitEmptyPendingSessionDesc
-	}
-
-	if offerHash == "" {
-		log.Println("[SessionDescSubmit] offerHash empty")
-		return ErrSubmitOfferStateEmpty
-	}
-
-	swapped := s.pendingHash.CompareAndSwap(offerHash, EmptyOfferHash)
-	if !swapped {
-		log.Println("[SessionDescSubmit] Unable unlock state")
-		return ErrSubmitOfferRaceCondition
-	}
-
-	//
-
-	s.negotiatedHash.Store(offerHash)
-
-	negotiated := s.pending.Swap(nil)
-	s.negotiated.Store(negotiated)
-	s.retry.Store(false)
-
-	if s.sessionMuLocked.Load() {
-		s.sessionMu.Unlock()
-	}
-	return nil
-}
-
-func (s *SessionDesc) GetPending() *webrtc.SessionDescription {
-	return s.pending.Load()
-}
-
-func (s *SessionDesc) GetRetry() bool {
-	return s.retry.Load()
-}
-
-func NewSessionDesc(ctx context.Context) *SessionDesc {
-	return &SessionDesc{
-		pctx: ctx,
-	}
-}
-
 type PeerContext struct {
 	ctx    context.Context
 	cancel context.CancelCauseFunc
@@ -154,12 +33,23 @@ type PeerContext struct {
 	webrtc           *webrtc.API
 	peerConnection   *webrtc.PeerConnection
 	stats            *rtpstats.RtpStats
-	offer            *SessionDesc
+	negotiator       *Negotiator
 	Signal           *Signal
 	Subscriber       *Subscriber
 	pipeAllocContext *AllocatorsContext
 	transceiverPool  *TransceiverPool
 	spreader         trackSpreader
+	reconciler       *senderReconciler
+	dataChannel      *dataChannel
+	// NOTE: Peer only sends media, e.g. WHIP encoder. It's not able to renegotiate
+	publishOnly bool
+	// NOTE: Peer only receives media, e.g. WHEP player. Senders are fixed by the first answer
+	receiveOnly bool
+
+	audioMix   *audioMix
+	audioMixMu sync.Mutex
+	// NOTE: Mix is being enabled, concurrent enable is no-op and disable cancels it
+	audioMixReserved bool
 
 	publishTracks   map[string]*PublishTrackContext
 	publishTracksMu sync.Mutex
@@ -171,11 +61,20 @@ type trackWritable interface {
 }
 
 type trackSpreader interface {
-	TrackDownToPeers(*PeerContext, *TrackContext) error
-	TrackDownStopToPeers(*PeerContext, *TrackContext) error
+	// Tracks of the room which peer must receive
+	DesiredTracks(peerTarget *PeerContext) map[string]*TrackContext
 
-	SanitizePeerSenders(*PeerContext) error
-	PeerPublishingSenders(peerTarget *PeerContext) map[string]OptionalSenderBox[any]
+	ObserveAudioLevel(peerID, trackID string, level uint8)
+
+	RelayData(*PeerContext, DataMessage) error
+
+	AddTrackListenerWithSnapshot(TrackListener) []*TrackContext
+	RemoveTrackListener(TrackListener)
+	PublishedTracks() []*TrackContext
+
+	TrackPublished(*PeerContext, *TrackContext)
+	TrackUnpublished(*PeerContext, *TrackContext)
+	TrackUpdated(*PeerContext, *TrackContext)
 }
 
 func (p *PeerContext) ObserveTrack(track *ActiveTrackContext) {
@@ -187,15 +86,12 @@ func (p *PeerContext) ObserveTrack(track *ActiveTrackContext) {
 
 	for {
 		select {
+		case <-track.Done():
+			// NOTE: Unsubscribed, new active track will have own observer
+			return
 		case <-t.Done():
-			// log.Println("Watch track context doen for", t.ID())
-			p.Subscriber.DeleteTrack(track)
-			p.spreader.SanitizePeerSenders(p)
-
-			// p.Signal.DispatchOffer()
-			// ack := p.Subscriber.DetachTrack(t)
-			// err := <-ack.Result
-			// log.Println("track context done for err", err)
+			// NOTE: Done track is not desired anymore, reconciliation deletes it
+			p.reconciler.Request()
 			return
 		case msg := <-bus:
 			switch evt := msg.Unbox().(type) {
@@ -208,8 +104,7 @@ func (p *PeerContext) ObserveTrack(track *ActiveTrackContext) {
 				}
 				err := track.SwitchActiveTrackMedia(p.webrtc, p.peerConnection)
 				log.Println("On switch active track media", err)
-				p.spreader.SanitizePeerSenders(p)
-				// p.Signal.DispatchOffer()
+				p.reconciler.Invalidate()
 			}
 		}
 	}
@@ -229,10 +124,15 @@ func (p *PeerContext) ObserveSubscriber(sub *Subscriber) {
 			case SubscriberTrackAttached:
 				log.Println("Track attached", evt.track.trackContext.ID())
 				go p.ObserveTrack(evt.ActiveTrack())
-				p.spreader.SanitizePeerSenders(p)
+				// NOTE: Track may be attached outside of reconciliation, e.g. own track
+				p.reconciler.Request()
 			case SubscriberTrackDetached:
-				p.spreader.SanitizePeerSenders(p)
 				log.Println("Get detach event")
+				p.reconciler.Request()
+			case SubscriberBandwidthAllocated:
+				if err := p.Signal.DispatchEvent("bandwidth-allocation", evt.Allocation()); err != nil {
+					log.Println("[ObserveSubscriber] Unable dispatch bandwidth allocation. Err:", err)
+				}
 			}
 		}
 	}
@@ -240,11 +140,15 @@ func (p *PeerContext) ObserveSubscriber(sub *Subscriber) {
 
 func (p *PeerContext) OnTrack() {
 	var onTrackMu sync.Mutex
-	pubStreamID := uuid.NewString()
+	// NOTE: Each source stream of the publisher (camera, screen share) is forwarded as own stream
+	pubStreamIDs := make(map[string]string)
 	filter := FILTER_NONE
 
 	go p.Subscriber.HandleTrackAttach()
 	go p.Subscriber.HandleTrackDetach()
+	go p.Subscriber.HandleBandwidthAllocation()
+	go p.HandleTrackActivity()
+	go p.HandleSenderReconcile()
 
 	go p.ObserveSubscriber(p.Subscriber)
 
@@ -252,98 +156,252 @@ func (p *PeerContext) OnTrack() {
 		onTrackMu.Unlock()
 		log.Println(message...)
 		err = errors.Join(err, ErrUnsupportedTrack)
-		_ = p.Signal.conn.WriteJSON(err)
+		_ = p.Signal.Conn().WriteJSON(err)
 		_ = p.Close(err)
 	}
 
 	p.peerConnection.OnTrack(func(t *webrtc.TrackRemote, recv *webrtc.RTPReceiver) {
 		onTrackMu.Lock()
 
-		log.Println("On track - ID:", t.ID(), "SSRC:", t.SSRC(), "StreamID:", t.StreamID())
+		log.Println("On track - ID:", t.ID(), "SSRC:", t.SSRC(), "StreamID:", t.StreamID(), "RID:", t.RID())
+
+		// NOTE: Each simulcast encoding comes as separate remote track with the same ID.
+		// Group them into one track context and only read the layer
+		if pub, exist := p.getPublishTrack(t.ID()); exist && t.RID() != "" {
+			tctx := pub.trackContext
+			tctx.AddLayer(t.RID(), t.SSRC())
+			onTrackMu.Unlock()
+
+			log.Printf("[OnTrack] track %s add simulcast layer %q", tctx.ID(), t.RID())
+			p.readTrackRTP(t, recv, tctx, tctx)
+			return
+		}
+
+		pubStreamID, exist := pubStreamIDs[t.StreamID()]
+		if !exist {
+			pubStreamID = uuid.NewString()
+			pubStreamIDs[t.StreamID()] = pubStreamID
+		}
+
 		tctx := p.Subscriber.Track(pubStreamID, t, recv, filter)
 
 		ptctx := NewPublishTrackContext(tctx)
 		p.publishTrack(ptctx)
-		defer p.publishTrackDelete(ptctx)
-
-		ack := p.Subscriber.AttachTrack(tctx)
-		select {
-		case <-p.Done():
-			onTrackMu.Unlock()
-			return
-		case err := <-ack.Result:
-			if err != nil {
-				onCloseTrack(err, "[OnTrack] Unable attach track to subscriber. Err:", err)
+		p.spreader.TrackPublished(p, tctx)
+		defer func() {
+			p.publishTrackDelete(ptctx)
+			p.spreader.TrackUnpublished(p, tctx)
+		}()
+
+		var track trackWritable = tctx
+		if !p.publishOnly {
+			ack := p.Subscriber.AttachTrack(tctx)
+			select {
+			case <-p.Done():
+				onTrackMu.Unlock()
 				return
+			case err := <-ack.Result:
+				if err != nil {
+					onCloseTrack(err, "[OnTrack] Unable attach track to subscriber. Err:", err)
+					return
+				}
 			}
+			track = ack.TrackContext
 		}
 
 		// TODO: Remove this
 		TrackContextRegistry.Add(tctx)
 		defer TrackContextRegistry.Remove(tctx)
 
-		err := p.spreader.TrackDownToPeers(p, tctx)
-		if err != nil {
-			onCloseTrack(err, "[OnTrack] Unable down", tctx.ID(), "track. Err:", err)
-			return
+		onTrackMu.Unlock()
+
+		p.readTrackRTP(t, recv, tctx, track)
+
+		// NOTE: Other simulcast layers may be still alive
+		select {
+		case <-p.Done():
+		case <-tctx.Done():
 		}
-		defer p.spreader.TrackDownStopToPeers(p, tctx)
+	})
+}
 
-		onTrackMu.Unlock()
+func (p *PeerContext) readTrackRTP(t *webrtc.TrackRemote, recv *webrtc.RTPReceiver, tctx *TrackContext, track trackWritable) {
+	rid := t.RID()
+	defer tctx.RemoveLayer(rid)
 
-		var track trackWritable = ack.TrackContext
+	var audioLevelID uint8
+	if tctx.codecKind == webrtc.RTPCodecTypeAudio {
+		audioLevelID = audioLevelExtensionID(recv)
+	}
 
-		for {
-			select {
-			case <-p.ctx.Done():
+	layer, exist := tctx.Layer(rid)
+	if !exist {
+		return
+	}
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-tctx.Done():
+			return
+		default:
+		}
+
+		pkt, _, err := t.ReadRTP()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				log.Printf("[EOF] Publish sender track ID: %s RID: %s", t.ID(), rid)
 				return
-			default:
 			}
+			continue
+		}
 
-			pkt, _, err := t.ReadRTP()
-			if err != nil {
-				if errors.Is(err, io.EOF) {
-					log.Printf("[EOF] Publish sender track ID: %s", t.ID())
-					_ = tctx.Close()
-					return
-				}
-				continue
-			}
+		p.writeTrackRTP(tctx, layer, pkt, track, audioLevelID)
+	}
+}
 
-			writer, err := track.GetTrackWriterRTP()
-			if err != nil {
-				log.Println("unable get rtp writer. Err:", err)
-				continue
-			}
+// Spread packet of the publisher to the sinks and subscribers of the track
+func (p *PeerContext) writeTrackRTP(tctx *TrackContext, layer *trackLayer, pkt *rtp.Packet, track trackWritable, audioLevelID uint8) {
+	layer.observe(pkt.MarshalSize())
+	tctx.touch()
 
-			err = writer.WriteRTP(pkt)
-			if err != nil {
-				log.Println("unable write rtp pkt. Err:", err)
-				continue
-			}
+	// NOTE: Browser keeps sending silence or black frames for muted track
+	if tctx.Muted() {
+		return
+	}
+
+	if level, ok := parseAudioLevel(audioLevelID, pkt); ok {
+		p.spreader.ObserveAudioLevel(p.peerID, tctx.ID(), level)
+	}
+
+	if tctx.codecKind == webrtc.RTPCodecTypeVideo && tctx.observeDimensions(layer, pkt) {
+		p.spreader.TrackUpdated(p, tctx)
+	}
+
+	if err := tctx.WriteSinks(layer.rid, pkt); err != nil {
+		log.Println("unable write rtp pkt into sinks. Err:", err)
+	}
+
+	if tctx.UsesDownTracks() {
+		if tctx.codecKind == webrtc.RTPCodecTypeVideo {
+			layer.cache(pkt)
 		}
-	})
+		if err := tctx.WriteDownTracks(layer.rid, pkt); err != nil {
+			log.Println("unable write rtp pkt into down tracks. Err:", err)
+		}
+		return
+	}
+
+	writer, err := track.GetTrackWriterRTP()
+	if err != nil {
+		log.Println("unable get rtp writer. Err:", err)
+		return
+	}
+
+	if err = writer.WriteRTP(pkt); err != nil {
+		log.Println("unable write rtp pkt. Err:", err)
+	}
 }
 
-func (p *PeerContext) GetVideoPublishTrack() (*PublishTrackContext, error) {
+type PublishTrackInfo struct {
+	TrackMetadata
+	Filter string `json:"filter"`
+}
+
+func (p *PeerContext) PublishTracks() []PublishTrackInfo {
 	p.publishTracksMu.Lock()
 	defer p.publishTracksMu.Unlock()
 
+	result := make([]PublishTrackInfo, 0, len(p.publishTracks))
 	for _, pub := range p.publishTracks {
-		if pub.trackContext.codecKind == webrtc.RTPCodecTypeVideo {
-			return pub, nil
+		result = append(result, PublishTrackInfo{
+			TrackMetadata: pub.trackContext.Metadata(),
+			Filter:        pub.trackContext.Filter().GetName(),
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].TrackID < result[j].TrackID
+	})
+	return result
+}
+
+func (p *PeerContext) SetTrackMuted(msg MuteMessage) error {
+	pub, exist := p.getPublishTrack(msg.TrackID)
+	if !exist {
+		return ErrTrackNotFound
+	}
+
+	if pub.SetMuted(msg.Muted) {
+		log.Printf("[PeerContext] %s track %s muted: %t", p.peerID, msg.TrackID, msg.Muted)
+		p.spreader.TrackUpdated(p, pub.trackContext)
+	}
+	return nil
+}
+
+const _TRACK_ACTIVITY_INTERVAL = time.Second
+
+// Report publish tracks which stopped sending packets and which are back
+func (p *PeerContext) HandleTrackActivity() {
+	ticker := time.NewTicker(_TRACK_ACTIVITY_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.Done():
+			return
+		case <-ticker.C:
+			for _, t := range p.publishTrackContexts() {
+				// NOTE: Simulcast layer which stopped sending don't describe the track anymore
+				updated := t.codecKind == webrtc.RTPCodecTypeVideo && t.updateDimensions()
+				if t.observeActivity() {
+					log.Printf("[PeerContext] %s track %s inactive: %t", p.peerID, t.ID(), t.Metadata().Inactive)
+					updated = true
+				}
+				if updated {
+					p.spreader.TrackUpdated(p, t)
+				}
+			}
 		}
 	}
+}
+
+// Publisher tells what the track is, e.g. screen share with a label
+func (p *PeerContext) SetTrackMetadata(msg TrackMetadataMessage) error {
+	pub, exist := p.getPublishTrack(msg.TrackID)
+	if !exist {
+		return ErrTrackNotFound
+	}
 
-	return nil, ErrTrackNotFound
+	changed, err := pub.trackContext.ApplyMetadataMessage(msg)
+	if err != nil {
+		return err
+	}
+	if msg.Muted != nil && pub.SetMuted(*msg.Muted) {
+		changed = true
+	}
+	if changed {
+		p.spreader.TrackUpdated(p, pub.trackContext)
+	}
+	return nil
+}
+
+func (p *PeerContext) GetVideoPublishTrack() (*PublishTrackContext, error) {
+	return p.getPublishTrackByKind(webrtc.RTPCodecTypeVideo)
 }
 
 func (p *PeerContext) GetAudioPublishTrack() (*PublishTrackContext, error) {
+	return p.getPublishTrackByKind(webrtc.RTPCodecTypeAudio)
+}
+
+// First track of the kind. Publisher may have several, e.g. camera and screen share
+func (p *PeerContext) getPublishTrackByKind(kind webrtc.RTPCodecType) (*PublishTrackContext, error) {
 	p.publishTracksMu.Lock()
 	defer p.publishTracksMu.Unlock()
 
 	for _, pub := range p.publishTracks {
-		if pub.trackContext.codecKind == webrtc.RTPCodecTypeAudio {
+		if pub.trackContext.codecKind == kind {
 			return pub, nil
 		}
 	}
@@ -351,7 +409,8 @@ func (p *PeerContext) GetAudioPublishTrack() (*PublishTrackContext, error) {
 	return nil, ErrTrackNotFound
 }
 
-func (p *PeerContext) SwitchFilter(filterName string, mimeTypeName string) error {
+// Set filter of the publish track. Without track id the first track of the mime type kind is used
+func (p *PeerContext) SwitchFilter(trackID string, filterName string, mimeTypeName string) error {
 	filter, err := p.pipeAllocContext.Filter(filterName)
 	if err != nil {
 		return err
@@ -367,16 +426,25 @@ func (p *PeerContext) SwitchFilter(filterName string, mimeTypeName string) error
 		return errors.New("unknown mime type")
 	}
 
-	var track *PublishTrackContext
-	err = nil
+	var kind webrtc.RTPCodecType
 	switch mimeType {
 	case MIME_TYPE_VIDEO:
-		track, err = p.GetVideoPublishTrack()
+		kind = webrtc.RTPCodecTypeVideo
 	case MIME_TYPE_AUDIO:
-		track, err = p.GetAudioPublishTrack()
+		kind = webrtc.RTPCodecTypeAudio
 	default:
 		return errors.New("unknown mime type")
 	}
+
+	var track *PublishTrackContext
+	if trackID != "" {
+		var exist bool
+		if track, exist = p.getPublishTrack(trackID); !exist || track.trackContext.codecKind != kind {
+			err = ErrTrackNotFound
+		}
+	} else {
+		track, err = p.getPublishTrackByKind(kind)
+	}
 	if err != nil {
 		log.Println("Unable switch filter. Not found publish track context")
 		return err
@@ -387,16 +455,6 @@ func (p *PeerContext) SwitchFilter(filterName string, mimeTypeName string) error
 		return err
 	}
 
-	// _ = p.peerConnection.RemoveTrack(track.LoadSender())
-	//
-	// sender, err := p.peerConnection.AddTrack(track.trackContext.GetLocalTrack())
-	// if err != nil {
-	// 	log.Println("unable add track on switch filter", err)
-	// 	return err
-	// }
-	// track.StoreSender(sender)
-	// p.Signal.DispatchOffer()
-
 	return nil
 }
 
@@ -406,8 +464,9 @@ type filterPayload struct {
 	Enabled  bool   `json:"enabled"`
 }
 type filtersResult struct {
-	Audio []filterPayload `json:"audio"`
-	Video []filterPayload `json:"video"`
+	Audio  []filterPayload    `json:"audio"`
+	Video  []filterPayload    `json:"video"`
+	Tracks []PublishTrackInfo `json:"tracks"`
 }
 
 func (p *PeerContext) Filters() *filtersResult {
@@ -438,47 +497,46 @@ func (p *PeerContext) Filters() *filtersResult {
 	}
 
 	return &filtersResult{
-		Audio: audioFilters,
-		Video: videoFilters,
+		Audio:  audioFilters,
+		Video:  videoFilters,
+		Tracks: p.PublishTracks(),
 	}
 }
 
-func (p *PeerContext) SynchronizeOfferState() {
-	ticker := time.NewTicker(time.Second * 4)
-	for {
-		select {
-		case <-p.Done():
-			return
-		case <-ticker.C:
-			senders := p.spreader.PeerPublishingSenders(p)
-			// log.Printf("[SynchronizeOfferState] try sanitize %s", p.PeerID())
-			// log.Printf("PeerPublishingSenders: %+v", senders)
-			// log.Printf("GetSenders: %+v", p.peerConnection.GetSenders())
-			for _, sender := range senders {
-				switch s := sender.value.(type) {
-				case UnattachedSender:
-					log.Printf("[SynchronizeOfferState] found unattached sender. track ID: %s", s.track.trackContext.ID())
-					ack := p.Subscriber.AttachTrack(s.track.trackContext)
-					if err := <-ack.Result; err != nil {
-						log.Println("attach track err", err)
-					}
-				default:
-				}
-			}
+// Client is the offerer, e.g. it adds a new camera or screen share. Answer is sent by the negotiator
+func (p *PeerContext) SetOffer(desc webrtc.SessionDescription) error {
+	return p.negotiator.HandleOffer(desc)
+}
+
+// Answer for the http signaling, e.g. WHIP. Server candidates are not trickled, they are in the answer
+func (p *PeerContext) AnswerOffer(ctx context.Context, desc webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
+	if p.receiveOnly {
+		if err := p.attachOffered(desc); err != nil {
+			return nil, err
 		}
 	}
-}
 
-func (p *PeerContext) CreateDataChannel(label string, options *webrtc.DataChannelInit) (*webrtc.DataChannel, error) {
-	return p.peerConnection.CreateDataChannel(label, options)
+	gathered := webrtc.GatheringCompletePromise(p.peerConnection)
+	if err := p.negotiator.HandleOffer(desc); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-p.Done():
+		return nil, p.Err()
+	case <-gathered:
+	}
+	return p.peerConnection.LocalDescription(), nil
 }
 
 func (p *PeerContext) SetAnswer(desc webrtc.SessionDescription) error {
-	err := p.peerConnection.SetRemoteDescription(desc)
-	if err != nil {
-		return err
-	}
-	return nil
+	return p.negotiator.HandleAnswer(desc)
+}
+
+func (p *PeerContext) SetSimulcastLayer(msg SimulcastLayerMessage) error {
+	return p.Subscriber.SetSimulcastLayer(msg.TrackID, msg.RID)
 }
 
 type CommitOfferStateMessage struct {
@@ -486,59 +544,93 @@ type CommitOfferStateMessage struct {
 }
 
 func (p *PeerContext) CommitOfferState(msg CommitOfferStateMessage) error {
-	return p.offer.Submit(msg.StateHash)
+	return p.negotiator.Commit(msg.StateHash)
 }
 
-type offerResult struct {
-	webrtc.SessionDescription
-
-	HashState string `json:"hash_state"`
+func (p *PeerContext) Negotiate() error {
+	return p.negotiator.Negotiate()
 }
 
-func (p *PeerContext) Offer() (offer string, err error) {
-	if p.peerConnection.ConnectionState() == webrtc.PeerConnectionStateClosed {
-		return "", ErrPeerConnectionClosed
-	}
-
-	offerSdp, err := p.peerConnection.CreateOffer(nil)
-	if err != nil {
-		return "", err
-	}
+func (p *PeerContext) RestartICE() error {
+	return p.negotiator.RestartICE()
+}
 
-	if err = p.offer.SetPendingDesc(&offerSdp); err != nil {
-		return "", err
-	}
+const _ICE_RESTART_DELAY = 3 * time.Second
 
-	err = p.peerConnection.SetLocalDescription(offerSdp)
-	if err != nil {
-		switch e := err.(type) {
-		case *rtcerr.InvalidModificationError:
-			log.Println("GetPending old pending offer")
-			pendingSDP := p.offer.GetPending()
-			if pendingSDP == nil {
-				return "", err
+// Restart ICE if connection don't recover by itself, e.g. client changed network
+func (p *PeerContext) ScheduleICERestart() {
+	time.AfterFunc(_ICE_RESTART_DELAY, func() {
+		switch p.peerConnection.ConnectionState() {
+		case webrtc.PeerConnectionStateDisconnected, webrtc.PeerConnectionStateFailed:
+			log.Printf("[PeerContext] %s restart ice", p.peerID)
+			if err := p.RestartICE(); err != nil {
+				log.Printf("[PeerContext] %s unable restart ice. Err: %s", p.peerID, err)
 			}
+		}
+	})
+}
 
-			offerSdp = *pendingSDP
-		default:
-			log.Println("default error for local desc", reflect.TypeOf(e))
-			return "", err
+func (p *PeerContext) ConnectionState() webrtc.PeerConnectionState {
+	return p.peerConnection.ConnectionState()
+}
+
+// Media sections of the offer by kind
+func offeredSlots(sdp string) map[webrtc.RTPCodecType]int {
+	slots := make(map[webrtc.RTPCodecType]int)
+	for _, line := range strings.Split(sdp, "\n") {
+		switch {
+		case strings.HasPrefix(line, "m=audio"):
+			slots[webrtc.RTPCodecTypeAudio]++
+		case strings.HasPrefix(line, "m=video"):
+			slots[webrtc.RTPCodecTypeVideo]++
 		}
 	}
+	return slots
+}
+
+// Senders of the receive only peer are created before the answer, so offered sections are matched with them.
+// Tracks which don't fit into the offered sections are not sent
+func (p *PeerContext) attachOffered(offer webrtc.SessionDescription) error {
+	slots := offeredSlots(offer.SDP)
 
-	offerJson, err := json.Marshal(offerResult{
-		SessionDescription: offerSdp,
-		HashState:          p.offer.pendingHash.Load().(string),
+	desired := p.spreader.DesiredTracks(p)
+	tracks := make([]*TrackContext, 0, len(desired))
+	for _, t := range desired {
+		tracks = append(tracks, t)
+	}
+	sort.Slice(tracks, func(i, j int) bool {
+		if tracks[i].SourcePeerID != tracks[j].SourcePeerID {
+			return tracks[i].SourcePeerID < tracks[j].SourcePeerID
+		}
+		return tracks[i].ID() < tracks[j].ID()
 	})
-	if err != nil {
-		return "", err
+
+	for _, t := range tracks {
+		if slots[t.Kind()] == 0 {
+			log.Printf("[PeerContext] %s no offered section for track %s", p.peerID, t.ID())
+			continue
+		}
+		slots[t.Kind()]--
+
+		ack := p.Subscriber.AttachTrack(t)
+		select {
+		case <-p.Done():
+			return p.Err()
+		case err := <-ack.Result:
+			if err != nil {
+				return err
+			}
+		}
 	}
+	return nil
+}
 
-	return string(offerJson), nil
+func (p *PeerContext) RemoteDescription() *webrtc.SessionDescription {
+	return p.peerConnection.RemoteDescription()
 }
 
 func (p *PeerContext) SetCandidate(candidate webrtc.ICECandidateInit) error {
-	return p.peerConnection.AddICECandidate(candidate)
+	return p.negotiator.AddCandidate(candidate)
 }
 
 func (p *PeerContext) OnICECandidate(f func(*webrtc.ICECandidate)) {
@@ -548,14 +640,18 @@ func (p *PeerContext) OnICECandidate(f func(*webrtc.ICECandidate)) {
 func (p *PeerContext) Close(err error) error {
 	// TODO: May be leak of not closed/removed resources
 	p.cancel(err)
+	p.disableAudioMix()
+
+	p.negotiator.Close()
 	return p.peerConnection.Close()
 }
 
 // NOTE: Chrome 122 has introduced bug with sendonly transceiver,
 // to expect normal behavior I think better use shadow stream/tracks,
-// also I have sanitize logic to check publish tracks
+// also I have sanitize logic to check publish tracks.
+// Only first track of each kind is pre-created, more tracks (e.g. screen share) come with client offer
 func (p *PeerContext) AddTransceiver(kinds []webrtc.RTPCodecType) error {
-	streamID := "inactive"
+	streamID := _SHADOW_STREAM_ID
 
 	for _, t := range kinds {
 		trackID := uuid.NewString()
@@ -591,18 +687,90 @@ func (p *PeerContext) Err() error {
 
 func (p *PeerContext) SetStats(stats *rtpstats.RtpStats) {
 	p.stats = stats
+	p.Subscriber.SetStats(stats)
+}
+
+// Nacks of the subscriber answered from the packet cache
+func (p *PeerContext) Retransmissions() rtpstats.RetransmissionStats {
+	if p.stats == nil {
+		return rtpstats.RetransmissionStats{}
+	}
+	return p.stats.TotalRetransmissions()
+}
+
+type TrackPauseMessage struct {
+	TrackID string `json:"trackId"`
+	Paused  bool   `json:"paused"`
+}
+
+func (p *PeerContext) SetTrackPaused(msg TrackPauseMessage) error {
+	return p.Subscriber.SetTrackPaused(msg.TrackID, msg.Paused)
+}
+
+func (p *PeerContext) Subscribe(msg SubscriptionMessage) error {
+	if err := p.Subscriber.Subscribe(msg); err != nil {
+		return err
+	}
+	return p.syncSubscription()
+}
+
+func (p *PeerContext) Unsubscribe(msg SubscriptionMessage) error {
+	if err := p.Subscriber.Unsubscribe(msg); err != nil {
+		return err
+	}
+	return p.syncSubscription()
+}
+
+func (p *PeerContext) SetAutoSubscribe(msg AutoSubscribeMessage) error {
+	p.Subscriber.SetAutoSubscribe(msg.Enabled)
+	return p.syncSubscription()
+}
+
+func (p *PeerContext) syncSubscription() error {
+	p.reconciler.Request()
+	return p.Signal.DispatchEvent("subscription", p.Subscriber.Subscription())
+}
+
+func (p *PeerContext) SetBandwidthEstimator(estimator *bwe.BandwidthEstimator) {
+	p.Subscriber.SetBandwidthEstimator(estimator)
 }
 
 func (p *PeerContext) PeerID() string {
 	return p.peerID
 }
 
+func (p *PeerContext) PublishOnly() bool {
+	return p.publishOnly
+}
+
+func (p *PeerContext) ReceiveOnly() bool {
+	return p.receiveOnly
+}
+
 func (p *PeerContext) publishTrack(t *PublishTrackContext) {
 	p.publishTracksMu.Lock()
 	defer p.publishTracksMu.Unlock()
 	p.publishTracks[t.trackContext.ID()] = t
 }
 
+func (p *PeerContext) publishTrackContexts() []*TrackContext {
+	p.publishTracksMu.Lock()
+	defer p.publishTracksMu.Unlock()
+
+	result := make([]*TrackContext, 0, len(p.publishTracks))
+	for _, pub := range p.publishTracks {
+		result = append(result, pub.trackContext)
+	}
+	return result
+}
+
+func (p *PeerContext) getPublishTrack(trackID string) (*PublishTrackContext, bool) {
+	p.publishTracksMu.Lock()
+	defer p.publishTracksMu.Unlock()
+	pub, exist := p.publishTracks[trackID]
+	return pub, exist
+}
+
 func (p *PeerContext) publishTrackDelete(t *PublishTrackContext) {
 	p.publishTracksMu.Lock()
 	defer p.publishTracksMu.Unlock()
@@ -619,6 +787,7 @@ func (p *PeerContext) newSubscriber() {
 		transceiverPool:  p.transceiverPool,
 		busAttachTrack:   make(chan watchTrackAck),
 		busDetachTrack:   make(chan watchTrackAck),
+		subscription:     newSubscription(),
 		ctx:              c,
 		cancel:           cancel,
 	}
@@ -649,6 +818,9 @@ type NewPeerContextParams struct {
 	API              *webrtc.API
 	PipeAllocContext *AllocatorsContext
 	Spreader         trackSpreader
+	// NOTE: Without websocket events of the peer are discarded
+	PublishOnly bool
+	ReceiveOnly bool
 }
 
 func NewPeerContext(params NewPeerContextParams) (*PeerContext, error) {
@@ -660,14 +832,22 @@ func NewPeerContext(params NewPeerContextParams) (*PeerContext, error) {
 		webrtc:           params.API,
 		pipeAllocContext: params.PipeAllocContext,
 		publishTracks:    make(map[string]*PublishTrackContext),
-		offer:            NewSessionDesc(ctx),
 		transceiverPool:  NewTransceiverPool(),
 		spreader:         params.Spreader,
+		reconciler:       newSenderReconciler(),
+		publishOnly:      params.PublishOnly,
+		receiveOnly:      params.ReceiveOnly,
 	}
 	if err := p.newPeerConnection(); err != nil {
 		return nil, err
 	}
 	p.newSubscriber()
-	p.newSignal(params.WS)
+
+	var conn WebsocketWriter = discardWriter{}
+	if params.WS != nil {
+		conn = params.WS
+	}
+	p.newSignal(conn)
+	p.negotiator = NewNegotiator(p.peerConnection, p.Signal)
 	return p, nil
 }
@@ -6,18 +6,78 @@ import (
 	"log"
 	"sync"
 
-	"github.com/pion/webrtc/v4"
+	webrtc "github.com/pion/webrtc/v4"
 	"golang.org/x/sync/errgroup"
 )
 
 type PeerContextPool struct {
 	subscriberMu sync.Mutex
 	pool         map[string]*PeerContext
+
+	speakers *ActiveSpeakerDetector
+
+	listenersMu sync.Mutex
+	listeners   []TrackListener
+}
+
+// Server side consumer of the room tracks, e.g. recorder
+type TrackListener interface {
+	TrackPublished(*TrackContext)
+	TrackUnpublished(*TrackContext)
 }
 
-func (s *PeerContextPool) DispatchOffers() {
-	for _, peerContext := range s.pool {
-		peerContext.Signal.DispatchOffer()
+func (s *PeerContextPool) AddTrackListener(l TrackListener) {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	s.listeners = append(s.listeners, l)
+}
+
+func (s *PeerContextPool) RemoveTrackListener(l TrackListener) {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+
+	for i, listener := range s.listeners {
+		if listener == l {
+			s.listeners = append(s.listeners[:i], s.listeners[i+1:]...)
+			return
+		}
+	}
+}
+
+// Listener which joins the running room gets already published tracks too. Listener goes before the snapshot,
+// so track published in between comes twice and listener ignores the repeated one.
+// Returns the snapshot, track which is not in it may be unpublished before the listener is added
+func (s *PeerContextPool) AddTrackListenerWithSnapshot(l TrackListener) []*TrackContext {
+	s.AddTrackListener(l)
+
+	published := s.PublishedTracks()
+	for _, t := range published {
+		l.TrackPublished(t)
+	}
+	return published
+}
+
+func (s *PeerContextPool) trackListeners() []TrackListener {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	return append([]TrackListener(nil), s.listeners...)
+}
+
+// Published tracks of all peers in the room
+func (s *PeerContextPool) PublishedTracks() []*TrackContext {
+	var result []*TrackContext
+	for _, peer := range s.Get() {
+		result = append(result, peer.publishTrackContexts()...)
+	}
+	return result
+}
+
+// Dispatch event to every peer of the room
+func (s *PeerContextPool) Broadcast(event string, data any) {
+	for _, peer := range s.Get() {
+		if err := peer.Signal.DispatchEvent(event, data); err != nil {
+			log.Printf("[%s] Unable dispatch to %s. Err: %s", event, peer.PeerID(), err)
+		}
 	}
 }
 
@@ -33,6 +93,13 @@ func (s *PeerContextPool) Get() []*PeerContext {
 	return result
 }
 
+func (s *PeerContextPool) Exist(sub *PeerContext) bool {
+	s.subscriberMu.Lock()
+	defer s.subscriberMu.Unlock()
+	_, exist := s.pool[sub.peerID]
+	return exist
+}
+
 func (s *PeerContextPool) Add(sub *PeerContext) error {
 	s.subscriberMu.Lock()
 	defer s.subscriberMu.Unlock()
@@ -42,6 +109,8 @@ func (s *PeerContextPool) Add(sub *PeerContext) error {
 	}
 
 	s.pool[sub.peerID] = sub
+	go s.dispatchPublishedTracks(sub)
+	sub.ReconcileSenders()
 	return nil
 }
 
@@ -58,180 +127,171 @@ func (s *PeerContextPool) Remove(sub *PeerContext) (err error) {
 	}
 
 	delete(s.pool, sub.peerID)
-	return err
-}
-
-func (s *PeerContextPool) ForEachAsync(ctx context.Context, f func(*PeerContext) error) error {
-	g, ctx := errgroup.WithContext(ctx)
-
+	s.speakers.Remove(sub.peerID)
 	for _, peer := range s.pool {
-		peer := peer
-		g.Go(func() error {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			default:
-			}
-			return f(peer)
-		})
-	}
-
-	if err := g.Wait(); err != nil {
-		return err
+		peer.ReconcileSenders()
 	}
-	return nil
+	return err
 }
 
-func (s *PeerContextPool) TrackDownToPeers(peerOrigin *PeerContext, t *TrackContext) error {
-	return s.ForEachAsync(t.ctx, func(peer *PeerContext) error {
-		if peer.PeerID() == peerOrigin.PeerID() {
-			return nil
+// Tell joined peer about tracks which were published before
+func (s *PeerContextPool) dispatchPublishedTracks(peerTarget *PeerContext) {
+	for _, peer := range s.Get() {
+		if peer.PeerID() == peerTarget.PeerID() {
+			continue
 		}
-
-		ack := peer.Subscriber.AttachTrack(t)
-		if err := <-ack.Result; err != nil {
-			return err
+		for _, track := range peer.PublishTracks() {
+			if err := peerTarget.Signal.DispatchEvent("track-published", track.TrackMetadata); err != nil {
+				log.Printf("[TrackPublished] Unable dispatch to %s. Err: %s", peerTarget.PeerID(), err)
+				return
+			}
 		}
-
-		return nil
-	})
+	}
 }
 
-func (s *PeerContextPool) TrackDownStopToPeers(peerOrigin *PeerContext, t *TrackContext) error {
-	return s.ForEachAsync(t.ctx, func(peer *PeerContext) error {
+func (s *PeerContextPool) broadcast(peerOrigin *PeerContext, event string, data any) {
+	for _, peer := range s.Get() {
 		if peer.PeerID() == peerOrigin.PeerID() {
-			return nil
+			continue
 		}
-
-		ack := peer.Subscriber.DetachTrack(t)
-		if err := <-ack.Result; err != nil {
-			return err
+		if err := peer.Signal.DispatchEvent(event, data); err != nil {
+			log.Printf("[%s] Unable dispatch to %s. Err: %s", event, peer.PeerID(), err)
 		}
-
-		return nil
-	})
+	}
 }
 
-type UnattachedSender struct {
-	track *PublishTrackContext
+func (s *PeerContextPool) TrackPublished(peerOrigin *PeerContext, t *TrackContext) {
+	s.broadcast(peerOrigin, "track-published", t.Metadata())
+	s.reconcile(peerOrigin)
+	for _, l := range s.trackListeners() {
+		l.TrackPublished(t)
+	}
 }
 
-type OptionalSender interface {
-	*webrtc.RTPSender | UnattachedSender
+func (s *PeerContextPool) TrackUnpublished(peerOrigin *PeerContext, t *TrackContext) {
+	s.broadcast(peerOrigin, "track-unpublished", TrackUnpublishedMessage{
+		TrackID:       t.ID(),
+		ParticipantID: t.SourcePeerID,
+	})
+	s.reconcile(peerOrigin)
+	for _, l := range s.trackListeners() {
+		l.TrackUnpublished(t)
+	}
 }
 
-type OptionalSenderBox[F any] struct {
-	value F
+// Origin may be not in the pool yet, it's reconciled too
+func (s *PeerContextPool) reconcile(peerOrigin *PeerContext) {
+	peerOrigin.ReconcileSenders()
+	for _, peer := range s.Get() {
+		if peer.PeerID() != peerOrigin.PeerID() {
+			peer.ReconcileSenders()
+		}
+	}
 }
 
-func (b *OptionalSenderBox[F]) Untype() OptionalSenderBox[any] {
-	return OptionalSenderBox[any]{
-		value: b.value,
+func (s *PeerContextPool) DesiredTracks(peerTarget *PeerContext) map[string]*TrackContext {
+	peers := s.Get()
+	if !s.Exist(peerTarget) {
+		peers = append(peers, peerTarget)
 	}
-}
 
-func NewOptionalSenderBox[F *webrtc.RTPSender | UnattachedSender](val F) OptionalSenderBox[F] {
-	return OptionalSenderBox[F]{
-		value: val,
+	desired := make(map[string]*TrackContext)
+	if peerTarget.PublishOnly() {
+		return desired
 	}
-}
 
-func (s *PeerContextPool) PeerPublishingSenders(peerTarget *PeerContext) map[string]OptionalSenderBox[any] {
-	pubSenders := make(map[string]OptionalSenderBox[any])
+	// NOTE: Mix replaces audio of other participants
+	mix := peerTarget.AudioMixTrack()
+	if mix != nil {
+		desired[mix.ID()] = mix
+	}
 
-	for _, peer := range s.pool {
-		for pubTrackID, pubTrack := range peer.publishTracks {
-			if track, exist := peerTarget.Subscriber.HasTrack(pubTrackID); exist {
-				sender := track.LoadSender()
-				if sender != nil {
-					box := NewOptionalSenderBox(sender)
-					pubSenders[track.trackContext.ID()] = box.Untype()
-					continue
-				}
+	for _, peer := range peers {
+		for _, t := range peer.publishTrackContexts() {
+			select {
+			case <-t.Done():
+				continue
+			default:
+			}
+			if mix != nil && t.Kind() == webrtc.RTPCodecTypeAudio && t.SourcePeerID != peerTarget.PeerID() {
+				continue
+			}
+			if peerTarget.Subscriber.Wants(t) {
+				desired[t.ID()] = t
 			}
-
-			box := NewOptionalSenderBox(UnattachedSender{
-				track: pubTrack,
-			})
-			pubSenders[pubTrackID] = box.Untype()
 		}
 	}
-
-	return pubSenders
+	return desired
 }
 
-func (s *PeerContextPool) removeUnpublishSenders(peerTarget *PeerContext, pubSenders map[string]OptionalSenderBox[any]) {
-	// log.Println(
-	// 	"senders:", peerTarget.peerConnection.GetSenders(),
-	// 	"pubSenders:", pubSenders,
-	// )
+func (s *PeerContextPool) TrackUpdated(peerOrigin *PeerContext, t *TrackContext) {
+	s.broadcast(peerOrigin, "track-updated", t.Metadata())
+}
 
-	for _, existingSender := range peerTarget.peerConnection.GetSenders() {
-		if existingSender == nil {
-			continue
+// Direct message goes only to the target, otherwise to everyone except sender
+func (s *PeerContextPool) RelayData(peerOrigin *PeerContext, msg DataMessage) error {
+	if msg.To != "" {
+		s.subscriberMu.Lock()
+		peer, exist := s.pool[msg.To]
+		s.subscriberMu.Unlock()
+		if !exist {
+			return ErrParticipantNotFound
 		}
+		return peer.SendData(msg)
+	}
 
-		track := existingSender.Track()
-		if track == nil {
+	for _, peer := range s.Get() {
+		if peer.PeerID() == peerOrigin.PeerID() {
 			continue
 		}
-		tID := track.ID()
-
-		s, exist := pubSenders[tID]
-		if !exist {
-			_ = peerTarget.peerConnection.RemoveTrack(existingSender)
-			return
+		if err := peer.SendData(msg); err != nil {
+			log.Printf("[RelayData] Unable send to %s. Err: %s", peer.PeerID(), err)
 		}
+	}
+	return nil
+}
 
-		switch sender := s.value.(type) {
-		case *webrtc.RTPSender:
-			if sender != existingSender {
-				_ = peerTarget.peerConnection.RemoveTrack(sender)
-				continue
+func (s *PeerContextPool) ObserveAudioLevel(peerID, trackID string, level uint8) {
+	s.speakers.Observe(peerID, trackID, level)
+}
+
+// Notify all peers of the room when active speakers change
+func (s *PeerContextPool) HandleActiveSpeakers(ctx context.Context) {
+	s.speakers.Run(ctx, func(msg ActiveSpeakersMessage) {
+		for _, peer := range s.Get() {
+			if err := peer.Signal.DispatchEvent("active-speakers", msg); err != nil {
+				log.Printf("[ActiveSpeakers] Unable dispatch to %s. Err: %s", peer.PeerID(), err)
 			}
-		case UnattachedSender:
-			continue
-		default:
-			log.Println("[removeUnpublishSenders] What is it???", sender)
-			panic("[removeUnpublishSenders] Unreachable state")
 		}
-	}
+	})
 }
 
-func (s *PeerContextPool) SanitizePeerSenders(peerTarget *PeerContext) error {
-	senders := s.PeerPublishingSenders(peerTarget)
-
-	s.removeUnpublishSenders(peerTarget, senders)
+func (s *PeerContextPool) ForEachAsync(ctx context.Context, f func(*PeerContext) error) error {
+	g, ctx := errgroup.WithContext(ctx)
 
-	for _, sender := range senders {
-		switch s := sender.value.(type) {
-		case *webrtc.RTPSender:
-			// NOTE: here may be bug if sender not attached but i assume that all ok
-			continue
-		case UnattachedSender:
-			log.Println("Block attached senders")
-			t := s.track.trackContext
-			ack := peerTarget.Subscriber.AttachTrack(t)
-			if err := <-ack.Result; err != nil {
-				return err
+	for _, peer := range s.pool {
+		peer := peer
+		g.Go(func() error {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
 			}
-			log.Println("Unblock attached senders")
-		default:
-			log.Println("[SanitizePeerSenders] What is it???", s)
-			panic("[SanitizePeerSenders] Unreachable state")
-		}
+			return f(peer)
+		})
 	}
 
-	// log.Println("[SanitizePeerSenders] sanitized peer", peerTarget.peerID)
-	// log.Println("[SanitizePeerSenders] sanitized senders", peerTarget.peerConnection.GetSenders())
-
-	return peerTarget.Signal.DispatchOffer()
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	return nil
 }
 
 var _ trackSpreader = (*PeerContextPool)(nil)
 
 func NewPeerContextPool() *PeerContextPool {
 	return &PeerContextPool{
-		pool: make(map[string]*PeerContext),
+		pool:     make(map[string]*PeerContext),
+		speakers: NewActiveSpeakerDetector(),
 	}
 }
@@ -61,6 +61,8 @@ type Allocator = func(t *TrackContext) (Pipeline, error)
 
 type AllocatorsContext struct {
 	allocators map[*Filter]Allocator
+	// NOTE: Not a filter, mixer is not selectable for the publisher track
+	audioMixer AudioMixerAllocator
 }
 
 func (ctx *AllocatorsContext) Register(name *Filter, alloc Allocator) {
@@ -111,6 +113,6 @@ func (ctx *AllocatorsContext) Filters() []*Filter {
 
 func NewAllocatorsContext() *AllocatorsContext {
 	return &AllocatorsContext{
-		make(map[*Filter]Allocator),
+		allocators: make(map[*Filter]Allocator),
 	}
 }
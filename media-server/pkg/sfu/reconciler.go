@@ -0,0 +1,195 @@
+package sfu
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	webrtc "github.com/pion/webrtc/v4"
+)
+
+// Events which come together, e.g. audio and video of the same publisher, are applied in one pass
+const _RECONCILE_BATCH_WINDOW = 50 * time.Millisecond
+
+// Pre-created senders of AddTransceiver, they are not managed by reconciliation
+const _SHADOW_STREAM_ID = "inactive"
+
+// What must be done to get from the current senders of the peer to the desired ones
+type senderPlan struct {
+	Attach []*TrackContext
+	Delete []*ActiveTrackContext
+	// NOTE: Senders which are not owned by any active track
+	Remove []*webrtc.RTPSender
+}
+
+func (p senderPlan) Empty() bool {
+	return len(p.Attach) == 0 && len(p.Delete) == 0 && len(p.Remove) == 0
+}
+
+// Pure diff of desired tracks and current state. Tracks kept by the plan are not touched
+func planSenders(desired map[string]*TrackContext, active map[string]*ActiveTrackContext, senders []*webrtc.RTPSender) senderPlan {
+	var plan senderPlan
+
+	for id, t := range desired {
+		if _, exist := active[id]; !exist {
+			plan.Attach = append(plan.Attach, t)
+		}
+	}
+
+	// NOTE: Senders of deleted tracks are removed with the track
+	owned := make(map[*webrtc.RTPSender]struct{}, len(active))
+	for id, track := range active {
+		if sender := track.LoadSender(); sender != nil {
+			owned[sender] = struct{}{}
+		}
+		if _, exist := desired[id]; !exist {
+			plan.Delete = append(plan.Delete, track)
+		}
+	}
+
+	for _, sender := range senders {
+		if sender == nil {
+			continue
+		}
+		if _, exist := owned[sender]; exist {
+			continue
+		}
+		track := sender.Track()
+		if track == nil || track.StreamID() == _SHADOW_STREAM_ID {
+			continue
+		}
+		plan.Remove = append(plan.Remove, sender)
+	}
+
+	return plan
+}
+
+// Level triggered reconciliation of the peer senders. Requests are coalesced, request which comes
+// during the pass causes one more pass, so the last pass always sees the latest room state
+type senderReconciler struct {
+	trigger chan struct{}
+	window  time.Duration
+	// NOTE: Sender track was replaced in place, the set is the same but client must know about it
+	invalidated atomic.Bool
+
+	negotiatedMu sync.Mutex
+	negotiated   map[string]struct{}
+}
+
+func (r *senderReconciler) Request() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (r *senderReconciler) Invalidate() {
+	r.invalidated.Store(true)
+	r.Request()
+}
+
+// Returns true when attached tracks differ from the last negotiated ones
+func (r *senderReconciler) changed(active map[string]*ActiveTrackContext) bool {
+	r.negotiatedMu.Lock()
+	defer r.negotiatedMu.Unlock()
+
+	changed := r.invalidated.Swap(false) || len(active) != len(r.negotiated)
+	if !changed {
+		for id := range active {
+			if _, exist := r.negotiated[id]; !exist {
+				changed = true
+				break
+			}
+		}
+	}
+
+	if changed {
+		r.negotiated = make(map[string]struct{}, len(active))
+		for id := range active {
+			r.negotiated[id] = struct{}{}
+		}
+	}
+	return changed
+}
+
+// Pass waits for the batch window after the first request. Request during the pass is kept in the trigger
+func (r *senderReconciler) run(done <-chan struct{}, pass func()) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-r.trigger:
+		}
+
+		select {
+		case <-done:
+			return
+		case <-time.After(r.window):
+		}
+
+		pass()
+	}
+}
+
+func newSenderReconciler() *senderReconciler {
+	return &senderReconciler{
+		trigger:    make(chan struct{}, 1),
+		window:     _RECONCILE_BATCH_WINDOW,
+		negotiated: make(map[string]struct{}),
+	}
+}
+
+func (p *PeerContext) ReconcileSenders() {
+	p.reconciler.Request()
+}
+
+func (p *PeerContext) HandleSenderReconcile() {
+	p.reconciler.run(p.Done(), func() {
+		if err := p.reconcileSenders(); err != nil {
+			log.Printf("[Reconcile] %s unable reconcile senders. Err: %s", p.peerID, err)
+		}
+	})
+}
+
+func (p *PeerContext) reconcileSenders() error {
+	// NOTE: Receive only peer has no signal for the server offer
+	if p.receiveOnly {
+		return nil
+	}
+
+	desired := p.spreader.DesiredTracks(p)
+	plan := planSenders(desired, p.Subscriber.ActiveTracks(), p.peerConnection.GetSenders())
+
+	for _, track := range plan.Delete {
+		if err := p.Subscriber.DeleteTrack(track); err != nil {
+			log.Printf("[Reconcile] %s unable delete track %s. Err: %s", p.peerID, track.trackContext.ID(), err)
+		}
+	}
+
+	for _, sender := range plan.Remove {
+		if err := p.peerConnection.RemoveTrack(sender); err != nil {
+			log.Printf("[Reconcile] %s unable remove sender. Err: %s", p.peerID, err)
+		}
+	}
+
+	for _, t := range plan.Attach {
+		ack := p.Subscriber.AttachTrack(t)
+		select {
+		case <-p.Done():
+			return p.Err()
+		case err := <-ack.Result:
+			if err != nil {
+				log.Printf("[Reconcile] %s unable attach track %s. Err: %s", p.peerID, t.ID(), err)
+			}
+		}
+	}
+
+	// NOTE: Stray sender removal changes transceiver direction, so it's negotiated too
+	if !p.reconciler.changed(p.Subscriber.ActiveTracks()) && len(plan.Remove) == 0 {
+		return nil
+	}
+
+	log.Printf("[Reconcile] %s attach: %d delete: %d remove: %d", p.peerID, len(plan.Attach), len(plan.Delete), len(plan.Remove))
+	return p.Negotiate()
+}
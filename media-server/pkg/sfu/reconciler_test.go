@@ -0,0 +1,243 @@
+package sfu
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	webrtc "github.com/pion/webrtc/v4"
+)
+
+func testTracks(ids ...string) map[string]*TrackContext {
+	tracks := make(map[string]*TrackContext, len(ids))
+	for _, id := range ids {
+		tracks[id] = &TrackContext{id: id}
+	}
+	return tracks
+}
+
+func testActiveTracks(senders map[string]*webrtc.RTPSender, ids ...string) map[string]*ActiveTrackContext {
+	active := make(map[string]*ActiveTrackContext, len(ids))
+	for _, id := range ids {
+		active[id] = NewActiveTrackContext(nil, senders[id], &TrackContext{id: id}, nil)
+	}
+	return active
+}
+
+func testSender(t *testing.T, pc *webrtc.PeerConnection, id, streamID string) *webrtc.RTPSender {
+	t.Helper()
+
+	track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, id, streamID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, err := pc.AddTrack(track)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sender
+}
+
+func planTrackIDs[T interface{ ID() string }](tracks []T) []string {
+	ids := make([]string, 0, len(tracks))
+	for _, t := range tracks {
+		ids = append(ids, t.ID())
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestPlanSenders(t *testing.T) {
+	pc := newTestPeerConnection(t)
+	senders := map[string]*webrtc.RTPSender{
+		"a":      testSender(t, pc, "a", "peer-a"),
+		"b":      testSender(t, pc, "b", "peer-b"),
+		"stray":  testSender(t, pc, "stray", "peer-c"),
+		"shadow": testSender(t, pc, "shadow", _SHADOW_STREAM_ID),
+		"empty":  testSender(t, pc, "empty", "peer-d"),
+	}
+	if err := senders["empty"].ReplaceTrack(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		desired []string
+		active  []string
+		senders []string
+		attach  []string
+		delete  []string
+		remove  []string
+	}{
+		{name: "empty"},
+		{name: "add", desired: []string{"a", "b"}, attach: []string{"a", "b"}},
+		{name: "remove", active: []string{"a", "b"}, senders: []string{"a", "b"}, delete: []string{"a", "b"}},
+		{name: "replace", desired: []string{"b"}, active: []string{"a"}, senders: []string{"a"}, attach: []string{"b"}, delete: []string{"a"}},
+		{name: "keep", desired: []string{"a"}, active: []string{"a"}, senders: []string{"a"}},
+		{name: "stray sender", desired: []string{"a"}, active: []string{"a"}, senders: []string{"a", "stray"}, remove: []string{"stray"}},
+		{name: "shadow and empty senders", senders: []string{"shadow", "empty"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := make([]*webrtc.RTPSender, 0, len(tt.senders)+1)
+			for _, id := range tt.senders {
+				current = append(current, senders[id])
+			}
+			// NOTE: Stopped transceiver has no sender
+			current = append(current, nil)
+
+			plan := planSenders(testTracks(tt.desired...), testActiveTracks(senders, tt.active...), current)
+
+			deleted := make([]*TrackContext, 0, len(plan.Delete))
+			for _, track := range plan.Delete {
+				deleted = append(deleted, track.trackContext)
+			}
+			removed := make([]webrtc.TrackLocal, 0, len(plan.Remove))
+			for _, sender := range plan.Remove {
+				removed = append(removed, sender.Track())
+			}
+
+			assertIDs(t, "attach", planTrackIDs(plan.Attach), tt.attach)
+			assertIDs(t, "delete", planTrackIDs(deleted), tt.delete)
+			assertIDs(t, "remove", planTrackIDs(removed), tt.remove)
+
+			empty := len(tt.attach) == 0 && len(tt.delete) == 0 && len(tt.remove) == 0
+			if plan.Empty() != empty {
+				t.Fatalf("plan empty %t, want %t", plan.Empty(), empty)
+			}
+		})
+	}
+}
+
+func assertIDs(t *testing.T, name string, got, want []string) {
+	t.Helper()
+
+	sort.Strings(want)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("%s %v, want %v", name, got, want)
+	}
+}
+
+func TestSenderReconcilerChanged(t *testing.T) {
+	r := newSenderReconciler()
+
+	if r.changed(testActiveTracks(nil)) {
+		t.Fatal("nothing attached, want unchanged")
+	}
+	if !r.changed(testActiveTracks(nil, "a", "b")) {
+		t.Fatal("attached tracks, want changed")
+	}
+	if r.changed(testActiveTracks(nil, "b", "a")) {
+		t.Fatal("same tracks, want unchanged")
+	}
+	if !r.changed(testActiveTracks(nil, "a", "c")) {
+		t.Fatal("replaced track, want changed")
+	}
+
+	r.invalidated.Store(true)
+	if !r.changed(testActiveTracks(nil, "a", "c")) {
+		t.Fatal("invalidated tracks, want changed")
+	}
+	if r.changed(testActiveTracks(nil, "a", "c")) {
+		t.Fatal("invalidation is consumed, want unchanged")
+	}
+}
+
+// Peers publish and leave concurrently, every change requests reconciliation of the subscriber.
+// Batched passes must end with the subscriber senders equal to the room state
+func TestSenderReconcilerConverges(t *testing.T) {
+	r := newSenderReconciler()
+	r.window = time.Millisecond
+
+	var (
+		roomMu sync.Mutex
+		room   = make(map[string]*TrackContext)
+		active = make(map[string]*ActiveTrackContext)
+		passes atomic.Int64
+	)
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		r.run(done, func() {
+			defer passes.Add(1)
+
+			roomMu.Lock()
+			desired := make(map[string]*TrackContext, len(room))
+			for id, track := range room {
+				desired[id] = track
+			}
+			roomMu.Unlock()
+
+			plan := planSenders(desired, active, nil)
+			for _, track := range plan.Delete {
+				delete(active, track.trackContext.ID())
+			}
+			for _, track := range plan.Attach {
+				active[track.ID()] = NewActiveTrackContext(nil, nil, track, nil)
+			}
+		})
+	}()
+
+	const peers = 32
+	var requests atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < peers; i++ {
+		wg.Add(1)
+		go func(peer int) {
+			defer wg.Done()
+
+			ids := []string{fmt.Sprintf("%d-audio", peer), fmt.Sprintf("%d-video", peer)}
+			for _, id := range ids {
+				roomMu.Lock()
+				room[id] = &TrackContext{id: id}
+				roomMu.Unlock()
+				r.Request()
+				requests.Add(1)
+			}
+
+			// NOTE: Every second peer leaves right after publishing
+			if peer%2 == 0 {
+				return
+			}
+			for _, id := range ids {
+				roomMu.Lock()
+				delete(room, id)
+				roomMu.Unlock()
+				r.Request()
+				requests.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// NOTE: Pass which is done may be started before the last change. Pass of the request after wait sees the final state
+	for i := 0; i < 2; i++ {
+		start := passes.Load()
+		r.Request()
+		deadline := time.Now().Add(time.Second)
+		for passes.Load() == start && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if passes.Load() == start {
+			t.Fatal("reconciler don't run the requested pass")
+		}
+	}
+	close(done)
+	<-stopped
+
+	if len(room) != peers {
+		t.Fatalf("room has %d tracks, want %d", len(room), peers)
+	}
+	if plan := planSenders(room, active, nil); !plan.Empty() {
+		t.Fatalf("not converged, attach: %d delete: %d", len(plan.Attach), len(plan.Delete))
+	}
+	if passes.Load() >= requests.Load() {
+		t.Fatalf("%d passes for %d requests, want them batched", passes.Load(), requests.Load())
+	}
+}
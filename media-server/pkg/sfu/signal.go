@@ -2,12 +2,9 @@ package sfu
 
 import (
 	"encoding/json"
-	"errors"
-	"log"
+	"io"
 	"sync"
-	"time"
 
-	"github.com/gorilla/websocket"
 	webrtc "github.com/pion/webrtc/v4"
 )
 
@@ -17,9 +14,22 @@ type websocketMessage struct {
 }
 
 type Signal struct {
-	signalMu sync.Mutex
-	conn     WebsocketWriter
-	agent    ICEAgent
+	connMu sync.RWMutex
+	conn   WebsocketWriter
+	agent  ICEAgent
+}
+
+// Replace websocket of the signal when client resumes the session
+func (s *Signal) SetConn(conn WebsocketWriter) {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	s.conn = conn
+}
+
+func (s *Signal) Conn() WebsocketWriter {
+	s.connMu.RLock()
+	defer s.connMu.RUnlock()
+	return s.conn
 }
 
 func (s *Signal) OnCandidate(data []byte) error {
@@ -38,54 +48,49 @@ func (s *Signal) OnAnswer(data []byte) error {
 	return s.agent.SetAnswer(answer)
 }
 
-func (s *Signal) DispatchOffer() error {
-	s.signalMu.Lock()
-	defer s.signalMu.Unlock()
-
-	sleep := func() { time.Sleep(time.Millisecond * 100) }
-
-	offerWriter := func(offer string) (bool, error) {
-		if err := s.conn.WriteJSON(&websocketMessage{
-			Event: "offer",
-			Data:  offer,
-		}); err != nil {
-			return false, err
-		}
-		return true, nil
+func (s *Signal) OnOffer(data []byte) error {
+	var offer webrtc.SessionDescription
+	if err := json.Unmarshal(data, &offer); err != nil {
+		return err
 	}
+	return s.agent.SetOffer(offer)
+}
+
+// Ask peer for renegotiation. Offer is sent by the negotiator when it's possible
+func (s *Signal) DispatchOffer() error {
+	return s.agent.Negotiate()
+}
+
+func (s *Signal) DispatchRestartICE() error {
+	return s.agent.RestartICE()
+}
+
+type offerResult struct {
+	webrtc.SessionDescription
+
+	HashState string `json:"hash_state"`
+}
+
+func (s *Signal) SendOffer(offer webrtc.SessionDescription, hash string) error {
+	return s.DispatchEvent("offer", offerResult{
+		SessionDescription: offer,
+		HashState:          hash,
+	})
+}
 
-	for attempt := 0; ; attempt++ {
-		// log.Println("[Signal attempt] attempt", attempt)
-		offer, err := s.agent.Offer()
-
-		if attempt >= 25 {
-			go func() {
-				sleep()
-				s.DispatchOffer()
-			}()
-			return ErrSignalRetry
-		}
-
-		switch {
-		case errors.Is(err, ErrPeerConnectionClosed):
-			return err
-		case err != nil:
-			log.Printf("[Signal SDP] %s", err)
-			sleep()
-			continue
-		default:
-		}
-
-		success, err := offerWriter(offer)
-		if errors.Is(err, websocket.ErrCloseSent) {
-			return err
-		}
-
-		if success {
-			break
-		}
+func (s *Signal) SendAnswer(answer webrtc.SessionDescription) error {
+	return s.DispatchEvent("answer", answer)
+}
+
+func (s *Signal) DispatchEvent(event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
 	}
-	return nil
+	return s.Conn().WriteJSON(&websocketMessage{
+		Event: event,
+		Data:  string(payload),
+	})
 }
 
 type WebsocketWriter interface {
@@ -94,8 +99,17 @@ type WebsocketWriter interface {
 	Close() error
 }
 
+// Signal of the peer which has no websocket, e.g. WHIP encoder
+type discardWriter struct{}
+
+func (discardWriter) WriteJSON(val any) error { return nil }
+func (discardWriter) ReadJSON(val any) error  { return io.EOF }
+func (discardWriter) Close() error            { return nil }
+
 type ICEAgent interface {
-	Offer() (offer string, err error)
+	Negotiate() error
+	RestartICE() error
+	SetOffer(desc webrtc.SessionDescription) error
 	SetAnswer(desc webrtc.SessionDescription) error
 	SetCandidate(candidate webrtc.ICECandidateInit) error
 }
@@ -0,0 +1,192 @@
+package sfu
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	webrtc "github.com/pion/webrtc/v4"
+)
+
+// Encoding of one published track. Browser sends each simulcast encoding as separate ssrc with own rid,
+// but for the room it's still one track. Not simulcast track has only one layer with empty rid
+type trackLayer struct {
+	rid  string
+	ssrc webrtc.SSRC
+
+	keyframe *keyframeRequester
+	packets  *packetCache
+
+	statMu      sync.Mutex
+	windowStart time.Time
+	windowBytes int
+	bitrate     int
+	width       int
+	height      int
+}
+
+func (l *trackLayer) RID() string {
+	return l.rid
+}
+
+func (l *trackLayer) SSRC() webrtc.SSRC {
+	return l.ssrc
+}
+
+const _LAYER_BITRATE_WINDOW = time.Second
+
+func (l *trackLayer) observe(size int) {
+	l.statMu.Lock()
+	defer l.statMu.Unlock()
+
+	now := time.Now()
+	if l.windowStart.IsZero() {
+		l.windowStart = now
+	}
+
+	l.windowBytes += size
+
+	if elapsed := now.Sub(l.windowStart); elapsed >= _LAYER_BITRATE_WINDOW {
+		l.bitrate = int(float64(l.windowBytes*8) / elapsed.Seconds())
+		l.windowBytes = 0
+		l.windowStart = now
+	}
+}
+
+// Bitrate in bits per second. Layer which stopped sending has zero bitrate
+func (l *trackLayer) Bitrate() int {
+	l.statMu.Lock()
+	defer l.statMu.Unlock()
+
+	if time.Since(l.windowStart) > _LAYER_BITRATE_WINDOW*2 {
+		return 0
+	}
+	return l.bitrate
+}
+
+func (l *trackLayer) setDimensions(width, height int) {
+	l.statMu.Lock()
+	defer l.statMu.Unlock()
+	l.width = width
+	l.height = height
+}
+
+// Frame size of the last keyframe. Layer which stopped sending has zero size
+func (l *trackLayer) Dimensions() (int, int) {
+	l.statMu.Lock()
+	defer l.statMu.Unlock()
+
+	if time.Since(l.windowStart) > _LAYER_BITRATE_WINDOW*2 {
+		return 0, 0
+	}
+	return l.width, l.height
+}
+
+func (l *trackLayer) cache(pkt *rtp.Packet) {
+	l.packets.Push(pkt)
+}
+
+func (l *trackLayer) RequestKeyframe() {
+	l.keyframe.Request()
+}
+
+func (l *trackLayer) Packet(seq uint16) (*rtp.Packet, bool) {
+	return l.packets.Get(seq)
+}
+
+func newTrackLayer(rid string, ssrc webrtc.SSRC, requestKeyframe func(ssrc webrtc.SSRC)) *trackLayer {
+	layer := &trackLayer{
+		rid:     rid,
+		ssrc:    ssrc,
+		packets: newPacketCache(),
+	}
+	layer.keyframe = newKeyframeRequester(_KEYFRAME_REQUEST_INTERVAL, func() {
+		requestKeyframe(ssrc)
+	})
+	return layer
+}
+
+type layerBitrate struct {
+	RID     string `json:"rid"`
+	Bitrate int    `json:"bitrate"`
+}
+
+func isKeyframe(mimeType string, pkt *rtp.Packet) bool {
+	if len(pkt.Payload) == 0 {
+		return false
+	}
+
+	switch strings.ToLower(mimeType) {
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		var vp8 codecs.VP8Packet
+		if _, err := vp8.Unmarshal(pkt.Payload); err != nil {
+			return false
+		}
+		if vp8.S != 1 || vp8.PID != 0 || len(vp8.Payload) == 0 {
+			return false
+		}
+		// NOTE: P bit of the vp8 payload header. Zero means key frame
+		return vp8.Payload[0]&0x01 == 0
+
+	case strings.ToLower(webrtc.MimeTypeVP9):
+		var vp9 codecs.VP9Packet
+		if _, err := vp9.Unmarshal(pkt.Payload); err != nil {
+			return false
+		}
+		return !vp9.P && vp9.B && vp9.SID == 0
+
+	case strings.ToLower(webrtc.MimeTypeH264):
+		return isH264Keyframe(pkt.Payload)
+
+	default:
+		// NOTE: Unknown codec. Allow switch on any packet
+		return true
+	}
+}
+
+const (
+	h264NaluTypeIDR   = 5
+	h264NaluTypeSPS   = 7
+	h264NaluTypeSTAPA = 24
+	h264NaluTypeFUA   = 28
+)
+
+func isH264Keyframe(payload []byte) bool {
+	naluType := payload[0] & 0x1F
+
+	switch naluType {
+	case h264NaluTypeIDR, h264NaluTypeSPS:
+		return true
+
+	case h264NaluTypeSTAPA:
+		offset := 1
+		for offset+2 < len(payload) {
+			size := int(payload[offset])<<8 | int(payload[offset+1])
+			offset += 2
+			if offset >= len(payload) {
+				return false
+			}
+			switch payload[offset] & 0x1F {
+			case h264NaluTypeIDR, h264NaluTypeSPS:
+				return true
+			}
+			offset += size
+		}
+
+	case h264NaluTypeFUA:
+		if len(payload) < 2 {
+			return false
+		}
+		start := payload[1]&0x80 != 0
+		return start && payload[1]&0x1F == h264NaluTypeIDR
+	}
+
+	return false
+}
+
+type SimulcastLayerMessage struct {
+	TrackID string `json:"trackId"`
+	RID     string `json:"rid"`
+}
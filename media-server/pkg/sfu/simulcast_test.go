@@ -0,0 +1,266 @@
+package sfu
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	webrtc "github.com/pion/webrtc/v4"
+)
+
+type testRTPSink struct {
+	packets []*rtp.Packet
+}
+
+func (s *testRTPSink) WriteRTP(pkt *rtp.Packet) error {
+	s.packets = append(s.packets, pkt)
+	return nil
+}
+
+func (s *testRTPSink) sequenceNumbers() []uint16 {
+	result := make([]uint16, 0, len(s.packets))
+	for _, pkt := range s.packets {
+		result = append(result, pkt.SequenceNumber)
+	}
+	return result
+}
+
+// Track of the publisher without peer connection. Layers have the measured bitrate, so allocation sees them
+func newTestTrack(id string, kind webrtc.RTPCodecType, mimeType string, layers ...layerBitrate) *TrackContext {
+	t := &TrackContext{
+		id:     id,
+		layers: make(map[string]*trackLayer),
+		codecParams: webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeType, ClockRate: 90000},
+		},
+		codecKind: kind,
+		filter:    FILTER_NONE,
+	}
+	for i, layer := range layers {
+		if i == 0 {
+			t.rid = layer.RID
+		}
+		l := newTrackLayer(layer.RID, webrtc.SSRC(i+1), func(webrtc.SSRC) {})
+		l.windowStart = time.Now()
+		l.bitrate = layer.Bitrate
+		t.layers[layer.RID] = l
+	}
+	return t
+}
+
+var (
+	testVP8Keyframe = []byte{0x10, 0x00, 0x9d, 0x01, 0x2a}
+	testVP8Delta    = []byte{0x10, 0x01, 0x00}
+)
+
+func testPacket(seq uint16, timestamp uint32, payload []byte) *rtp.Packet {
+	return &rtp.Packet{
+		Header:  rtp.Header{Version: 2, SequenceNumber: seq, Timestamp: timestamp},
+		Payload: payload,
+	}
+}
+
+func TestIsKeyframe(t *testing.T) {
+	tests := []struct {
+		name     string
+		mimeType string
+		payload  []byte
+		want     bool
+	}{
+		{"empty", webrtc.MimeTypeVP8, nil, false},
+		{"vp8 keyframe", webrtc.MimeTypeVP8, testVP8Keyframe, true},
+		{"vp8 delta", webrtc.MimeTypeVP8, testVP8Delta, false},
+		{"vp8 not first partition", webrtc.MimeTypeVP8, []byte{0x00, 0x00}, false},
+		{"vp9 keyframe", webrtc.MimeTypeVP9, []byte{0x08, 0x00}, true},
+		{"vp9 delta", webrtc.MimeTypeVP9, []byte{0x48, 0x00}, false},
+		{"h264 idr", webrtc.MimeTypeH264, []byte{0x65, 0x88}, true},
+		{"h264 sps", webrtc.MimeTypeH264, []byte{0x67, 0x42}, true},
+		{"h264 slice", webrtc.MimeTypeH264, []byte{0x41, 0x9a}, false},
+		{"h264 stap-a with sps", webrtc.MimeTypeH264, []byte{0x78, 0x00, 0x02, 0x67, 0x42, 0x00, 0x02, 0x68, 0xce}, true},
+		{"h264 stap-a without idr", webrtc.MimeTypeH264, []byte{0x78, 0x00, 0x01, 0x41}, false},
+		{"h264 fu-a idr start", webrtc.MimeTypeH264, []byte{0x7c, 0x85, 0x88}, true},
+		{"h264 fu-a idr middle", webrtc.MimeTypeH264, []byte{0x7c, 0x05, 0x88}, false},
+		{"h264 fu-a truncated", webrtc.MimeTypeH264, []byte{0x7c}, false},
+		{"unknown codec", webrtc.MimeTypeOpus, []byte{0xfc}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isKeyframe(tt.mimeType, testPacket(1, 0, tt.payload)); got != tt.want {
+				t.Fatalf("keyframe %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrackLayerBitrate(t *testing.T) {
+	layer := newTrackLayer("h", 1, func(webrtc.SSRC) {})
+
+	layer.observe(1000)
+	if layer.Bitrate() != 0 {
+		t.Fatalf("bitrate %d before the first window, want 0", layer.Bitrate())
+	}
+
+	layer.windowStart = time.Now().Add(-_LAYER_BITRATE_WINDOW)
+	layer.observe(124000)
+	if bitrate := layer.Bitrate(); bitrate < 900_000 || bitrate > 1_000_000 {
+		t.Fatalf("bitrate %d, want about 1000000", bitrate)
+	}
+
+	// NOTE: Layer stopped sending, e.g. publisher dropped it on the poor uplink
+	layer.windowStart = time.Now().Add(-3 * _LAYER_BITRATE_WINDOW)
+	if layer.Bitrate() != 0 {
+		t.Fatalf("bitrate %d of the stale layer, want 0", layer.Bitrate())
+	}
+}
+
+func TestTrackLayerBitrates(t *testing.T) {
+	track := newTestTrack("video", webrtc.RTPCodecTypeVideo, webrtc.MimeTypeVP8,
+		layerBitrate{RID: "f", Bitrate: 1_500_000},
+		layerBitrate{RID: "q", Bitrate: 150_000},
+		layerBitrate{RID: "h", Bitrate: 500_000},
+		layerBitrate{RID: "stale", Bitrate: 0},
+	)
+
+	if got := fmt.Sprint(track.LayerBitrates()); got != "[{q 150000} {h 500000} {f 1500000}]" {
+		t.Fatalf("layers %s, want ordered by bitrate without the stale one", got)
+	}
+}
+
+func TestSinkDownTrackBestLayer(t *testing.T) {
+	track := newTestTrack("video", webrtc.RTPCodecTypeVideo, webrtc.MimeTypeVP8,
+		layerBitrate{RID: "q", Bitrate: 150_000},
+		layerBitrate{RID: "f", Bitrate: 1_500_000},
+	)
+
+	d := NewSinkDownTrack(track, &testRTPSink{})
+	if d.PreferredLayer() != "f" || d.TargetLayer() != "f" {
+		t.Fatalf("preferred %q target %q, want the best layer", d.PreferredLayer(), d.TargetLayer())
+	}
+}
+
+// Subscriber switches the layer only on the keyframe of the target layer, sequence numbers stay continuous
+func TestDownTrackLayerSwitch(t *testing.T) {
+	track := newTestTrack("video", webrtc.RTPCodecTypeVideo, webrtc.MimeTypeVP8,
+		layerBitrate{RID: "l", Bitrate: 150_000},
+		layerBitrate{RID: "h", Bitrate: 1_500_000},
+	)
+	sink := &testRTPSink{}
+	d := NewSinkDownTrack(track, sink)
+
+	writes := []struct {
+		rid     string
+		seq     uint16
+		payload []byte
+	}{
+		{"h", 10, testVP8Delta},
+		{"l", 500, testVP8Keyframe},
+		{"h", 11, testVP8Keyframe},
+		{"h", 12, testVP8Delta},
+		{"l", 501, testVP8Delta},
+	}
+	for _, w := range writes {
+		if err := d.WriteRTP(w.rid, testPacket(w.seq, uint32(w.seq)*3000, w.payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertSequenceNumbers(t, sink, 11, 12)
+
+	d.SetTargetLayer("l")
+	writes = []struct {
+		rid     string
+		seq     uint16
+		payload []byte
+	}{
+		{"h", 13, testVP8Delta},
+		{"l", 502, testVP8Delta},
+		{"l", 503, testVP8Keyframe},
+		{"h", 14, testVP8Delta},
+		{"l", 504, testVP8Delta},
+	}
+	for _, w := range writes {
+		if err := d.WriteRTP(w.rid, testPacket(w.seq, uint32(w.seq)*3000, w.payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertSequenceNumbers(t, sink, 11, 12, 13, 14, 15)
+
+	if d.CurrentLayer() != "l" {
+		t.Fatalf("current layer %q, want l", d.CurrentLayer())
+	}
+	last := sink.packets[len(sink.packets)-1]
+	if int32(last.Timestamp-sink.packets[2].Timestamp) <= 0 {
+		t.Fatal("timestamp goes back after the layer switch")
+	}
+}
+
+func TestDownTrackResumeFromKeyframe(t *testing.T) {
+	track := newTestTrack("video", webrtc.RTPCodecTypeVideo, webrtc.MimeTypeVP8,
+		layerBitrate{RID: "h", Bitrate: 1_500_000},
+	)
+	sink := &testRTPSink{}
+	d := NewSinkDownTrack(track, sink)
+
+	_ = d.WriteRTP("h", testPacket(1, 3000, testVP8Keyframe))
+	d.SetSubscriberPaused(true)
+	_ = d.WriteRTP("h", testPacket(2, 6000, testVP8Delta))
+	d.SetSubscriberPaused(false)
+	_ = d.WriteRTP("h", testPacket(3, 9000, testVP8Delta))
+	_ = d.WriteRTP("h", testPacket(4, 12000, testVP8Keyframe))
+
+	assertSequenceNumbers(t, sink, 1, 2)
+}
+
+func assertSequenceNumbers(t *testing.T, sink *testRTPSink, want ...uint16) {
+	t.Helper()
+
+	if got := sink.sequenceNumbers(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("written %v, want %v", got, want)
+	}
+}
+
+func testVP8KeyframeSized(width, height int) []byte {
+	return []byte{0x10, 0x00, 0x00, 0x00, 0x9d, 0x01, 0x2a, byte(width), byte(width >> 8), byte(height), byte(height >> 8)}
+}
+
+// Track is described by the largest layer which is sending, not by the largest one ever seen
+func TestTrackDimensions(t *testing.T) {
+	track := newTestTrack("video", webrtc.RTPCodecTypeVideo, webrtc.MimeTypeVP8, testSimulcastLayers()...)
+	observe := func(rid string, width, height int) {
+		layer, _ := track.Layer(rid)
+		track.observeDimensions(layer, testPacket(1, 3000, testVP8KeyframeSized(width, height)))
+	}
+	stop := func(rid string) {
+		layer, _ := track.Layer(rid)
+		layer.windowStart = time.Now().Add(-3 * _LAYER_BITRATE_WINDOW)
+	}
+	assertDimensions := func(width, height int) {
+		t.Helper()
+		if m := track.Metadata(); m.Width != width || m.Height != height {
+			t.Fatalf("dimensions %dx%d, want %dx%d", m.Width, m.Height, width, height)
+		}
+	}
+
+	observe("q", 320, 180)
+	observe("f", 1280, 720)
+	observe("h", 640, 360)
+	assertDimensions(1280, 720)
+
+	stop("f")
+	if !track.updateDimensions() {
+		t.Fatal("stopped layer is not reported")
+	}
+	assertDimensions(640, 360)
+
+	// NOTE: Encoder scales the layer down on the poor uplink
+	observe("h", 480, 270)
+	assertDimensions(480, 270)
+
+	stop("q")
+	stop("h")
+	if track.updateDimensions() {
+		t.Fatal("stopped track lost the size")
+	}
+	assertDimensions(480, 270)
+}
@@ -0,0 +1,194 @@
+package sfu
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	webrtc "github.com/pion/webrtc/v4"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/bwe"
+)
+
+const _BANDWIDTH_ALLOCATION_INTERVAL = time.Second
+
+type trackAllocation struct {
+	TrackID string `json:"trackId"`
+	RID     string `json:"rid"`
+	Bitrate int    `json:"bitrate"`
+	Paused  bool   `json:"paused"`
+}
+
+type bandwidthAllocation struct {
+	EstimatedBitrate int               `json:"estimatedBitrate"`
+	Tracks           []trackAllocation `json:"tracks"`
+}
+
+func (s *Subscriber) SetBandwidthEstimator(estimator *bwe.BandwidthEstimator) {
+	s.estimator.Store(estimator)
+}
+
+// Bitrate which subscriber downlink can sustain. Zero when estimator not attached
+func (s *Subscriber) EstimatedBitrate() int {
+	estimator := s.estimator.Load()
+	if estimator == nil {
+		return 0
+	}
+	return estimator.GetTargetBitrate()
+}
+
+func (s *Subscriber) HandleBandwidthAllocation() {
+	ticker := time.NewTicker(_BANDWIDTH_ALLOCATION_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			if allocation, changed := s.allocateBandwidth(); changed {
+				s.dispatch(NewSubscriberMessage(SubscriberBandwidthAllocated{
+					allocation: allocation,
+				}))
+			}
+		}
+	}
+}
+
+type layerPlan struct {
+	track  *ActiveTrackContext
+	layers []layerBitrate
+	index  int
+}
+
+// Layers which subscriber allowed to receive. Preferred layer is the upper bound
+func allowedLayers(track *ActiveTrackContext) []layerBitrate {
+	layers := track.trackContext.LayerBitrates()
+
+	preferred := track.downTrack.PreferredLayer()
+	if preferred == "" {
+		return layers
+	}
+
+	for i, layer := range layers {
+		if layer.RID == preferred {
+			return layers[:i+1]
+		}
+	}
+	return layers
+}
+
+// Audio is always forwarded first. Video tracks get the lowest layer if it fits, then upgraded one step
+// at a time while budget allows. Video which doesn't fit even with the lowest layer is paused
+func (s *Subscriber) allocateBandwidth() (*bandwidthAllocation, bool) {
+	estimate := s.EstimatedBitrate()
+	if estimate <= 0 {
+		return nil, false
+	}
+
+	var audio, video []*ActiveTrackContext
+	s.MapForEachTrack(func(_, value any) bool {
+		track := value.(*ActiveTrackContext)
+		if track.downTrack == nil {
+			return true
+		}
+		// NOTE: Paused by subscriber track don't take any bandwidth
+		if track.downTrack.SubscriberPaused() {
+			track.storeAllocatedBitrate(0)
+			return true
+		}
+
+		switch track.trackContext.codecKind {
+		case webrtc.RTPCodecTypeAudio:
+			audio = append(audio, track)
+		case webrtc.RTPCodecTypeVideo:
+			video = append(video, track)
+		}
+		return true
+	})
+
+	budget := estimate
+	allocation := &bandwidthAllocation{EstimatedBitrate: estimate}
+
+	for _, track := range audio {
+		var bitrate int
+		for _, layer := range track.trackContext.LayerBitrates() {
+			bitrate += layer.Bitrate
+		}
+		budget -= bitrate
+		track.storeAllocatedBitrate(bitrate)
+	}
+
+	sort.Slice(video, func(i, j int) bool {
+		return video[i].trackContext.ID() < video[j].trackContext.ID()
+	})
+
+	plans := make([]*layerPlan, 0, len(video))
+	for _, track := range video {
+		plan := &layerPlan{track: track, layers: allowedLayers(track), index: -1}
+		plans = append(plans, plan)
+
+		if len(plan.layers) == 0 {
+			continue
+		}
+		if lowest := plan.layers[0].Bitrate; lowest <= budget {
+			budget -= lowest
+			plan.index = 0
+		}
+	}
+
+	for upgraded := true; upgraded; {
+		upgraded = false
+		for _, plan := range plans {
+			if plan.index < 0 || plan.index+1 >= len(plan.layers) {
+				continue
+			}
+			delta := plan.layers[plan.index+1].Bitrate - plan.layers[plan.index].Bitrate
+			if delta <= budget {
+				budget -= delta
+				plan.index++
+				upgraded = true
+			}
+		}
+	}
+
+	var changed bool
+	for _, plan := range plans {
+		downTrack := plan.track.downTrack
+
+		// NOTE: Track without measured layers just started, keep it as is
+		if len(plan.layers) == 0 {
+			continue
+		}
+
+		if plan.index < 0 {
+			if !downTrack.BandwidthPaused() {
+				log.Printf("[Subscriber %s] pause track %s. Estimated bitrate: %d", s.peerId, plan.track.trackContext.ID(), estimate)
+				changed = true
+			}
+			downTrack.SetBandwidthPaused(true)
+			plan.track.storeAllocatedBitrate(0)
+
+			allocation.Tracks = append(allocation.Tracks, trackAllocation{
+				TrackID: plan.track.trackContext.ID(),
+				Paused:  true,
+			})
+			continue
+		}
+
+		layer := plan.layers[plan.index]
+		if downTrack.BandwidthPaused() || downTrack.TargetLayer() != layer.RID {
+			changed = true
+		}
+		downTrack.SetTargetLayer(layer.RID)
+		downTrack.SetBandwidthPaused(false)
+		plan.track.storeAllocatedBitrate(layer.Bitrate)
+
+		allocation.Tracks = append(allocation.Tracks, trackAllocation{
+			TrackID: plan.track.trackContext.ID(),
+			RID:     layer.RID,
+			Bitrate: layer.Bitrate,
+		})
+	}
+
+	return allocation, changed
+}
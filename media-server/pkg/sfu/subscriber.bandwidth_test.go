@@ -0,0 +1,158 @@
+package sfu
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pion/interceptor/pkg/cc"
+	webrtc "github.com/pion/webrtc/v4"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/bwe"
+)
+
+type testEstimator struct {
+	cc.BandwidthEstimator
+	bitrate int
+}
+
+func (e *testEstimator) GetTargetBitrate() int {
+	return e.bitrate
+}
+
+func newTestSubscriber(estimate int) *Subscriber {
+	s := &Subscriber{peerId: "subscriber"}
+	s.SetBandwidthEstimator(bwe.NewBandwidthEstimator(&testEstimator{bitrate: estimate}))
+	return s
+}
+
+func attachTestTrack(t *testing.T, s *Subscriber, track *TrackContext) *ActiveTrackContext {
+	t.Helper()
+
+	downTrack, err := NewDownTrack(track)
+	if err != nil {
+		t.Fatal(err)
+	}
+	active := NewActiveTrackContext(nil, nil, track, downTrack)
+	s.tracks.Store(track.ID(), active)
+	return active
+}
+
+func testSimulcastLayers() []layerBitrate {
+	return []layerBitrate{
+		{RID: "q", Bitrate: 100_000},
+		{RID: "h", Bitrate: 300_000},
+		{RID: "f", Bitrate: 1_000_000},
+	}
+}
+
+func allocationString(allocation *bandwidthAllocation) string {
+	result := make([]string, 0, len(allocation.Tracks))
+	for _, track := range allocation.Tracks {
+		if track.Paused {
+			result = append(result, track.TrackID+":paused")
+			continue
+		}
+		result = append(result, track.TrackID+":"+track.RID)
+	}
+	return fmt.Sprint(result)
+}
+
+func TestAllocateBandwidth(t *testing.T) {
+	tests := []struct {
+		name      string
+		estimate  int
+		preferred map[string]string
+		paused    []string
+		want      string
+	}{
+		{name: "everything fits", estimate: 5_000_000, want: "[a:f b:f]"},
+		{name: "lowest layers then upgrade one step at a time", estimate: 864_000, want: "[a:h b:h]"},
+		{name: "upgrade goes in order of tracks", estimate: 564_000, want: "[a:h b:q]"},
+		{name: "second video paused", estimate: 200_000, want: "[a:q b:paused]"},
+		{name: "audio goes first", estimate: 100_000, want: "[a:paused b:paused]"},
+		{name: "preferred layer is upper bound", estimate: 5_000_000, preferred: map[string]string{"a": "q"}, want: "[a:q b:f]"},
+		{name: "paused by subscriber takes nothing", estimate: 1_064_000, paused: []string{"a"}, want: "[b:f]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSubscriber(tt.estimate)
+			audio := attachTestTrack(t, s, newTestTrack("audio", webrtc.RTPCodecTypeAudio, webrtc.MimeTypeOpus,
+				layerBitrate{Bitrate: 64_000},
+			))
+			video := map[string]*ActiveTrackContext{}
+			for _, id := range []string{"b", "a"} {
+				video[id] = attachTestTrack(t, s, newTestTrack(id, webrtc.RTPCodecTypeVideo, webrtc.MimeTypeVP8, testSimulcastLayers()...))
+			}
+			for id, rid := range tt.preferred {
+				if err := video[id].SetSimulcastLayer(rid); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, id := range tt.paused {
+				if err := video[id].Pause(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			allocation, changed := s.allocateBandwidth()
+			if allocation == nil || !changed {
+				t.Fatalf("allocation %v changed %t, want new allocation", allocation, changed)
+			}
+			if got := allocationString(allocation); got != tt.want {
+				t.Fatalf("allocation %s, want %s", got, tt.want)
+			}
+			if audio.AllocatedBitrate() != 64_000 {
+				t.Fatalf("audio allocated %d, want 64000", audio.AllocatedBitrate())
+			}
+
+			for id, track := range video {
+				for _, allocated := range allocation.Tracks {
+					if allocated.TrackID != id {
+						continue
+					}
+					if track.downTrack.BandwidthPaused() != allocated.Paused {
+						t.Fatalf("track %s bandwidth paused %t, want %t", id, track.downTrack.BandwidthPaused(), allocated.Paused)
+					}
+					if !allocated.Paused && track.downTrack.TargetLayer() != allocated.RID {
+						t.Fatalf("track %s target %q, want %q", id, track.downTrack.TargetLayer(), allocated.RID)
+					}
+					if track.AllocatedBitrate() != allocated.Bitrate {
+						t.Fatalf("track %s allocated %d, want %d", id, track.AllocatedBitrate(), allocated.Bitrate)
+					}
+				}
+			}
+
+			if _, changed = s.allocateBandwidth(); changed {
+				t.Fatal("same estimate, want allocation unchanged")
+			}
+		})
+	}
+}
+
+func TestAllocateBandwidthWithoutEstimate(t *testing.T) {
+	s := &Subscriber{peerId: "subscriber"}
+	attachTestTrack(t, s, newTestTrack("a", webrtc.RTPCodecTypeVideo, webrtc.MimeTypeVP8, testSimulcastLayers()...))
+
+	if allocation, changed := s.allocateBandwidth(); allocation != nil || changed {
+		t.Fatalf("allocation %v changed %t without estimator", allocation, changed)
+	}
+
+	s.SetBandwidthEstimator(bwe.NewBandwidthEstimator(&testEstimator{}))
+	if allocation, changed := s.allocateBandwidth(); allocation != nil || changed {
+		t.Fatalf("allocation %v changed %t on zero estimate", allocation, changed)
+	}
+}
+
+// Track which just started has no measured layers, it's forwarded as is
+func TestAllocateBandwidthUnmeasuredTrack(t *testing.T) {
+	s := newTestSubscriber(100_000)
+	track := attachTestTrack(t, s, newTestTrack("a", webrtc.RTPCodecTypeVideo, webrtc.MimeTypeVP8, layerBitrate{RID: "q"}))
+
+	allocation, changed := s.allocateBandwidth()
+	if changed || len(allocation.Tracks) != 0 {
+		t.Fatalf("allocation %s changed %t, want track untouched", allocationString(allocation), changed)
+	}
+	if track.downTrack.BandwidthPaused() {
+		t.Fatal("unmeasured track is paused")
+	}
+}
@@ -16,8 +16,16 @@ func (s *SubscriberTrackDetached) ActiveTrack() *ActiveTrackContext {
 	return s.track
 }
 
+type SubscriberBandwidthAllocated struct {
+	allocation *bandwidthAllocation
+}
+
+func (s *SubscriberBandwidthAllocated) Allocation() *bandwidthAllocation {
+	return s.allocation
+}
+
 type SubscriberEvent interface {
-	SubscriberTrackAttached | SubscriberTrackDetached
+	SubscriberTrackAttached | SubscriberTrackDetached | SubscriberBandwidthAllocated
 }
 
 func (p *Subscriber) Observer() <-chan SubscriberMessage[any] {
@@ -10,6 +10,8 @@ import (
 
 	"github.com/google/uuid"
 	webrtc "github.com/pion/webrtc/v4"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/bwe"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/rtpstats"
 )
 
 type watchTrackAck struct {
@@ -45,6 +47,11 @@ type Subscriber struct {
 	pipeAllocContext *AllocatorsContext
 	transceiverPool  *TransceiverPool
 
+	estimator atomic.Pointer[bwe.BandwidthEstimator]
+	stats     atomic.Pointer[rtpstats.RtpStats]
+
+	subscription *subscription
+
 	ctx    context.Context
 	cancel context.CancelCauseFunc
 }
@@ -106,32 +113,37 @@ func (s *Subscriber) HandleTrackAttach() {
 
 				var sender *webrtc.RTPSender
 				var trackSender webrtc.TrackLocal
-
-				switch t.codecKind {
-				case webrtc.RTPCodecTypeAudio:
-					if s.peerId == t.SourcePeerID {
-						log.Printf("[Track %s] found loopback audio send stub for %s",
-							t.ID(),
-							t.SourcePeerID,
-						)
-						if trackSender, err = webrtc.NewTrackLocalStaticSample(
-							t.codecParams.RTPCodecCapability,
-							t.ID(),
-							t.streamID,
-						); err != nil {
-							break
-						}
-						sender, err = s.webrtc.NewRTPSender(trackSender, s.peerConnection.SCTP().Transport())
+				var downTrack *DownTrack
+
+				switch {
+				case t.codecKind == webrtc.RTPCodecTypeAudio && s.peerId == t.SourcePeerID:
+					log.Printf("[Track %s] found loopback audio send stub for %s",
+						t.ID(),
+						t.SourcePeerID,
+					)
+					if trackSender, err = webrtc.NewTrackLocalStaticSample(
+						t.codecParams.RTPCodecCapability,
+						t.ID(),
+						t.streamID,
+					); err != nil {
 						break
 					}
-
-					fallthrough
+					sender, err = s.webrtc.NewRTPSender(trackSender, s.peerConnection.SCTP().Transport())
 				default:
-					trackSender = t.GetLocalTrack()
+					if downTrack, err = t.NewDownTrack(); err != nil {
+						break
+					}
+					trackSender = downTrack.GetLocalTrack()
+					if !t.UsesDownTracks() {
+						trackSender = t.GetLocalTrack()
+					}
 					sender, err = s.webrtc.NewRTPSender(trackSender, s.peerConnection.SCTP().Transport())
 				}
 
 				if err != nil {
+					if downTrack != nil {
+						t.RemoveDownTrack(downTrack)
+					}
 					ack.Result <- err
 					close(ack.Result)
 					s.handleAttachTrackMu.Unlock()
@@ -139,14 +151,24 @@ func (s *Subscriber) HandleTrackAttach() {
 				}
 
 				if err = transiv.SetSender(sender, trackSender); err != nil {
+					if downTrack != nil {
+						t.RemoveDownTrack(downTrack)
+					}
 					ack.Result <- err
 					close(ack.Result)
 					s.handleAttachTrackMu.Unlock()
+					continue
 				}
 
-				track = NewActiveTrackContext(transiv, transiv.Sender(), t)
+				track = NewActiveTrackContext(transiv, transiv.Sender(), t, downTrack)
 				s.MapStoreTrack(t.ID(), track)
 
+				go track.ReadSenderRTCP(s.ctx, s.stats.Load())
+				if t.codecKind == webrtc.RTPCodecTypeVideo {
+					// NOTE: New subscriber must not wait for the next periodic keyframe
+					track.RequestKeyframe()
+				}
+
 				ack.Result <- nil
 				close(ack.Result)
 				s.dispatch(NewSubscriberMessage(SubscriberTrackAttached{
@@ -180,18 +202,21 @@ func (s *Subscriber) HandleTrackDetach() {
 				// log.Printf("detach track | not found %s track. Ignoring...", t.ID())
 				ack.Result <- errors.Join(ErrWatchTrackDetachNotFound, fmt.Errorf("PeerID: %s TrackID:%s", s.peerId, t.ID()))
 				close(ack.Result)
-				return
+				s.handleDetachTrackMu.Unlock()
+				continue
 			}
 
 			t = track.trackContext
 			s.MapDeleteTrack(t.ID())
+			track.release()
 
 			sender := track.LoadSender()
 			if sender == nil {
 				// NOTE: If this called, something goes too wrong
 				ack.Result <- errors.Join(ErrWatchTrackDetachSenderNotFound, fmt.Errorf("Track not attached. PeerID: %s TrackID:%s", s.peerId, t.ID()))
 				close(ack.Result)
-				return
+				s.handleDetachTrackMu.Unlock()
+				continue
 			}
 
 			err := s.peerConnection.RemoveTrack(sender)
@@ -257,6 +282,29 @@ func (s *Subscriber) DetachTrack(t *TrackContext) watchTrackAck {
 	return ack
 }
 
+func (s *Subscriber) SetStats(stats *rtpstats.RtpStats) {
+	s.stats.Store(stats)
+}
+
+func (s *Subscriber) SetSimulcastLayer(trackID, rid string) error {
+	track, exist := s.HasTrack(trackID)
+	if !exist {
+		return ErrTrackNotFound
+	}
+	return track.SetSimulcastLayer(rid)
+}
+
+func (s *Subscriber) SetTrackPaused(trackID string, paused bool) error {
+	track, exist := s.HasTrack(trackID)
+	if !exist {
+		return ErrTrackNotFound
+	}
+	if paused {
+		return track.Pause()
+	}
+	return track.Resume()
+}
+
 var EmptyActiveTrackContext = &ActiveTrackContext{}
 
 func (s *Subscriber) HasTrack(trackID string) (*ActiveTrackContext, bool) {
@@ -270,6 +318,19 @@ func (s *Subscriber) HasTrack(trackID string) (*ActiveTrackContext, bool) {
 	return track.(*ActiveTrackContext), exist
 }
 
+// Snapshot of attached tracks by track id
+func (s *Subscriber) ActiveTracks() map[string]*ActiveTrackContext {
+	s.tracksMu.Lock()
+	defer s.tracksMu.Unlock()
+
+	result := make(map[string]*ActiveTrackContext)
+	s.MapForEachTrack(func(key, value any) bool {
+		result[key.(string)] = value.(*ActiveTrackContext)
+		return true
+	})
+	return result
+}
+
 func (s *Subscriber) DeleteTrack(t *ActiveTrackContext) error {
 	s.tracksMu.Lock()
 	defer s.tracksMu.Unlock()
@@ -280,6 +341,7 @@ func (s *Subscriber) DeleteTrack(t *ActiveTrackContext) error {
 		return fmt.Errorf("Track not exist. Unable delete %s", id)
 	}
 	s.MapDeleteTrack(id)
+	t.release()
 
 	err := s.transceiverPool.Release(t.LoadTransiver())
 	if err != nil {
@@ -298,6 +360,7 @@ func (s *Subscriber) Close() (err error) {
 		active := value.(*ActiveTrackContext)
 
 		_ = s.peerConnection.RemoveTrack(active.LoadSender())
+		active.release()
 		_ = active.trackContext.Close()
 
 		s.MapDeleteTrack(id)
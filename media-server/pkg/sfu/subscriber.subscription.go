@@ -0,0 +1,134 @@
+package sfu
+
+import "sync"
+
+type SubscriptionMessage struct {
+	TrackIDs []string `json:"trackIds"`
+	PeerIDs  []string `json:"peerIds"`
+}
+
+// NOTE: Ids come from the client and may be not published yet, so they are not checked against the room
+const _SUBSCRIPTION_MAX_IDS = 512
+
+type AutoSubscribeMessage struct {
+	Enabled bool `json:"enabled"`
+}
+
+// Tracks of other peers which subscriber wants to receive. In auto mode it's everything except unsubscribed,
+// otherwise only subscribed tracks and tracks of subscribed peers
+type subscription struct {
+	mu   sync.Mutex
+	auto bool
+
+	tracks map[string]bool
+	peers  map[string]bool
+}
+
+type SubscriptionState struct {
+	Auto     bool     `json:"auto"`
+	TrackIDs []string `json:"trackIds"`
+	PeerIDs  []string `json:"peerIds"`
+}
+
+func (s *subscription) Wants(t *TrackContext) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if wanted, exist := s.tracks[t.ID()]; exist {
+		return wanted
+	}
+	if wanted, exist := s.peers[t.SourcePeerID]; exist {
+		return wanted
+	}
+	return s.auto
+}
+
+// Message which adds ids over the limit is rejected as a whole
+func (s *subscription) set(msg SubscriptionMessage, wanted bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if countNew(s.tracks, msg.TrackIDs) > _SUBSCRIPTION_MAX_IDS-len(s.tracks) ||
+		countNew(s.peers, msg.PeerIDs) > _SUBSCRIPTION_MAX_IDS-len(s.peers) {
+		return ErrSubscriptionTooLarge
+	}
+
+	for _, id := range msg.TrackIDs {
+		s.tracks[id] = wanted
+	}
+	for _, id := range msg.PeerIDs {
+		s.peers[id] = wanted
+	}
+	return nil
+}
+
+func countNew(m map[string]bool, ids []string) int {
+	seen := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		if _, exist := m[id]; !exist {
+			seen[id] = struct{}{}
+		}
+	}
+	return len(seen)
+}
+
+func (s *subscription) setAuto(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auto = enabled
+}
+
+func (s *subscription) State() SubscriptionState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := SubscriptionState{
+		Auto:     s.auto,
+		TrackIDs: make([]string, 0),
+		PeerIDs:  make([]string, 0),
+	}
+	for id, wanted := range s.tracks {
+		if wanted {
+			state.TrackIDs = append(state.TrackIDs, id)
+		}
+	}
+	for id, wanted := range s.peers {
+		if wanted {
+			state.PeerIDs = append(state.PeerIDs, id)
+		}
+	}
+	return state
+}
+
+func newSubscription() *subscription {
+	return &subscription{
+		auto:   true,
+		tracks: make(map[string]bool),
+		peers:  make(map[string]bool),
+	}
+}
+
+func (s *Subscriber) Subscribe(msg SubscriptionMessage) error {
+	return s.subscription.set(msg, true)
+}
+
+func (s *Subscriber) Unsubscribe(msg SubscriptionMessage) error {
+	return s.subscription.set(msg, false)
+}
+
+// Auto subscribe is enabled by default. Disable it to receive only explicitly subscribed tracks
+func (s *Subscriber) SetAutoSubscribe(enabled bool) {
+	s.subscription.setAuto(enabled)
+}
+
+func (s *Subscriber) Subscription() SubscriptionState {
+	return s.subscription.State()
+}
+
+// Own tracks are always attached, subscription is only about other peers
+func (s *Subscriber) Wants(t *TrackContext) bool {
+	if t.SourcePeerID == s.peerId {
+		return true
+	}
+	return s.subscription.Wants(t)
+}
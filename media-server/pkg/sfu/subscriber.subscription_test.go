@@ -0,0 +1,63 @@
+package sfu
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	webrtc "github.com/pion/webrtc/v4"
+)
+
+func testIDs(prefix string, count int) []string {
+	result := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		result = append(result, fmt.Sprintf("%s%d", prefix, i))
+	}
+	return result
+}
+
+func TestSubscriptionWants(t *testing.T) {
+	s := newSubscription()
+	track := newTestTrack("a", webrtc.RTPCodecTypeVideo, webrtc.MimeTypeVP8)
+	track.SourcePeerID = "peer"
+
+	if !s.Wants(track) {
+		t.Fatal("auto subscription don't want the track")
+	}
+
+	s.setAuto(false)
+	_ = s.set(SubscriptionMessage{PeerIDs: []string{"peer"}}, true)
+	_ = s.set(SubscriptionMessage{TrackIDs: []string{"a"}}, false)
+	if s.Wants(track) {
+		t.Fatal("unsubscribed track is wanted by the peer subscription")
+	}
+}
+
+// Ids are client input, subscription can't grow without bound
+func TestSubscriptionMaxIDs(t *testing.T) {
+	s := newSubscription()
+
+	if err := s.set(SubscriptionMessage{TrackIDs: testIDs("t", _SUBSCRIPTION_MAX_IDS)}, true); err != nil {
+		t.Fatal(err)
+	}
+	// NOTE: Known ids only change the state
+	if err := s.set(SubscriptionMessage{TrackIDs: testIDs("t", 10)}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []SubscriptionMessage{
+		{TrackIDs: []string{"new"}},
+		{TrackIDs: []string{"t0", "new"}},
+		{PeerIDs: testIDs("p", _SUBSCRIPTION_MAX_IDS+1)},
+	}
+	for _, msg := range tests {
+		if err := s.set(msg, true); !errors.Is(err, ErrSubscriptionTooLarge) {
+			t.Fatalf("subscription %v err %v, want %v", msg, err, ErrSubscriptionTooLarge)
+		}
+	}
+
+	state := s.State()
+	if len(state.TrackIDs) != _SUBSCRIPTION_MAX_IDS-10 || len(state.PeerIDs) != 0 {
+		t.Fatalf("subscribed %d tracks %d peers, rejected message is applied", len(state.TrackIDs), len(state.PeerIDs))
+	}
+}
@@ -3,14 +3,18 @@ package sfu
 import (
 	"context"
 	"errors"
+	"io"
 	"log"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 	webrtc "github.com/pion/webrtc/v4"
 	"github.com/pion/webrtc/v4/pkg/media"
+	"github.com/romashorodok/conferencing-platform/media-server/pkg/rtpstats"
 )
 
 type TrackWriterRTP interface {
@@ -138,6 +142,14 @@ type TrackContext struct {
 	ssrc        webrtc.SSRC
 	payloadType webrtc.PayloadType
 
+	layers       map[string]*trackLayer
+	layersMu     sync.Mutex
+	downTracks   []*DownTrack
+	downTracksMu sync.RWMutex
+	// NOTE: Sinks get the source media even when the track is filtered
+	sinks   []*DownTrack
+	sinksMu sync.RWMutex
+
 	media   TrackWriter
 	mediaMu sync.Mutex
 
@@ -157,6 +169,12 @@ type TrackContext struct {
 	observers   []chan TrackContextMessage[any]
 	observersMu sync.Mutex
 
+	metadata   TrackMetadata
+	metadataMu sync.Mutex
+
+	muted      atomic.Bool
+	lastPacket atomic.Int64
+
 	// pipes     []Pipeline
 	// sampleBus chan *media.Sample
 
@@ -235,6 +253,202 @@ func (t *TrackContext) GetTrackRemoteWriterSample() (TrackRemoteWriterSample, er
 // 	t.pipes = pipes
 // }
 
+func (t *TrackContext) IsSimulcast() bool {
+	return t.rid != ""
+}
+
+func (t *TrackContext) AddLayer(rid string, ssrc webrtc.SSRC) {
+	t.layersMu.Lock()
+	defer t.layersMu.Unlock()
+	t.layers[rid] = newTrackLayer(rid, ssrc, t.writeKeyframeRequest)
+}
+
+// Remove layer and close the track if it was the last one
+func (t *TrackContext) RemoveLayer(rid string) {
+	t.layersMu.Lock()
+	delete(t.layers, rid)
+	empty := len(t.layers) == 0
+	t.layersMu.Unlock()
+
+	if empty {
+		_ = t.Close()
+	}
+}
+
+func (t *TrackContext) HasLayer(rid string) bool {
+	t.layersMu.Lock()
+	defer t.layersMu.Unlock()
+	_, exist := t.layers[rid]
+	return exist
+}
+
+func (t *TrackContext) Layer(rid string) (*trackLayer, bool) {
+	t.layersMu.Lock()
+	defer t.layersMu.Unlock()
+	layer, exist := t.layers[rid]
+	return layer, exist
+}
+
+// Active layers ordered from the lowest bitrate to the highest
+func (t *TrackContext) LayerBitrates() []layerBitrate {
+	t.layersMu.Lock()
+	result := make([]layerBitrate, 0, len(t.layers))
+	for rid, layer := range t.layers {
+		if bitrate := layer.Bitrate(); bitrate > 0 {
+			result = append(result, layerBitrate{RID: rid, Bitrate: bitrate})
+		}
+	}
+	t.layersMu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Bitrate < result[j].Bitrate
+	})
+	return result
+}
+
+// Ask publisher for a keyframe of the layer. Requests of all subscribers are coalesced per layer
+func (t *TrackContext) RequestKeyframe(rid string) {
+	layer, exist := t.Layer(rid)
+	if !exist {
+		return
+	}
+	layer.RequestKeyframe()
+}
+
+func (t *TrackContext) RequestKeyframes() {
+	t.layersMu.Lock()
+	layers := make([]*trackLayer, 0, len(t.layers))
+	for _, layer := range t.layers {
+		layers = append(layers, layer)
+	}
+	t.layersMu.Unlock()
+
+	for _, layer := range layers {
+		layer.RequestKeyframe()
+	}
+}
+
+func (t *TrackContext) writeKeyframeRequest(ssrc webrtc.SSRC) {
+	if t.peerConnection == nil || t.codecKind != webrtc.RTPCodecTypeVideo {
+		return
+	}
+
+	select {
+	case <-t.Done():
+		return
+	default:
+	}
+
+	err := t.peerConnection.WriteRTCP([]rtcp.Packet{
+		&rtcp.PictureLossIndication{MediaSSRC: uint32(ssrc)},
+	})
+	if err != nil {
+		log.Printf("[TrackContext] %s unable request keyframe for %d ssrc. Err: %s", t.ID(), ssrc, err)
+	}
+}
+
+func (t *TrackContext) NewDownTrack() (*DownTrack, error) {
+	downTrack, err := NewDownTrack(t)
+	if err != nil {
+		return nil, err
+	}
+
+	t.downTracksMu.Lock()
+	t.downTracks = append(t.downTracks, downTrack)
+	t.downTracksMu.Unlock()
+	return downTrack, nil
+}
+
+func (t *TrackContext) RemoveDownTrack(downTrack *DownTrack) {
+	t.downTracksMu.Lock()
+	defer t.downTracksMu.Unlock()
+
+	for i, d := range t.downTracks {
+		if d == downTrack {
+			t.downTracks = append(t.downTracks[:i], t.downTracks[i+1:]...)
+			return
+		}
+	}
+}
+
+// Fan out packet of the layer to all subscribers. Each down track decide by itself forward it or not
+func (t *TrackContext) WriteDownTracks(rid string, pkt *rtp.Packet) error {
+	t.downTracksMu.RLock()
+	defer t.downTracksMu.RUnlock()
+
+	var errs []error
+	for _, downTrack := range t.downTracks {
+		if err := downTrack.WriteRTP(rid, pkt); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t *TrackContext) deactivateDownTracks() {
+	t.downTracksMu.RLock()
+	for _, downTrack := range t.downTracks {
+		downTrack.deactivate()
+	}
+	t.downTracksMu.RUnlock()
+
+	t.sinksMu.RLock()
+	for _, sink := range t.sinks {
+		sink.deactivate()
+	}
+	t.sinksMu.RUnlock()
+}
+
+// Attach server side consumer of the track. Sink is written from the rtp read loop, it must not block
+func (t *TrackContext) AddSink(sink TrackWriterRTP) *DownTrack {
+	downTrack := NewSinkDownTrack(t, sink)
+
+	t.sinksMu.Lock()
+	t.sinks = append(t.sinks, downTrack)
+	t.sinksMu.Unlock()
+
+	downTrack.RequestKeyframe()
+	return downTrack
+}
+
+func (t *TrackContext) RemoveSink(downTrack *DownTrack) {
+	t.sinksMu.Lock()
+	defer t.sinksMu.Unlock()
+
+	for i, d := range t.sinks {
+		if d == downTrack {
+			t.sinks = append(t.sinks[:i], t.sinks[i+1:]...)
+			return
+		}
+	}
+}
+
+func (t *TrackContext) WriteSinks(rid string, pkt *rtp.Packet) error {
+	t.sinksMu.RLock()
+	defer t.sinksMu.RUnlock()
+
+	var errs []error
+	for _, sink := range t.sinks {
+		if err := sink.WriteRTP(rid, pkt); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t *TrackContext) Kind() webrtc.RTPCodecType {
+	return t.codecKind
+}
+
+func (t *TrackContext) Codec() webrtc.RTPCodecParameters {
+	return t.codecParams
+}
+
+// Packets without filter are forwarded per subscriber, filtered media goes through the shared pipeline track
+func (t *TrackContext) UsesDownTracks() bool {
+	return t.Filter() == FILTER_NONE
+}
+
 func (t *TrackContext) Close() (err error) {
 	log.Println("[Close] track context close ID:", t.ID())
 	t.cancel()
@@ -308,6 +522,10 @@ func (t *TrackContext) SetFilter(filter *Filter) error {
 		return errors.New("filter mime type mismatch")
 	}
 
+	if t.IsSimulcast() && filter != FILTER_NONE {
+		return errors.New("unable set filter on simulcast track")
+	}
+
 	var media TrackWriter
 	var err error
 
@@ -401,10 +619,17 @@ func (t *TrackContext) SetFilter(filter *Filter) error {
 		track: t,
 	}))
 	t.mediaMu.Unlock()
+
+	if filter != FILTER_NONE {
+		// NOTE: Pipeline decoder can't start without a keyframe
+		t.RequestKeyframes()
+	}
 	return nil
 }
 
 func (t *TrackContext) Filter() *Filter {
+	t.mediaMu.Lock()
+	defer t.mediaMu.Unlock()
 	return t.filter
 }
 
@@ -500,6 +725,9 @@ func NewTrackContext(ctx context.Context, params NewTrackContextParams) *TrackCo
 		// track:  params.Track,
 		pipeAllocContext: params.PipeAllocContext,
 
+		layers:   make(map[string]*trackLayer),
+		metadata: newTrackMetadata(params),
+
 		observers: make([]chan TrackContextMessage[any], 0),
 		// filter: params.Filter,
 		ctx:    c,
@@ -508,6 +736,9 @@ func NewTrackContext(ctx context.Context, params NewTrackContextParams) *TrackCo
 		// sampleBus: make(chan *media.Sample, 10),
 	}
 
+	trackContext.AddLayer(params.RID, params.SSRC)
+	trackContext.touch()
+
 	if err := trackContext.SetFilter(params.Filter); err != nil {
 		log.Printf("TRACK | %s unable set filter. Err: %s", trackContext.id, err)
 	}
@@ -519,6 +750,145 @@ type ActiveTrackContext struct {
 	trackContext *TrackContext
 	sender       atomic.Pointer[webrtc.RTPSender]
 	transceiver  atomic.Pointer[webrtc.RTPTransceiver]
+	downTrack    *DownTrack
+
+	allocatedBitrate atomic.Int64
+
+	releaseOnce sync.Once
+	done        chan struct{}
+}
+
+// Local track which is bound to the subscriber sender
+func (a *ActiveTrackContext) GetLocalTrack() webrtc.TrackLocal {
+	if a.downTrack != nil && a.trackContext.UsesDownTracks() {
+		return a.downTrack.GetLocalTrack()
+	}
+	return a.trackContext.GetLocalTrack()
+}
+
+func (a *ActiveTrackContext) SetSimulcastLayer(rid string) error {
+	if a.downTrack == nil || !a.trackContext.IsSimulcast() {
+		return ErrTrackNotSimulcast
+	}
+	if !a.trackContext.HasLayer(rid) {
+		return ErrSimulcastLayerNotFound
+	}
+	a.downTrack.SetPreferredLayer(rid)
+	return nil
+}
+
+// Stop writing packets to the subscriber, transceiver and sdp stay untouched
+func (a *ActiveTrackContext) Pause() error {
+	if a.downTrack == nil || !a.trackContext.UsesDownTracks() {
+		return ErrTrackPauseUnsupported
+	}
+	a.downTrack.SetSubscriberPaused(true)
+	return nil
+}
+
+// Continue writing packets from the next keyframe
+func (a *ActiveTrackContext) Resume() error {
+	if a.downTrack == nil || !a.trackContext.UsesDownTracks() {
+		return ErrTrackPauseUnsupported
+	}
+	a.downTrack.SetSubscriberPaused(false)
+	return nil
+}
+
+func (a *ActiveTrackContext) Paused() bool {
+	return a.downTrack != nil && a.downTrack.SubscriberPaused()
+}
+
+func (a *ActiveTrackContext) RequestKeyframe() {
+	if a.downTrack != nil {
+		a.downTrack.RequestKeyframe()
+		return
+	}
+	a.trackContext.RequestKeyframes()
+}
+
+// Read RTCP of the subscriber sender. Keyframe requests are forwarded to the publisher through the track
+// context, NACKs are answered from the layer packet cache. It also drives interceptors like congestion controller
+func (a *ActiveTrackContext) ReadSenderRTCP(ctx context.Context, stats *rtpstats.RtpStats) {
+	for {
+		sender := a.LoadSender()
+		if sender == nil {
+			return
+		}
+
+		pkts, _, err := sender.ReadRTCP()
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-a.trackContext.Done():
+				return
+			default:
+			}
+
+			// NOTE: Sender may be replaced on media switch
+			if a.LoadSender() != sender {
+				continue
+			}
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) {
+				return
+			}
+			continue
+		}
+
+		for _, pkt := range pkts {
+			switch pkt := pkt.(type) {
+			case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+				a.RequestKeyframe()
+			case *rtcp.TransportLayerNack:
+				a.retransmit(pkt, stats)
+			}
+		}
+	}
+}
+
+func (a *ActiveTrackContext) retransmit(nack *rtcp.TransportLayerNack, stats *rtpstats.RtpStats) {
+	// NOTE: Filtered tracks are encoded by the pipeline, nothing to retransmit from
+	if a.downTrack == nil || !a.trackContext.UsesDownTracks() {
+		return
+	}
+
+	var seqs []uint16
+	for _, pair := range nack.Nacks {
+		seqs = append(seqs, pair.PacketList()...)
+	}
+
+	hits, misses := a.downTrack.Retransmit(seqs)
+	if stats != nil {
+		stats.RecordRetransmissions(webrtc.SSRC(nack.MediaSSRC), hits, misses)
+	}
+}
+
+func (a *ActiveTrackContext) DownTrack() *DownTrack {
+	return a.downTrack
+}
+
+// Share of subscriber estimated bitrate given to this track by bandwidth allocation
+func (a *ActiveTrackContext) AllocatedBitrate() int {
+	return int(a.allocatedBitrate.Load())
+}
+
+func (a *ActiveTrackContext) storeAllocatedBitrate(bitrate int) {
+	a.allocatedBitrate.Store(int64(bitrate))
+}
+
+func (a *ActiveTrackContext) release() {
+	a.releaseOnce.Do(func() {
+		if a.downTrack != nil {
+			a.trackContext.RemoveDownTrack(a.downTrack)
+		}
+		close(a.done)
+	})
+}
+
+// Closed when track removed from the subscriber
+func (a *ActiveTrackContext) Done() <-chan struct{} {
+	return a.done
 }
 
 func (a *ActiveTrackContext) LoadSender() *webrtc.RTPSender {
@@ -548,23 +918,23 @@ func (a *ActiveTrackContext) SwitchActiveTrackMedia(api *webrtc.API, pc *webrtc.
 		return ErrSwitchActiveTrackNotFoundTransiv
 	}
 
-	nextSender, err := api.NewRTPSender(a.trackContext.GetLocalTrack(), pc.SCTP().Transport())
+	nextSender, err := api.NewRTPSender(a.GetLocalTrack(), pc.SCTP().Transport())
 	if err != nil {
 		return err
 	}
 
 	_ = sender.Stop()
 
-	err = transiv.SetSender(nextSender, a.trackContext.GetLocalTrack())
+	err = transiv.SetSender(nextSender, a.GetLocalTrack())
 	log.Println("SetSender err:", err)
 
-	a.StoreSender(sender)
+	a.StoreSender(nextSender)
 
 	return nil
 }
 
-func NewActiveTrackContext(transiv *webrtc.RTPTransceiver, sender *webrtc.RTPSender, track *TrackContext) *ActiveTrackContext {
-	a := &ActiveTrackContext{trackContext: track}
+func NewActiveTrackContext(transiv *webrtc.RTPTransceiver, sender *webrtc.RTPSender, track *TrackContext, downTrack *DownTrack) *ActiveTrackContext {
+	a := &ActiveTrackContext{trackContext: track, downTrack: downTrack, done: make(chan struct{})}
 	a.StoreSender(sender)
 	a.StoreTransiver(transiv)
 	return a
@@ -574,6 +944,10 @@ type PublishTrackContext struct {
 	trackContext *TrackContext
 }
 
+func (p *PublishTrackContext) SetMuted(muted bool) bool {
+	return p.trackContext.SetMuted(muted)
+}
+
 func NewPublishTrackContext(t *TrackContext) *PublishTrackContext {
 	return &PublishTrackContext{
 		trackContext: t,
@@ -0,0 +1,209 @@
+package sfu
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	webrtc "github.com/pion/webrtc/v4"
+)
+
+type TrackSource string
+
+const (
+	TrackSourceUnknown          TrackSource = "unknown"
+	TrackSourceCamera           TrackSource = "camera"
+	TrackSourceMicrophone       TrackSource = "microphone"
+	TrackSourceScreenShare      TrackSource = "screen_share"
+	TrackSourceScreenShareAudio TrackSource = "screen_share_audio"
+)
+
+func (s TrackSource) Valid() bool {
+	switch s {
+	case TrackSourceUnknown, TrackSourceCamera, TrackSourceMicrophone, TrackSourceScreenShare, TrackSourceScreenShareAudio:
+		return true
+	default:
+		return false
+	}
+}
+
+// What the track is and who owns it. Source and label are told by the publisher, the rest is known by the sfu
+type TrackMetadata struct {
+	TrackID       string      `json:"trackId"`
+	ParticipantID string      `json:"participantId"`
+	StreamID      string      `json:"streamId"`
+	Kind          string      `json:"kind"`
+	Codec         string      `json:"codec"`
+	Source        TrackSource `json:"source"`
+	Label         string      `json:"label"`
+	Muted         bool        `json:"muted"`
+	// No packets from the publisher for a while, e.g. camera is stopped
+	Inactive bool `json:"inactive"`
+	Width    int  `json:"width"`
+	Height   int  `json:"height"`
+}
+
+// Publisher message. Empty fields are not changed
+type TrackMetadataMessage struct {
+	TrackID string      `json:"trackId"`
+	Source  TrackSource `json:"source"`
+	Label   string      `json:"label"`
+	Muted   *bool       `json:"muted"`
+	Width   int         `json:"width"`
+	Height  int         `json:"height"`
+}
+
+type MuteMessage struct {
+	TrackID string `json:"trackId"`
+	Muted   bool   `json:"muted"`
+}
+
+type TrackUnpublishedMessage struct {
+	TrackID       string `json:"trackId"`
+	ParticipantID string `json:"participantId"`
+}
+
+func newTrackMetadata(params NewTrackContextParams) TrackMetadata {
+	source := TrackSourceCamera
+	if params.Kind == webrtc.RTPCodecTypeAudio {
+		source = TrackSourceMicrophone
+	}
+
+	return TrackMetadata{
+		TrackID:       params.ID,
+		ParticipantID: params.SourcePeerID,
+		StreamID:      params.StreamID,
+		Kind:          params.Kind.String(),
+		Codec:         params.CodecParams.MimeType,
+		Source:        source,
+	}
+}
+
+func (t *TrackContext) Metadata() TrackMetadata {
+	t.metadataMu.Lock()
+	defer t.metadataMu.Unlock()
+	return t.metadata
+}
+
+// Returns true when metadata changed
+func (t *TrackContext) UpdateMetadata(f func(*TrackMetadata)) bool {
+	t.metadataMu.Lock()
+	defer t.metadataMu.Unlock()
+
+	metadata := t.metadata
+	f(&metadata)
+	if metadata == t.metadata {
+		return false
+	}
+	t.metadata = metadata
+	return true
+}
+
+func (t *TrackContext) ApplyMetadataMessage(msg TrackMetadataMessage) (bool, error) {
+	if msg.Source != "" && !msg.Source.Valid() {
+		return false, ErrTrackSourceUnknown
+	}
+
+	return t.UpdateMetadata(func(m *TrackMetadata) {
+		if msg.Source != "" {
+			m.Source = msg.Source
+		}
+		if msg.Label != "" {
+			m.Label = msg.Label
+		}
+		if msg.Width > 0 && msg.Height > 0 {
+			m.Width = msg.Width
+			m.Height = msg.Height
+		}
+	}), nil
+}
+
+// Muted track is not forwarded. On unmute subscribers continue from a keyframe
+func (t *TrackContext) SetMuted(muted bool) bool {
+	if t.muted.Swap(muted) != muted {
+		if muted {
+			t.deactivateDownTracks()
+		} else {
+			t.RequestKeyframes()
+		}
+	}
+
+	return t.UpdateMetadata(func(m *TrackMetadata) {
+		m.Muted = muted
+	})
+}
+
+func (t *TrackContext) Muted() bool {
+	return t.muted.Load()
+}
+
+const _TRACK_INACTIVE_TIMEOUT = 5 * time.Second
+
+func (t *TrackContext) touch() {
+	t.lastPacket.Store(time.Now().UnixNano())
+}
+
+// Returns true when activity changed
+func (t *TrackContext) observeActivity() bool {
+	inactive := time.Since(time.Unix(0, t.lastPacket.Load())) > _TRACK_INACTIVE_TIMEOUT
+	return t.UpdateMetadata(func(m *TrackMetadata) {
+		m.Inactive = inactive
+	})
+}
+
+// Read frame size of the layer from the keyframe. Only vp8 carries it in the rtp payload
+func (t *TrackContext) observeDimensions(layer *trackLayer, pkt *rtp.Packet) bool {
+	if !strings.EqualFold(t.codecParams.MimeType, webrtc.MimeTypeVP8) {
+		return false
+	}
+
+	width, height, ok := vp8Dimensions(pkt)
+	if !ok {
+		return false
+	}
+
+	layer.setDimensions(width, height)
+	return t.updateDimensions()
+}
+
+// Track is described by the largest layer which is sending. Returns true when dimensions changed
+// NOTE: Stopped track keeps the last size, it's reported as inactive
+func (t *TrackContext) updateDimensions() bool {
+	var width, height int
+	t.layersMu.Lock()
+	for _, layer := range t.layers {
+		if w, h := layer.Dimensions(); w*h > width*height {
+			width, height = w, h
+		}
+	}
+	t.layersMu.Unlock()
+
+	if width == 0 || height == 0 {
+		return false
+	}
+	return t.UpdateMetadata(func(m *TrackMetadata) {
+		m.Width = width
+		m.Height = height
+	})
+}
+
+func vp8Dimensions(pkt *rtp.Packet) (int, int, bool) {
+	var vp8 codecs.VP8Packet
+	if _, err := vp8.Unmarshal(pkt.Payload); err != nil {
+		return 0, 0, false
+	}
+	if vp8.S != 1 || vp8.PID != 0 || len(vp8.Payload) < 10 {
+		return 0, 0, false
+	}
+
+	frame := vp8.Payload
+	// NOTE: Key frame has zero P bit and start code after the frame tag
+	if frame[0]&0x01 != 0 || frame[3] != 0x9d || frame[4] != 0x01 || frame[5] != 0x2a {
+		return 0, 0, false
+	}
+
+	width := int(frame[6]) | int(frame[7]&0x3f)<<8
+	height := int(frame[8]) | int(frame[9]&0x3f)<<8
+	return width, height, true
+}
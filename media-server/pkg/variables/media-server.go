@@ -15,6 +15,16 @@ const (
 
 	WEBRTC_UDP_PORT_DEFAULT = "3478"
 	WEBRTC_UDP_PORT         = "WEBRTC_UDP_PORT"
+
+	RECORDINGS_DIR_DEFAULT = "recordings"
+	RECORDINGS_DIR         = "RECORDINGS_DIR"
+
+	HLS_DIR_DEFAULT = "hls"
+	HLS_DIR         = "HLS_DIR"
+
+	// NOTE: Comma separated usernames, their access token has the admin claim
+	ADMIN_USERS_DEFAULT = ""
+	ADMIN_USERS         = "ADMIN_USERS"
 )
 
 func ParseInt(value string) (int, error) {
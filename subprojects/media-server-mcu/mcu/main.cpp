@@ -76,3 +76,111 @@ extern "C" void MCU_gst_elem_set_structure(GstElement *elem,
   g_object_set(G_OBJECT(elem), p_name, p_value, NULL);
 }
 
+extern "C" GstPad *MCU_gst_element_request_pad(GstElement *elem,
+                                               const gchar *name) {
+  return gst_element_request_pad_simple(elem, name);
+}
+
+extern "C" void MCU_gst_element_release_pad(GstElement *elem, GstPad *pad) {
+  gst_element_release_request_pad(elem, pad);
+  gst_object_unref(pad);
+}
+
+extern "C" gboolean MCU_gst_element_link_pad(GstElement *src,
+                                             GstPad *sink_pad) {
+  auto *src_pad = gst_element_get_static_pad(src, "src");
+  if (!src_pad)
+    return FALSE;
+
+  auto ret = gst_pad_link(src_pad, sink_pad);
+  gst_object_unref(src_pad);
+  return ret == GST_PAD_LINK_OK;
+}
+
+extern "C" void MCU_gst_element_unlink_pad(GstElement *src, GstPad *sink_pad) {
+  auto *src_pad = gst_element_get_static_pad(src, "src");
+  if (!src_pad)
+    return;
+
+  gst_pad_unlink(src_pad, sink_pad);
+  gst_object_unref(src_pad);
+}
+
+extern "C" void MCU_gst_pad_set_int(GstPad *pad, const gchar *p_name,
+                                    gint p_value) {
+  g_object_set(G_OBJECT(pad), p_name, p_value, NULL);
+}
+
+static void MCU_on_pad_added(GstElement *src, GstPad *pad, gpointer data) {
+  auto *sink_pad = gst_element_get_static_pad(GST_ELEMENT(data), "sink");
+  if (!sink_pad)
+    return;
+
+  // NOTE: Pad goes to the first sink which accepts the caps
+  if (!gst_pad_is_linked(pad) && !gst_pad_is_linked(sink_pad)) {
+    auto *caps = gst_pad_query_caps(pad, NULL);
+    auto *sink_caps = gst_pad_query_caps(sink_pad, NULL);
+    if (gst_caps_can_intersect(caps, sink_caps))
+      gst_pad_link(pad, sink_pad);
+    gst_caps_unref(caps);
+    gst_caps_unref(sink_caps);
+  }
+  gst_object_unref(sink_pad);
+}
+
+extern "C" void MCU_gst_element_link_dynamic(GstElement *src,
+                                             GstElement *sink) {
+  g_signal_connect(src, "pad-added", G_CALLBACK(MCU_on_pad_added), sink);
+}
+
+extern "C" gboolean MCU_gst_element_sync_state(GstElement *elem) {
+  return gst_element_sync_state_with_parent(elem);
+}
+
+extern "C" void MCU_gst_bin_remove(GstElement *p, GstElement *element) {
+  gst_bin_remove(GST_BIN(p), element);
+}
+
+extern "C" gboolean MCU_gst_element_send_eos(GstElement *elem) {
+  return gst_element_send_event(elem, gst_event_new_eos());
+}
+
+// Returns FALSE on error or when eos is not reached in time
+extern "C" gboolean MCU_gst_pipeline_wait_eos(GstElement *pipe,
+                                              GstClockTime timeout) {
+  auto *bus = gst_element_get_bus(pipe);
+  auto *msg = gst_bus_timed_pop_filtered(
+      bus, timeout, (GstMessageType)(GST_MESSAGE_EOS | GST_MESSAGE_ERROR));
+  gst_object_unref(bus);
+
+  if (!msg)
+    return FALSE;
+
+  gboolean eos = GST_MESSAGE_TYPE(msg) == GST_MESSAGE_EOS;
+  gst_message_unref(msg);
+  return eos;
+}
+
+// Returns 0 on timeout, 1 on eos and 2 on error. Error message must be freed
+extern "C" gint MCU_gst_pipeline_pop_message(GstElement *pipe,
+                                             GstClockTime timeout,
+                                             gchar **error_message) {
+  auto *bus = gst_element_get_bus(pipe);
+  auto *msg = gst_bus_timed_pop_filtered(
+      bus, timeout, (GstMessageType)(GST_MESSAGE_EOS | GST_MESSAGE_ERROR));
+  gst_object_unref(bus);
+
+  if (!msg)
+    return 0;
+
+  gint result = 1;
+  if (GST_MESSAGE_TYPE(msg) == GST_MESSAGE_ERROR) {
+    GError *err = NULL;
+    gst_message_parse_error(msg, &err, NULL);
+    *error_message = g_strdup(err->message);
+    g_error_free(err);
+    result = 2;
+  }
+  gst_message_unref(msg);
+  return result;
+}
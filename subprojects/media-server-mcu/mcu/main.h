@@ -27,6 +27,20 @@ void MCU_gst_elem_set_caps(GstElement *elem, const gchar *p_name,
 void MCU_gst_elem_set_structure(GstElement *elem, const gchar *p_name,
                                 const GstStructure *p_value);
 
+GstPad *MCU_gst_element_request_pad(GstElement *elem, const gchar *name);
+void MCU_gst_element_release_pad(GstElement *elem, GstPad *pad);
+gboolean MCU_gst_element_link_pad(GstElement *src, GstPad *sink_pad);
+void MCU_gst_element_unlink_pad(GstElement *src, GstPad *sink_pad);
+void MCU_gst_pad_set_int(GstPad *pad, const gchar *p_name, gint p_value);
+void MCU_gst_element_link_dynamic(GstElement *src, GstElement *sink);
+
+gboolean MCU_gst_element_sync_state(GstElement *elem);
+void MCU_gst_bin_remove(GstElement *p, GstElement *element);
+gboolean MCU_gst_element_send_eos(GstElement *elem);
+gboolean MCU_gst_pipeline_wait_eos(GstElement *pipe, GstClockTime timeout);
+gint MCU_gst_pipeline_pop_message(GstElement *pipe, GstClockTime timeout,
+                                  gchar **error_message);
+
 #ifdef __cplusplus
 }
 #endif